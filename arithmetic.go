@@ -0,0 +1,121 @@
+package squeel
+
+import (
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+arithmeticOperators maps the SQL arithmetic operators onto the aggregation
+operators that compute them.
+*/
+var arithmeticOperators = map[string]string{
+	sqlparser.PlusStr:  "$add",
+	sqlparser.MinusStr: "$subtract",
+	sqlparser.MultStr:  "$multiply",
+	sqlparser.DivStr:   "$divide",
+	sqlparser.ModStr:   "$mod",
+}
+
+/*
+isArithmetic reports whether an expression computes a value with arithmetic,
+as in price * qty or -balance, so it can only be evaluated as an aggregation
+expression.
+
+Parameters:
+- expr: The expression to check
+
+Returns:
+- Whether the expression is arithmetic
+*/
+func isArithmetic(expr sqlparser.Expr) bool {
+	switch expr := expr.(type) {
+	case *sqlparser.BinaryExpr, *sqlparser.UnaryExpr:
+		return true
+	case *sqlparser.ParenExpr:
+		return isArithmetic(expr.Expr)
+	}
+	return false
+}
+
+/*
+binaryExpression compiles a binary arithmetic expression. Chains of the
+same associative operator, as in a + b + c, become a single $add or
+$multiply with every operand. Adding or subtracting an INTERVAL, as in
+created_at + INTERVAL 1 DAY, is DATE_ADD or DATE_SUB.
+
+Parameters:
+- expr: The binary expression to compile
+
+Returns:
+- The aggregation expression
+- Whether the expression could be compiled
+*/
+func (statement *Statement) binaryExpression(expr *sqlparser.BinaryExpr) (interface{}, bool) {
+	if _, ok := expr.Right.(*sqlparser.IntervalExpr); ok {
+		switch expr.Operator {
+		case sqlparser.PlusStr:
+			return statement.callFunction(expr, "date_add", []sqlparser.Expr{expr.Left, expr.Right})
+		case sqlparser.MinusStr:
+			return statement.callFunction(expr, "date_sub", []sqlparser.Expr{expr.Left, expr.Right})
+		}
+	}
+
+	operator, ok := arithmeticOperators[expr.Operator]
+	if !ok {
+		return nil, false
+	}
+
+	left, ok := statement.expression(expr.Left)
+	if !ok {
+		return nil, false
+	}
+
+	right, ok := statement.expression(expr.Right)
+	if !ok {
+		return nil, false
+	}
+
+	if operator == "$add" || operator == "$multiply" {
+		if chain, ok := left.(bson.M); ok && len(chain) == 1 {
+			if operands, ok := chain[operator].(bson.A); ok {
+				return bson.M{operator: append(operands, right)}, true
+			}
+		}
+	}
+
+	return bson.M{operator: bson.A{left, right}}, true
+}
+
+/*
+unaryExpression compiles a unary plus or minus. Negating a number literal
+gives the negative number; negating anything else multiplies it by -1.
+
+Parameters:
+- expr: The unary expression to compile
+
+Returns:
+- The aggregation expression
+- Whether the expression could be compiled
+*/
+func (statement *Statement) unaryExpression(expr *sqlparser.UnaryExpr) (interface{}, bool) {
+	operand, ok := statement.expression(expr.Expr)
+	if !ok {
+		return nil, false
+	}
+
+	switch expr.Operator {
+	case sqlparser.UPlusStr:
+		return operand, true
+	case sqlparser.UMinusStr:
+		switch number := operand.(type) {
+		case int64:
+			return -number, true
+		case float64:
+			return -number, true
+		}
+		return bson.M{"$multiply": bson.A{int64(-1), operand}}, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,97 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestArithmetic(t *testing.T) {
+	Convey("Given SQL with arithmetic expressions", t, func() {
+		evaluator := newEvaluator(map[string][]interface{}{
+			"items": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "price", Value: 10}, {Key: "qty", Value: 3}, {Key: "discount", Value: 5}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "price", Value: 100}, {Key: "qty", Value: 1}, {Key: "discount", Value: 0}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "price", Value: 4}, {Key: "qty", Value: 5}},
+			},
+		})
+
+		Convey("It should compile the operators into aggregation expressions", func() {
+			q := build("SELECT _id FROM items WHERE (price + qty + 1) * 2 - discount / 5 % 3 > 0 AND -price < -5")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "$expr", Value: bson.M{"$and": bson.A{
+				bson.M{"$gt": bson.A{
+					bson.M{"$subtract": bson.A{
+						bson.M{"$multiply": bson.A{bson.M{"$add": bson.A{"$price", "$qty", int64(1)}}, int64(2)}},
+						bson.M{"$mod": bson.A{bson.M{"$divide": bson.A{"$discount", int64(5)}}, int64(3)}},
+					}},
+					int64(0),
+				}},
+				bson.M{"$lt": bson.A{bson.M{"$multiply": bson.A{int64(-1), "$price"}}, int64(-5)}},
+			}}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}, {"_id": int32(2)}})
+		})
+
+		Convey("It should filter on a computed value with $expr", func() {
+			q := build("SELECT _id FROM items WHERE price * 1.21 > 100")
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "$expr", Value: bson.M{"$gt": bson.A{bson.M{"$multiply": bson.A{"$price", 1.21}}, int64(100)}}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2)}})
+		})
+
+		Convey("It should compare a computed range with BETWEEN", func() {
+			q := build("SELECT _id FROM items WHERE price * qty BETWEEN 20 AND 30")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}, {"_id": int32(3)}})
+
+			q = build("SELECT _id FROM items WHERE price * qty NOT BETWEEN 20 AND 30")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2)}})
+		})
+
+		Convey("It should require the columns of a computed value to be set with SQL nulls", func() {
+			q := build("SELECT _id FROM items WHERE price - discount < 50", WithNullSemantics(SQLNulls))
+			So(q.Filter, ShouldResemble, bson.D{{Key: "$expr", Value: bson.M{"$and": bson.A{
+				bson.M{"$gt": bson.A{"$price", nil}},
+				bson.M{"$gt": bson.A{"$discount", nil}},
+				bson.M{"$lt": bson.A{bson.M{"$subtract": bson.A{"$price", "$discount"}}, int64(50)}},
+			}}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}})
+		})
+
+		Convey("It should project computed columns", func() {
+			q := build("SELECT _id, price * qty AS total, -qty FROM items WHERE _id = 1")
+			So(q.Operation, ShouldEqual, "aggregate")
+			So(q.Projection, ShouldResemble, bson.D{
+				{Key: "_id", Value: 1},
+				{Key: "total", Value: bson.M{"$multiply": bson.A{"$price", "$qty"}}},
+				{Key: "-qty", Value: bson.M{"$multiply": bson.A{int64(-1), "$qty"}}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1), "total": int64(30), "-qty": int64(-3)}})
+		})
+
+		Convey("It should aggregate computed values", func() {
+			q := build("SELECT SUM(price * qty) AS revenue FROM items")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"revenue": int32(150)}})
+		})
+
+		Convey("It should sort on a computed key and remove it afterwards", func() {
+			q := build("SELECT _id FROM items ORDER BY price * qty DESC")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$addFields", "$project", "$sort", "$unset"})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2)}, {"_id": int32(1)}, {"_id": int32(3)}})
+
+			q = build("SELECT * FROM items ORDER BY qty - price LIMIT 1")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2), "price": int32(100), "qty": int32(1), "discount": int32(0)}})
+		})
+
+		Convey("It should reject arithmetic it cannot translate", func() {
+			for _, sql := range []string{
+				"SELECT _id FROM items WHERE price & 1 = 1",
+				"SELECT o.price * 2 FROM items o",
+				"SELECT qty FROM items GROUP BY qty ORDER BY qty * 2",
+			} {
+				_, err := NewStatement(sql).Build(NewQuery())
+				So(err, ShouldHaveSameTypeAs, &UnsupportedError{})
+			}
+		})
+	})
+}
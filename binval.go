@@ -133,3 +133,41 @@ func CSUUID(uuid string) (primitive.Binary, error) {
 
 	return makeLegacyBinVal(string(HexToBase64(reordered)))
 }
+
+/*
+Base64ToHex is the inverse of HexToBase64. It converts a base64 encoded
+
+string to its hexadecimal representation, returning an error if the input
+is not valid base64.
+*/
+func Base64ToHex(in string) (string, error) {
+	rawBytes, err := b64.StdEncoding.DecodeString(strings.ReplaceAll(in, " ", "+"))
+	if err != nil {
+		return "", fmt.Errorf("decoding base64: %w", err)
+	}
+
+	return hex.EncodeToString(rawBytes), nil
+}
+
+/*
+UUIDFromCSUUID is the inverse of CSUUID. It converts a MongoDB Binary value
+
+back to its canonical UUID string. Subtype 3 values are reordered back from
+the legacy byte order, while subtype 4 values are already in standard order.
+*/
+func UUIDFromCSUUID(binval primitive.Binary) (string, error) {
+	if (binval.Subtype != 3 && binval.Subtype != 4) || len(binval.Data) != 16 {
+		return "", fmt.Errorf("binary value is not a UUID: subtype %d, %d bytes", binval.Subtype, len(binval.Data))
+	}
+
+	raw := hex.EncodeToString(binval.Data)
+
+	if binval.Subtype == 3 {
+		raw = raw[6:8] + raw[4:6] + raw[2:4] + raw[0:2] +
+			raw[10:12] + raw[8:10] +
+			raw[14:16] + raw[12:14] +
+			raw[16:]
+	}
+
+	return raw[0:8] + "-" + raw[8:12] + "-" + raw[12:16] + "-" + raw[16:20] + "-" + raw[20:], nil
+}
@@ -0,0 +1,211 @@
+package squeel
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+parseAnd processes a conjunction, flattening nested ANDs and parentheses, and
+merges the filter of every operand into the filter of the query, so that
+conditions on the same field end up in a single document.
+
+Parameters:
+- q: The Query object to modify
+- expr: The conjunction to process
+
+Returns:
+- The modified Query object with every operand applied
+*/
+func (statement *Statement) parseAnd(q *Query, expr *sqlparser.AndExpr) *Query {
+	for _, operand := range splitExpr(expr, true) {
+		for _, element := range statement.parseSubExpr(q, operand) {
+			q.Filter = mergeFilter(q.Filter, element)
+		}
+	}
+	return q
+}
+
+/*
+parseOr processes a disjunction, flattening nested ORs and parentheses into a
+single $or. Every operand is compiled into a filter of its own, so operands
+with several conditions on the same field keep all of them.
+
+Parameters:
+- q: The Query object to modify
+- expr: The disjunction to process
+
+Returns:
+- The modified Query object with the $or applied
+*/
+func (statement *Statement) parseOr(q *Query, expr *sqlparser.OrExpr) *Query {
+	disjuncts := bson.A{}
+
+	for _, operand := range splitExpr(expr, false) {
+		filter := statement.parseSubExpr(q, operand)
+		if len(filter) == 0 {
+			// An operand that was left out matches every document, and so does the OR
+			return q
+		}
+
+		if len(filter) == 1 && filter[0].Key == "$or" {
+			disjuncts = append(disjuncts, filter[0].Value.(bson.A)...)
+			continue
+		}
+
+		disjuncts = append(disjuncts, filter)
+	}
+
+	q.Filter = mergeFilter(q.Filter, bson.E{Key: "$or", Value: disjuncts})
+	return q
+}
+
+/*
+mergeFilter adds a condition to a filter whose conditions must all hold. A
+condition on a field that is already filtered is merged into one operator
+document when the operators differ, as in {$gt: 18, $lt: 65}, and otherwise
+added to $and, so no key appears twice. NOR conditions are combined into one
+$nor, and $expr conditions into one $expr.
+
+Parameters:
+- filter: The filter to add the condition to
+- element: The condition to add
+
+Returns:
+- The filter with the condition added
+*/
+func mergeFilter(filter bson.D, element bson.E) bson.D {
+	switch element.Key {
+	case "$expr":
+		return appendExpr(filter, element.Value)
+	case "$and":
+		for _, operand := range element.Value.(bson.A) {
+			for _, nested := range operand.(bson.D) {
+				filter = mergeFilter(filter, nested)
+			}
+		}
+		return filter
+	}
+
+	for i, existing := range filter {
+		if existing.Key != element.Key {
+			continue
+		}
+
+		if element.Key == "$nor" {
+			filter[i].Value = append(append(bson.A{}, existing.Value.(bson.A)...), element.Value.(bson.A)...)
+			return filter
+		}
+
+		if merged, ok := mergeOperators(existing.Value, element.Value); ok {
+			filter[i].Value = merged
+			return filter
+		}
+
+		return appendAnd(filter, bson.D{element})
+	}
+
+	return append(filter, element)
+}
+
+/*
+appendAnd adds a filter to the $and of a filter, creating the $and if there
+is none yet.
+
+Parameters:
+- filter: The filter to add to
+- operand: The filter that must hold as well
+
+Returns:
+- The filter with the operand added
+*/
+func appendAnd(filter bson.D, operand bson.D) bson.D {
+	for i, existing := range filter {
+		if existing.Key == "$and" {
+			filter[i].Value = append(existing.Value.(bson.A), operand)
+			return filter
+		}
+	}
+	return append(filter, bson.E{Key: "$and", Value: bson.A{operand}})
+}
+
+/*
+mergeOperators combines two operator documents on the same field into one,
+keeping the order in which the operators were written. Documents that share
+an operator cannot be combined.
+
+Parameters:
+- a: The existing condition on the field
+- b: The condition to add
+
+Returns:
+- The combined operator document
+- Whether the conditions could be combined
+*/
+func mergeOperators(a, b interface{}) (bson.D, bool) {
+	left, ok := operatorDocument(a)
+	if !ok {
+		return nil, false
+	}
+
+	right, ok := operatorDocument(b)
+	if !ok {
+		return nil, false
+	}
+
+	for _, operator := range right {
+		if hasKey(left, operator.Key) {
+			return nil, false
+		}
+	}
+
+	return append(append(bson.D{}, left...), right...), true
+}
+
+/*
+operatorDocument returns a field condition as an ordered operator document,
+such as {$gt: 18}. Conditions that compare with a value directly are not
+operator documents.
+
+Parameters:
+- value: The condition on a field
+
+Returns:
+- The operators of the condition, in a deterministic order
+- Whether the condition is an operator document
+*/
+func operatorDocument(value interface{}) (bson.D, bool) {
+	var document bson.D
+
+	switch value := value.(type) {
+	case bson.D:
+		document = value
+	case bson.M:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			document = append(document, bson.E{Key: key, Value: value[key]})
+		}
+	default:
+		return nil, false
+	}
+
+	if len(document) == 0 {
+		return nil, false
+	}
+
+	for _, operator := range document {
+		if !strings.HasPrefix(operator.Key, "$") {
+			return nil, false
+		}
+	}
+
+	return document, true
+}
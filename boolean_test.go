@@ -0,0 +1,101 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBooleanFilter(t *testing.T) {
+	Convey("Given SQL with AND, OR and parentheses", t, func() {
+		Convey("It should keep every condition of an OR operand", func() {
+			So(build("SELECT * FROM users WHERE (age > 18 AND age < 65) OR vip = 1").Filter, ShouldResemble, bson.D{
+				{Key: "$or", Value: bson.A{
+					bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: int64(18)}, {Key: "$lt", Value: int64(65)}}}},
+					bson.D{{Key: "vip", Value: int64(1)}},
+				}},
+			})
+		})
+
+		Convey("It should flatten nested operators of the same kind", func() {
+			So(build("SELECT * FROM t WHERE a = 1 OR (b = 2 OR (c = 3)) OR d NOT BETWEEN 1 AND 2").Filter, ShouldResemble, bson.D{
+				{Key: "$or", Value: bson.A{
+					bson.D{{Key: "a", Value: int64(1)}},
+					bson.D{{Key: "b", Value: int64(2)}},
+					bson.D{{Key: "c", Value: int64(3)}},
+					bson.D{{Key: "d", Value: bson.M{"$lt": int64(1)}}},
+					bson.D{{Key: "d", Value: bson.M{"$gt": int64(2)}}},
+				}},
+			})
+
+			So(build("SELECT * FROM t WHERE a = 1 AND (b = 2 AND (c = 3 AND d = 4))").Filter, ShouldResemble, bson.D{
+				{Key: "a", Value: int64(1)},
+				{Key: "b", Value: int64(2)},
+				{Key: "c", Value: int64(3)},
+				{Key: "d", Value: int64(4)},
+			})
+		})
+
+		Convey("It should merge range predicates on one field", func() {
+			So(build("SELECT * FROM t WHERE a >= 1 AND b = 2 AND a < 5 AND a != 3").Filter, ShouldResemble, bson.D{
+				{Key: "a", Value: bson.D{{Key: "$gte", Value: int64(1)}, {Key: "$lt", Value: int64(5)}, {Key: "$ne", Value: int64(3)}}},
+				{Key: "b", Value: int64(2)},
+			})
+		})
+
+		Convey("It should move conditions that cannot be merged into $and", func() {
+			So(build("SELECT * FROM t WHERE a = 1 AND a > 0 AND a > 2 AND (b = 1 OR c = 1) AND (b = 2 OR c = 2)").Filter, ShouldResemble, bson.D{
+				{Key: "a", Value: int64(1)},
+				{Key: "$and", Value: bson.A{
+					bson.D{{Key: "a", Value: bson.M{"$gt": int64(0)}}},
+					bson.D{{Key: "a", Value: bson.M{"$gt": int64(2)}}},
+					bson.D{{Key: "$or", Value: bson.A{
+						bson.D{{Key: "b", Value: int64(2)}},
+						bson.D{{Key: "c", Value: int64(2)}},
+					}}},
+				}},
+				{Key: "$or", Value: bson.A{
+					bson.D{{Key: "b", Value: int64(1)}},
+					bson.D{{Key: "c", Value: int64(1)}},
+				}},
+			})
+		})
+
+		Convey("It should combine negations into a single $nor", func() {
+			So(build("SELECT * FROM t WHERE NOT a = 1 AND b = 2 AND NOT (c = 3 OR d = 4)").Filter, ShouldResemble, bson.D{
+				{Key: "$nor", Value: bson.A{
+					bson.D{{Key: "a", Value: int64(1)}},
+					bson.D{{Key: "$or", Value: bson.A{
+						bson.D{{Key: "c", Value: int64(3)}},
+						bson.D{{Key: "d", Value: int64(4)}},
+					}}},
+				}},
+				{Key: "b", Value: int64(2)},
+			})
+		})
+
+		Convey("It should produce the same document on every build", func() {
+			sql := "SELECT * FROM t WHERE (a > 1 AND a < 9 AND b LIKE 'x%') OR (c BETWEEN 1 AND 2 AND c != 5)"
+			expected, err := bson.Marshal(build(sql).Filter)
+			So(err, ShouldBeNil)
+
+			for i := 0; i < 20; i++ {
+				actual, err := bson.Marshal(build(sql).Filter)
+				So(err, ShouldBeNil)
+				So(actual, ShouldResemble, expected)
+			}
+		})
+
+		Convey("It should match the documents the SQL selects", func() {
+			evaluator := newEvaluator(evaluatorFixtures)
+
+			q, err := NewStatement("SELECT name FROM users WHERE name = 'ann' OR (name > 'b' AND name < 'c') ORDER BY name").Build(NewQuery())
+			So(err, ShouldBeNil)
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(1), "name": "ann"},
+				{"_id": int32(2), "name": "bob"},
+			})
+		})
+	})
+}
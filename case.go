@@ -0,0 +1,222 @@
+package squeel
+
+import (
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+caseExpression compiles a CASE expression. A searched CASE tests the
+condition of every WHEN, and a simple CASE, as in CASE status WHEN 1 THEN
+..., compares its value with the value of every WHEN. A single WHEN becomes
+$cond and more become $switch, with the ELSE value, or NULL without one, as
+the default.
+
+Parameters:
+- expr: The CASE expression to compile
+
+Returns:
+- The aggregation expression
+- Whether the expression could be compiled
+*/
+func (statement *Statement) caseExpression(expr *sqlparser.CaseExpr) (interface{}, bool) {
+	var value interface{}
+	if expr.Expr != nil {
+		var ok bool
+		if value, ok = statement.expression(expr.Expr); !ok {
+			return nil, false
+		}
+	}
+
+	branches := make(bson.A, 0, len(expr.Whens))
+	for _, when := range expr.Whens {
+		var condition interface{}
+		var ok bool
+		if expr.Expr != nil {
+			var compared interface{}
+			compared, ok = statement.expression(when.Cond)
+			condition = bson.M{"$eq": bson.A{value, compared}}
+		} else {
+			condition, ok = statement.predicate(when.Cond)
+		}
+		if !ok {
+			return nil, false
+		}
+
+		then, ok := statement.expression(when.Val)
+		if !ok {
+			return nil, false
+		}
+
+		branches = append(branches, bson.D{{Key: "case", Value: condition}, {Key: "then", Value: then}})
+	}
+
+	var otherwise interface{}
+	if expr.Else != nil {
+		var ok bool
+		if otherwise, ok = statement.expression(expr.Else); !ok {
+			return nil, false
+		}
+	}
+
+	if len(branches) == 1 {
+		branch := branches[0].(bson.D)
+		return bson.M{"$cond": bson.A{branch[0].Value, branch[1].Value, otherwise}}, true
+	}
+
+	return bson.M{"$switch": bson.D{{Key: "branches", Value: branches}, {Key: "default", Value: otherwise}}}, true
+}
+
+/*
+predicate compiles a condition into a boolean aggregation expression, for
+the WHEN of a CASE. Comparisons, IN, BETWEEN, IS and the logical operators
+are compiled into their aggregation operators; any other expression is
+tested for truthiness.
+
+Parameters:
+- expr: The condition to compile
+
+Returns:
+- The aggregation expression
+- Whether the condition could be compiled
+*/
+func (statement *Statement) predicate(expr sqlparser.Expr) (interface{}, bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		return statement.logicalPredicate("$and", expr.Left, expr.Right)
+	case *sqlparser.OrExpr:
+		return statement.logicalPredicate("$or", expr.Left, expr.Right)
+	case *sqlparser.NotExpr:
+		condition, ok := statement.predicate(expr.Expr)
+		return bson.M{"$not": bson.A{condition}}, ok
+	case *sqlparser.ParenExpr:
+		return statement.predicate(expr.Expr)
+	case *sqlparser.RangeCond:
+		return statement.predicate(splitRange(expr))
+	case *sqlparser.ComparisonExpr:
+		return statement.comparisonPredicate(expr)
+	case *sqlparser.IsExpr:
+		return statement.isPredicate(expr)
+	}
+
+	return statement.expression(expr)
+}
+
+/*
+logicalPredicate compiles the operands of AND or OR into a single $and or
+$or, flattening nested operands of the same operator.
+
+Parameters:
+- operator: The aggregation operator, $and or $or
+- operands: The operands to compile
+
+Returns:
+- The aggregation expression
+- Whether every operand could be compiled
+*/
+func (statement *Statement) logicalPredicate(operator string, operands ...sqlparser.Expr) (interface{}, bool) {
+	conditions := make(bson.A, 0, len(operands))
+	for _, operand := range operands {
+		condition, ok := statement.predicate(operand)
+		if !ok {
+			return nil, false
+		}
+
+		if nested, ok := condition.(bson.M); ok && len(nested) == 1 {
+			if flattened, ok := nested[operator].(bson.A); ok {
+				conditions = append(conditions, flattened...)
+				continue
+			}
+		}
+		conditions = append(conditions, condition)
+	}
+
+	return bson.M{operator: conditions}, true
+}
+
+/*
+comparisonPredicate compiles a comparison, or an IN or NOT IN list, into a
+boolean aggregation expression.
+
+Parameters:
+- expr: The comparison to compile
+
+Returns:
+- The aggregation expression
+- Whether the comparison could be compiled
+*/
+func (statement *Statement) comparisonPredicate(expr *sqlparser.ComparisonExpr) (interface{}, bool) {
+	left, ok := statement.comparisonOperand(expr.Left, expr.Right)
+	if !ok {
+		return nil, false
+	}
+
+	switch expr.Operator {
+	case sqlparser.InStr, sqlparser.NotInStr:
+		tuple, ok := expr.Right.(sqlparser.ValTuple)
+		if !ok {
+			return nil, false
+		}
+
+		values := make(bson.A, 0, len(tuple))
+		for _, element := range tuple {
+			value, ok := statement.comparisonOperand(element, expr.Left)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, value)
+		}
+
+		condition := bson.M{"$in": bson.A{left, values}}
+		if expr.Operator == sqlparser.NotInStr {
+			return bson.M{"$not": bson.A{condition}}, true
+		}
+		return condition, true
+	}
+
+	if !isValidOperator(expr.Operator) {
+		return nil, false
+	}
+
+	right, ok := statement.comparisonOperand(expr.Right, expr.Left)
+	if !ok {
+		return nil, false
+	}
+
+	return bson.M{mongoOperator(expr.Operator): bson.A{left, right}}, true
+}
+
+/*
+isPredicate compiles IS NULL, IS TRUE and IS FALSE and their negations into
+a boolean aggregation expression. A missing field is NULL.
+
+Parameters:
+- expr: The IS expression to compile
+
+Returns:
+- The aggregation expression
+- Whether the expression could be compiled
+*/
+func (statement *Statement) isPredicate(expr *sqlparser.IsExpr) (interface{}, bool) {
+	value, ok := statement.expression(expr.Expr)
+	if !ok {
+		return nil, false
+	}
+
+	switch expr.Operator {
+	case sqlparser.IsNullStr:
+		return bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{value, nil}}, nil}}, true
+	case sqlparser.IsNotNullStr:
+		return bson.M{"$ne": bson.A{bson.M{"$ifNull": bson.A{value, nil}}, nil}}, true
+	case sqlparser.IsTrueStr:
+		return bson.M{"$eq": bson.A{value, true}}, true
+	case sqlparser.IsNotTrueStr:
+		return bson.M{"$ne": bson.A{value, true}}, true
+	case sqlparser.IsFalseStr:
+		return bson.M{"$eq": bson.A{value, false}}, true
+	case sqlparser.IsNotFalseStr:
+		return bson.M{"$ne": bson.A{value, false}}, true
+	}
+
+	return nil, false
+}
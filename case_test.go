@@ -0,0 +1,120 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCase(t *testing.T) {
+	Convey("Given SQL with CASE expressions", t, func() {
+		evaluator := newEvaluator(map[string][]interface{}{
+			"accounts": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "status", Value: 1}, {Key: "plan", Value: "pro"}, {Key: "total", Value: 30}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "status", Value: 2}, {Key: "plan", Value: "free"}, {Key: "total", Value: 0}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "status", Value: 1}, {Key: "plan", Value: "free"}, {Key: "total", Value: 10}},
+				bson.D{{Key: "_id", Value: 4}, {Key: "status", Value: 9}, {Key: "total", Value: 5}},
+			},
+		})
+
+		label := bson.M{"$switch": bson.D{
+			{Key: "branches", Value: bson.A{
+				bson.D{{Key: "case", Value: bson.M{"$eq": bson.A{"$status", int64(1)}}}, {Key: "then", Value: "active"}},
+				bson.D{{Key: "case", Value: bson.M{"$eq": bson.A{"$status", int64(2)}}}, {Key: "then", Value: "blocked"}},
+			}},
+			{Key: "default", Value: "other"},
+		}}
+
+		Convey("It should project a searched CASE with $switch", func() {
+			q := build("SELECT _id, CASE WHEN status = 1 THEN 'active' WHEN status = 2 THEN 'blocked' ELSE 'other' END AS label FROM accounts")
+			So(q.Projection, ShouldResemble, bson.D{{Key: "_id", Value: 1}, {Key: "label", Value: label}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(1), "label": "active"},
+				{"_id": int32(2), "label": "blocked"},
+				{"_id": int32(3), "label": "active"},
+				{"_id": int32(4), "label": "other"},
+			})
+		})
+
+		Convey("It should compile a simple CASE into the same $switch", func() {
+			q := build("SELECT _id, CASE status WHEN 1 THEN 'active' WHEN 2 THEN 'blocked' ELSE 'other' END AS label FROM accounts")
+			So(q.Projection, ShouldResemble, bson.D{{Key: "_id", Value: 1}, {Key: "label", Value: label}})
+		})
+
+		Convey("It should compile a single branch into $cond, with NULL as the default", func() {
+			q := build("SELECT _id, CASE WHEN plan IS NULL OR total BETWEEN 1 AND 10 THEN 'small' END AS size FROM accounts WHERE _id > 2")
+			So(q.Projection, ShouldResemble, bson.D{{Key: "_id", Value: 1}, {Key: "size", Value: bson.M{"$cond": bson.A{
+				bson.M{"$or": bson.A{
+					bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$plan", nil}}, nil}},
+					bson.M{"$and": bson.A{
+						bson.M{"$gte": bson.A{"$total", int64(1)}},
+						bson.M{"$lte": bson.A{"$total", int64(10)}},
+					}},
+				}},
+				"small",
+				nil,
+			}}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(3), "size": "small"},
+				{"_id": int32(4), "size": "small"},
+			})
+		})
+
+		Convey("It should group on a CASE expression and its alias", func() {
+			for _, sql := range []string{
+				"SELECT CASE WHEN status = 1 THEN 'active' WHEN status = 2 THEN 'blocked' ELSE 'other' END AS label, COUNT(*) AS n FROM accounts GROUP BY label ORDER BY label",
+				"SELECT CASE WHEN status = 1 THEN 'active' WHEN status = 2 THEN 'blocked' ELSE 'other' END AS label, COUNT(*) AS n FROM accounts " +
+					"GROUP BY CASE WHEN status = 1 THEN 'active' WHEN status = 2 THEN 'blocked' ELSE 'other' END ORDER BY label",
+			} {
+				q := build(sql)
+				So(q.Pipeline[0], ShouldResemble, bson.D{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: bson.D{{Key: "label", Value: label}}},
+					{Key: "n", Value: bson.M{"$sum": 1}},
+				}}})
+				So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+					{"label": "active", "n": int32(2)},
+					{"label": "blocked", "n": int32(1)},
+					{"label": "other", "n": int32(1)},
+				})
+			}
+		})
+
+		Convey("It should accumulate CASE expressions", func() {
+			q := build("SELECT plan, SUM(CASE WHEN status = 1 THEN total ELSE 0 END) AS active_total FROM accounts GROUP BY plan ORDER BY plan")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"plan": nil, "active_total": int32(0)},
+				{"plan": "free", "active_total": int32(10)},
+				{"plan": "pro", "active_total": int32(30)},
+			})
+		})
+
+		Convey("It should compare a CASE expression with $expr", func() {
+			q := build("SELECT _id FROM accounts WHERE CASE WHEN plan IN ('pro', 'team') THEN total ELSE total * 2 END >= 20")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "$expr", Value: bson.M{"$gte": bson.A{
+				bson.M{"$cond": bson.A{
+					bson.M{"$in": bson.A{"$plan", bson.A{"pro", "team"}}},
+					"$total",
+					bson.M{"$multiply": bson.A{"$total", int64(2)}},
+				}},
+				int64(20),
+			}}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}, {"_id": int32(3)}})
+		})
+
+		Convey("It should sort on a CASE expression", func() {
+			q := build("SELECT _id FROM accounts ORDER BY CASE WHEN NOT status = 1 THEN 0 ELSE 1 END, _id DESC")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(4)}, {"_id": int32(2)}, {"_id": int32(3)}, {"_id": int32(1)}})
+		})
+
+		Convey("It should reject CASE expressions it cannot translate", func() {
+			for _, sql := range []string{
+				"SELECT CASE WHEN status LIKE 'a%' THEN 1 END AS x FROM accounts",
+				"SELECT CASE WHEN status = 1 THEN UNKNOWN_FN(total) END AS x FROM accounts",
+			} {
+				_, err := NewStatement(sql).Build(NewQuery())
+				So(err, ShouldHaveSameTypeAs, &UnsupportedError{})
+			}
+		})
+	})
+}
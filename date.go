@@ -0,0 +1,428 @@
+package squeel
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+WithDateFields marks fields of a collection as dates. String literals that
+are compared with these fields, including in BETWEEN and IN, are converted
+to time.Time, so they match BSON dates. The option can be given once for
+every collection.
+
+Parameters:
+- collection: The collection the fields belong to
+- fields: The names or dotted paths of the date fields
+
+Returns:
+- The option to pass to NewStatement
+*/
+func WithDateFields(collection string, fields ...string) StatementOption {
+	return func(statement *Statement) {
+		if statement.dateFields == nil {
+			statement.dateFields = map[string][]string{}
+		}
+		statement.dateFields[collection] = append(statement.dateFields[collection], fields...)
+	}
+}
+
+/*
+isDateField reports whether a column refers to a field configured as a date
+with WithDateFields.
+
+Parameters:
+- col: The column
+- collection: The collection the column belongs to
+
+Returns:
+- Whether the field is a date
+*/
+func (statement *Statement) isDateField(col *sqlparser.ColName, collection string) bool {
+	for _, field := range statement.dateFields[collection] {
+		if field == col.Name.String() || field == statement.fieldPath(col) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+dateValue converts the value a date field is compared with, converting every
+string in a list of values as well. Values that are not strings are left as
+they are.
+
+Parameters:
+- value: The value to convert
+
+Returns:
+- The converted value
+- Whether every string could be parsed as a time
+*/
+func dateValue(value interface{}) (interface{}, bool) {
+	switch value := value.(type) {
+	case string:
+		t, err := parseTimeWithFormats(value)
+		return t, err == nil
+	case []interface{}:
+		times := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			t, ok := dateValue(item)
+			if !ok {
+				return nil, false
+			}
+			times = append(times, t)
+		}
+		return times, true
+	}
+	return value, true
+}
+
+/*
+dateLiteral returns the string of a DATE or TIMESTAMP literal, which
+rewriteSQL turns into CAST('...' AS DATE) and CAST('...' AS DATETIME).
+
+Parameters:
+- expr: The CAST expression
+
+Returns:
+- The string that is cast
+- Whether the expression casts a string to a date or time
+*/
+func dateLiteral(expr *sqlparser.ConvertExpr) (*sqlparser.SQLVal, bool) {
+	val, ok := expr.Expr.(*sqlparser.SQLVal)
+	if !ok || val.Type != sqlparser.StrVal || expr.Type == nil {
+		return nil, false
+	}
+
+	switch strings.ToLower(expr.Type.Type) {
+	case "date", "datetime":
+		return val, true
+	}
+	return nil, false
+}
+
+/*
+parseDateLiteral converts a DATE or TIMESTAMP literal into a time.Time.
+
+Parameters:
+- expr: The CAST expression
+
+Returns:
+- The time
+- Whether the expression is a date literal holding a valid time
+*/
+func parseDateLiteral(expr *sqlparser.ConvertExpr) (time.Time, bool) {
+	val, ok := dateLiteral(expr)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t, err := parseTimeWithFormats(string(val.Val))
+	return t, err == nil
+}
+
+/*
+dateFunctions maps the MySQL date and time functions onto the aggregation
+operators that compute them. NOW() is the time the query runs, $$NOW.
+*/
+var dateFunctions = map[string]scalarFunction{
+	"now":               nowFunction,
+	"current_timestamp": nowFunction,
+	"curdate":           todayFunction,
+	"current_date":      todayFunction,
+	"year":              datePart("$year"),
+	"month":             datePart("$month"),
+	"day":               datePart("$dayOfMonth"),
+	"dayofmonth":        datePart("$dayOfMonth"),
+	"dayofweek":         datePart("$dayOfWeek"),
+	"dayofyear":         datePart("$dayOfYear"),
+	"week":              datePart("$week"),
+	"hour":              datePart("$hour"),
+	"minute":            datePart("$minute"),
+	"second":            datePart("$second"),
+	"extract": {
+		params: []valueType{unitType, dateType},
+		result: numberType,
+		check:  checkUnit(extractOperators),
+		compile: func(args bson.A) interface{} {
+			return bson.M{extractOperators[args[0].(string)]: args[1]}
+		},
+	},
+	"date_trunc": {
+		params: []valueType{unitType, dateType},
+		result: dateType,
+		check:  checkUnit(dateUnits),
+		compile: func(args bson.A) interface{} {
+			return truncateDate(args[1], args[0].(string))
+		},
+	},
+	"date": {
+		params: []valueType{dateType},
+		result: dateType,
+		compile: func(args bson.A) interface{} {
+			return truncateDate(args[0], "day")
+		},
+	},
+	"date_format": {
+		params: []valueType{dateType, stringType},
+		result: stringType,
+		check: func(args bson.A) string {
+			format, ok := args[1].(string)
+			if !ok {
+				return "the format must be a string literal"
+			}
+			_, err := dateFormat(format)
+			return errorReason(err)
+		},
+		compile: func(args bson.A) interface{} {
+			format, _ := dateFormat(args[1].(string))
+			return bson.M{"$dateToString": bson.D{{Key: "date", Value: args[0]}, {Key: "format", Value: format}}}
+		},
+	},
+	"date_add": dateArithmetic("$dateAdd"),
+	"date_sub": dateArithmetic("$dateSubtract"),
+	"datediff": {
+		params: []valueType{dateType, dateType},
+		result: numberType,
+		compile: func(args bson.A) interface{} {
+			return dateDiff(args[1], args[0], "day")
+		},
+	},
+	"timestampdiff": {
+		params: []valueType{unitType, dateType, dateType},
+		result: numberType,
+		check:  checkUnit(dateUnits),
+		compile: func(args bson.A) interface{} {
+			return dateDiff(args[1], args[2], args[0].(string))
+		},
+	},
+}
+
+/*
+dateUnits are the units of time that DATE_TRUNC, INTERVAL and TIMESTAMPDIFF
+take, which the aggregation operators name the same.
+*/
+var dateUnits = map[string]string{
+	"year":    "year",
+	"quarter": "quarter",
+	"month":   "month",
+	"week":    "week",
+	"day":     "day",
+	"hour":    "hour",
+	"minute":  "minute",
+	"second":  "second",
+}
+
+/*
+extractOperators maps the units of EXTRACT onto the operators returning
+that part of a date.
+*/
+var extractOperators = map[string]string{
+	"year":   "$year",
+	"month":  "$month",
+	"week":   "$week",
+	"day":    "$dayOfMonth",
+	"hour":   "$hour",
+	"minute": "$minute",
+	"second": "$second",
+}
+
+/*
+nowFunction returns the time the query runs.
+*/
+var nowFunction = scalarFunction{
+	result: dateType,
+	compile: func(bson.A) interface{} {
+		return "$$NOW"
+	},
+}
+
+/*
+todayFunction returns the start of the day the query runs on.
+*/
+var todayFunction = scalarFunction{
+	result: dateType,
+	compile: func(bson.A) interface{} {
+		return truncateDate("$$NOW", "day")
+	},
+}
+
+/*
+dateInterval is a compiled INTERVAL, as in INTERVAL 1 DAY.
+*/
+type dateInterval struct {
+	unit   string      // The lowercase unit of the interval
+	amount interface{} // The number of units
+}
+
+/*
+interval compiles an INTERVAL argument. A quoted amount, as in INTERVAL '2'
+HOUR, is read as a number.
+
+Parameters:
+- expr: The INTERVAL expression
+
+Returns:
+- The dateInterval
+- The reason the interval is invalid, or an empty string
+- Whether the interval could be compiled
+*/
+func (statement *Statement) interval(expr *sqlparser.IntervalExpr) (interface{}, string, bool) {
+	amount, ok := statement.expression(expr.Expr)
+	if !ok {
+		return nil, "", false
+	}
+
+	if text, isText := amount.(string); isText {
+		number, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			return nil, "must have a whole number of units, not " + sqlparser.String(expr.Expr), false
+		}
+		amount = number
+	}
+
+	return dateInterval{unit: strings.ToLower(expr.Unit), amount: amount}, "", true
+}
+
+/*
+datePart returns a function of a date, such as YEAR, that compiles into the
+operator returning that part of the date.
+*/
+func datePart(operator string) scalarFunction {
+	return scalarFunction{
+		params: []valueType{dateType},
+		result: numberType,
+		compile: func(args bson.A) interface{} {
+			return bson.M{operator: args[0]}
+		},
+	}
+}
+
+/*
+dateArithmetic returns DATE_ADD or DATE_SUB, which add or subtract an
+INTERVAL with $dateAdd or $dateSubtract.
+*/
+func dateArithmetic(operator string) scalarFunction {
+	return scalarFunction{
+		params: []valueType{dateType, intervalType},
+		result: dateType,
+		check: func(args bson.A) string {
+			interval := args[1].(dateInterval)
+			if _, ok := dateUnits[interval.unit]; !ok {
+				return "unsupported unit " + strings.ToUpper(interval.unit)
+			}
+			if _, ok := interval.amount.(float64); ok {
+				return "the interval must have a whole number of units"
+			}
+			return ""
+		},
+		compile: func(args bson.A) interface{} {
+			interval := args[1].(dateInterval)
+			return bson.M{operator: bson.D{
+				{Key: "startDate", Value: args[0]},
+				{Key: "unit", Value: dateUnits[interval.unit]},
+				{Key: "amount", Value: interval.amount},
+			}}
+		},
+	}
+}
+
+/*
+checkUnit returns a check that the unit a function takes as its first
+argument is one of the given units.
+*/
+func checkUnit(units map[string]string) func(args bson.A) string {
+	return func(args bson.A) string {
+		if unit := args[0].(string); units[unit] == "" {
+			return "unsupported unit " + strings.ToUpper(unit)
+		}
+		return ""
+	}
+}
+
+/*
+truncateDate builds the $dateTrunc expression that rounds a date down to a
+unit of time.
+*/
+func truncateDate(date interface{}, unit string) bson.M {
+	return bson.M{"$dateTrunc": bson.D{{Key: "date", Value: date}, {Key: "unit", Value: dateUnits[unit]}}}
+}
+
+/*
+dateDiff builds the $dateDiff expression that counts the boundaries of a
+unit of time between two dates. Unlike TIMESTAMPDIFF in MySQL, which counts
+whole units, 23:00 and 01:00 the next day are a day apart.
+*/
+func dateDiff(start, end interface{}, unit string) bson.M {
+	return bson.M{"$dateDiff": bson.D{
+		{Key: "startDate", Value: start},
+		{Key: "endDate", Value: end},
+		{Key: "unit", Value: dateUnits[unit]},
+	}}
+}
+
+/*
+dateFormatSpecifiers maps the MySQL DATE_FORMAT specifiers onto those of
+$dateToString.
+*/
+var dateFormatSpecifiers = map[byte]string{
+	'Y': "%Y",
+	'm': "%m",
+	'd': "%d",
+	'H': "%H",
+	'i': "%M",
+	's': "%S",
+	'S': "%S",
+	'j': "%j",
+	'U': "%U",
+	'T': "%H:%M:%S",
+	'%': "%%",
+}
+
+/*
+dateFormat converts a MySQL DATE_FORMAT format into a $dateToString format.
+
+Parameters:
+- format: The MySQL format
+
+Returns:
+- The $dateToString format
+- An error naming the first specifier that has no equivalent
+*/
+func dateFormat(format string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			out.WriteByte(format[i])
+			continue
+		}
+
+		if i+1 == len(format) {
+			return "", fmt.Errorf("the format ends in %%")
+		}
+
+		i++
+		specifier, ok := dateFormatSpecifiers[format[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported format specifier %%%c", format[i])
+		}
+		out.WriteString(specifier)
+	}
+	return out.String(), nil
+}
+
+/*
+errorReason returns the message of an error, or an empty string for nil.
+*/
+func errorReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,235 @@
+package squeel
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDates(t *testing.T) {
+	Convey("Given SQL comparing dates", t, func() {
+		day := func(value string) time.Time {
+			t, err := time.Parse("2006-01-02", value)
+			So(err, ShouldBeNil)
+			return t
+		}
+
+		evaluator := newEvaluator(map[string][]interface{}{
+			"employees": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "hire_date", Value: day("2019-06-01")}, {Key: "note", Value: "2019-06-01"}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "hire_date", Value: day("2020-01-01")}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "hire_date", Value: day("2021-03-15").Add(9 * time.Hour)}},
+			},
+		})
+
+		Convey("It should convert DATE and TIMESTAMP literals", func() {
+			q := build("SELECT _id FROM employees WHERE hire_date >= DATE '2020-01-01' AND hire_date < timestamp '2021-03-15 09:00:00'")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "hire_date", Value: bson.D{
+				{Key: "$gte", Value: day("2020-01-01")},
+				{Key: "$lt", Value: day("2021-03-15").Add(9 * time.Hour)},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2)}})
+		})
+
+		Convey("It should convert literals in BETWEEN and IN", func() {
+			So(ids(evaluator, "SELECT _id FROM employees WHERE hire_date BETWEEN DATE '2019-01-01' AND DATE '2020-01-01'"), ShouldResemble, []interface{}{int32(1), int32(2)})
+			So(ids(evaluator, "SELECT _id FROM employees WHERE hire_date IN (DATE '2019-06-01', DATE '2020-01-01')"), ShouldResemble, []interface{}{int32(1), int32(2)})
+		})
+
+		Convey("It should convert strings compared with configured date fields", func() {
+			dates := WithDateFields("employees", "hire_date")
+
+			So(ids(evaluator, "SELECT _id FROM employees WHERE hire_date >= '2020-01-01'"), ShouldBeEmpty)
+			So(ids(evaluator, "SELECT _id FROM employees WHERE hire_date >= '2020-01-01'", dates), ShouldResemble, []interface{}{int32(2), int32(3)})
+			So(ids(evaluator, "SELECT _id FROM employees WHERE hire_date NOT BETWEEN '2019-01-01' AND '2020-12-31'", dates), ShouldResemble, []interface{}{int32(3)})
+			So(ids(evaluator, "SELECT _id FROM employees WHERE hire_date IN ('2019-06-01', '2021-03-15T09:00:00Z')", dates), ShouldResemble, []interface{}{int32(1), int32(3)})
+			So(ids(evaluator, "SELECT _id FROM employees WHERE note = '2019-06-01'", dates), ShouldResemble, []interface{}{int32(1)})
+		})
+
+		Convey("It should only configure the fields of the given collection", func() {
+			q := build("SELECT e._id FROM employees e JOIN contracts c ON c.employee_id = e._id WHERE e.hire_date = '2020-01-01' AND c.start = '2020-01-01'",
+				WithDateFields("contracts", "start"))
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "hire_date", Value: "2020-01-01"},
+				{Key: "c.start", Value: day("2020-01-01")},
+			})
+		})
+
+		Convey("It should leave quoted text that looks like a literal alone", func() {
+			So(build("SELECT _id FROM employees WHERE note = 'date ''2020-01-01'''").Filter, ShouldResemble, bson.D{
+				{Key: "note", Value: "date '2020-01-01'"},
+			})
+		})
+
+		Convey("It should reject invalid dates", func() {
+			_, err := NewStatement("SELECT _id FROM employees WHERE hire_date > DATE 'soon'").Build(NewQuery())
+			So(err, ShouldNotBeNil)
+
+			_, err = NewStatement("SELECT _id FROM employees WHERE hire_date > 'soon'", WithDateFields("employees", "hire_date")).Build(NewQuery())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDateFunctions(t *testing.T) {
+	Convey("Given SQL with date and time functions", t, func() {
+		at := func(value string) time.Time {
+			t, err := time.Parse(time.RFC3339, value)
+			So(err, ShouldBeNil)
+			return t
+		}
+
+		evaluator := newEvaluator(map[string][]interface{}{
+			"events": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "at", Value: at("2024-01-31T10:30:15Z")}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "at", Value: at("2024-02-29T23:00:00Z")}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "at", Value: at("2023-12-31T01:05:00Z")}},
+			},
+			"people": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "BirthDay", Value: at("1992-02-29T00:00:00Z")}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "BirthDay", Value: at("1984-03-01T00:00:00Z")}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "BirthDay", Value: at("2000-02-29T12:00:00Z")}},
+			},
+		})
+
+		Convey("It should extract the parts of a date", func() {
+			q := build("SELECT _id, YEAR(at) AS y, MONTH(at) AS m, DAYOFMONTH(at) AS d, EXTRACT(HOUR FROM at) AS h, " +
+				"MINUTE(at) AS mi, DAYOFWEEK(at) AS dow, WEEK(at) AS w FROM events WHERE _id = 3")
+			So(q.Projection, ShouldResemble, bson.D{
+				{Key: "_id", Value: 1},
+				{Key: "y", Value: bson.M{"$year": "$at"}},
+				{Key: "m", Value: bson.M{"$month": "$at"}},
+				{Key: "d", Value: bson.M{"$dayOfMonth": "$at"}},
+				{Key: "h", Value: bson.M{"$hour": "$at"}},
+				{Key: "mi", Value: bson.M{"$minute": "$at"}},
+				{Key: "dow", Value: bson.M{"$dayOfWeek": "$at"}},
+				{Key: "w", Value: bson.M{"$week": "$at"}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{
+				"_id": int32(3), "y": int32(2023), "m": int32(12), "d": int32(31), "h": int32(1), "mi": int32(5), "dow": int32(1), "w": int32(53),
+			}})
+		})
+
+		Convey("It should truncate and format dates", func() {
+			q := build("SELECT _id, DATE_TRUNC('month', at) AS month, DATE(at) AS day, DATE_FORMAT(at, '%Y-%m-%d %T') AS text FROM events WHERE _id = 1")
+			So(q.Projection, ShouldResemble, bson.D{
+				{Key: "_id", Value: 1},
+				{Key: "month", Value: bson.M{"$dateTrunc": bson.D{{Key: "date", Value: "$at"}, {Key: "unit", Value: "month"}}}},
+				{Key: "day", Value: bson.M{"$dateTrunc": bson.D{{Key: "date", Value: "$at"}, {Key: "unit", Value: "day"}}}},
+				{Key: "text", Value: bson.M{"$dateToString": bson.D{{Key: "date", Value: "$at"}, {Key: "format", Value: "%Y-%m-%d %H:%M:%S"}}}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{
+				"_id":   int32(1),
+				"month": primitive.NewDateTimeFromTime(at("2024-01-01T00:00:00Z")),
+				"day":   primitive.NewDateTimeFromTime(at("2024-01-31T00:00:00Z")),
+				"text":  "2024-01-31 10:30:15",
+			}})
+		})
+
+		Convey("It should add and subtract intervals", func() {
+			q := build("SELECT _id, DATE_ADD(at, INTERVAL 1 MONTH) AS later, at - INTERVAL '2' HOUR AS before FROM events WHERE _id = 1")
+			So(q.Projection, ShouldResemble, bson.D{
+				{Key: "_id", Value: 1},
+				{Key: "later", Value: bson.M{"$dateAdd": bson.D{{Key: "startDate", Value: "$at"}, {Key: "unit", Value: "month"}, {Key: "amount", Value: int64(1)}}}},
+				{Key: "before", Value: bson.M{"$dateSubtract": bson.D{{Key: "startDate", Value: "$at"}, {Key: "unit", Value: "hour"}, {Key: "amount", Value: int64(2)}}}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{
+				"_id":    int32(1),
+				"later":  primitive.NewDateTimeFromTime(at("2024-02-29T10:30:15Z")),
+				"before": primitive.NewDateTimeFromTime(at("2024-01-31T08:30:15Z")),
+			}})
+		})
+
+		Convey("It should count the days and units between dates", func() {
+			q := build("SELECT _id, DATEDIFF('2024-03-01', at) AS days, TIMESTAMPDIFF(MONTH, at, '2024-03-01') AS months FROM events ORDER BY _id")
+			So(q.Projection[1], ShouldResemble, bson.E{Key: "days", Value: bson.M{"$dateDiff": bson.D{
+				{Key: "startDate", Value: "$at"},
+				{Key: "endDate", Value: at("2024-03-01T00:00:00Z")},
+				{Key: "unit", Value: "day"},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(1), "days": int64(30), "months": int64(2)},
+				{"_id": int32(2), "days": int64(1), "months": int64(1)},
+				{"_id": int32(3), "days": int64(61), "months": int64(3)},
+			})
+		})
+
+		Convey("It should filter, group and sort on date functions", func() {
+			q := build("SELECT _id FROM events WHERE at < NOW() - INTERVAL 1 DAY AND EXTRACT(YEAR FROM at) = 2024 ORDER BY DAYOFMONTH(at)")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "$expr", Value: bson.M{"$and": bson.A{
+				bson.M{"$lt": bson.A{"$at", bson.M{"$dateSubtract": bson.D{
+					{Key: "startDate", Value: "$$NOW"},
+					{Key: "unit", Value: "day"},
+					{Key: "amount", Value: int64(1)},
+				}}}},
+				bson.M{"$eq": bson.A{bson.M{"$year": "$at"}, int64(2024)}},
+			}}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2)}, {"_id": int32(1)}})
+
+			q = build("SELECT YEAR(at) AS y, COUNT(*) AS n FROM events GROUP BY y ORDER BY y")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"y": int32(2023), "n": int32(1)}, {"y": int32(2024), "n": int32(2)}})
+		})
+
+		Convey("It should compare strings with dates as dates", func() {
+			q := build("SELECT _id FROM events WHERE DATE(at) = '2024-01-31' OR '2023-12-31' = DATE(at) ORDER BY _id")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "$expr", Value: bson.M{"$eq": bson.A{
+					bson.M{"$dateTrunc": bson.D{{Key: "date", Value: "$at"}, {Key: "unit", Value: "day"}}},
+					at("2024-01-31T00:00:00Z"),
+				}}}},
+				bson.D{{Key: "$expr", Value: bson.M{"$eq": bson.A{
+					at("2023-12-31T00:00:00Z"),
+					bson.M{"$dateTrunc": bson.D{{Key: "date", Value: "$at"}, {Key: "unit", Value: "day"}}},
+				}}}},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}, {"_id": int32(3)}})
+
+			for sql, want := range map[string][]bson.M{
+				"SELECT _id FROM events WHERE DATE(at) IN ('2024-02-29', '2023-12-31') ORDER BY _id":       {{"_id": int32(2)}, {"_id": int32(3)}},
+				"SELECT _id FROM events WHERE DATE(at) BETWEEN '2024-01-01' AND '2024-02-01' ORDER BY _id": {{"_id": int32(1)}},
+				"SELECT _id FROM events WHERE DATE_TRUNC('month', at) > '2024-01-15' ORDER BY _id":         {{"_id": int32(2)}},
+			} {
+				So(evaluate(evaluator, build(sql)), ShouldResemble, want)
+			}
+		})
+
+		Convey("It should check the arguments when the statement is built", func() {
+			for sql, message := range map[string]string{
+				"SELECT DATE_TRUNC('fortnight', at) AS x FROM events":          "invalid arguments to DATE_TRUNC in DATE_TRUNC('fortnight', at): unsupported unit FORTNIGHT",
+				"SELECT DATE_FORMAT(at, '%W') AS x FROM events":                "invalid arguments to DATE_FORMAT in DATE_FORMAT(at, '%W'): unsupported format specifier %W",
+				"SELECT _id FROM events WHERE DATEDIFF(at, 'soon') > 1":        "invalid arguments to DATEDIFF in DATEDIFF(at, 'soon'): argument 2 must be a date, not 'soon'",
+				"SELECT DATE_ADD(at, 1) AS x FROM events":                      "invalid arguments to DATE_ADD in DATE_ADD(at, 1): argument 2 must be an INTERVAL, not a number",
+				"SELECT MONTH(UPPER(at)) AS x FROM events":                     "invalid arguments to MONTH in MONTH(UPPER(at)): argument 1 must be a date, not a string",
+				"SELECT DATE_ADD(at, INTERVAL 1 MICROSECOND) AS x FROM events": "invalid arguments to DATE_ADD in DATE_ADD(at, interval 1 MICROSECOND): unsupported unit MICROSECOND",
+			} {
+				_, err := NewStatement(sql).Build(NewQuery())
+				So(err, ShouldHaveSameTypeAs, &ArgumentError{})
+				So(err.Error(), ShouldEqual, message)
+			}
+		})
+
+		Convey("It should rewrite EXTRACT into a function call", func() {
+			So(rewriteSQL("SELECT EXTRACT(year FROM at), extract ( DAY from at) FROM events WHERE note = 'extract(year from x)'"), ShouldEqual,
+				"SELECT extract('year', at), extract('day', at) FROM events WHERE note = 'extract(year from x)'")
+		})
+
+		Convey("It should write the BirthDay filter as SQL", func() {
+			q := NewQuery()
+			q.Operation, q.Collection = "find", "people"
+			So(q.handleBirthDay("29-02"), ShouldBeNil)
+			So(q.Filter, ShouldResemble, bson.D{{Key: "$expr", Value: bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$month": "$BirthDay"}, int64(2)}},
+				bson.M{"$eq": bson.A{bson.M{"$dayOfMonth": "$BirthDay"}, int64(29)}},
+			}}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(1), "BirthDay": primitive.NewDateTimeFromTime(at("1992-02-29T00:00:00Z"))},
+				{"_id": int32(3), "BirthDay": primitive.NewDateTimeFromTime(at("2000-02-29T12:00:00Z"))},
+			})
+
+			So(q.handleBirthDay("29-xx"), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,611 @@
+package squeel
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xwb1989/sqlparser"
+	"github.com/xwb1989/sqlparser/dependency/querypb"
+	"github.com/xwb1989/sqlparser/dependency/sqltypes"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+/*
+DriverName is the name under which squeel registers itself with database/sql.
+*/
+const DriverName = "squeel"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+/*
+Driver is a database/sql driver that translates SQL queries with squeel and
+runs them against MongoDB. The data source name is a MongoDB connection URI
+that names the database to use, for example mongodb://localhost:27017/app.
+*/
+type Driver struct{}
+
+/*
+Open connects to the MongoDB deployment described by the URI and returns a
+connection bound to the database named in its path. The connection owns its
+client and disconnects it when closed. database/sql uses OpenConnector
+instead, so that its pooled connections share a single client.
+
+Parameters:
+- uri: The MongoDB connection URI, including the database name
+
+Returns:
+- A new driver connection
+- Any error that occurred while parsing the URI or connecting
+*/
+func (d *Driver) Open(uri string) (driver.Conn, error) {
+	c, err := d.newConnector(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{
+		client:   c.client,
+		executor: c.executor(),
+	}, nil
+}
+
+/*
+OpenConnector parses the URI and creates the MongoDB client that every
+connection opened through the returned connector shares. The client, and
+with it its connection pool, lives until the sql.DB is closed.
+
+Parameters:
+- uri: The MongoDB connection URI, including the database name
+
+Returns:
+- A connector for the database named in the URI
+- Any error that occurred while parsing the URI or creating the client
+*/
+func (d *Driver) OpenConnector(uri string) (driver.Connector, error) {
+	return d.newConnector(uri)
+}
+
+/*
+NewConnector creates a connector for the database named in the URI whose
+statements are built with the given options, such as WithDateFields or
+WithNullSemantics. Pass it to sql.OpenDB to configure the translation,
+which a data source name cannot express.
+
+Parameters:
+- uri: The MongoDB connection URI, including the database name
+- options: The options every statement is built with
+
+Returns:
+- A connector for the database named in the URI
+- Any error that occurred while parsing the URI or creating the client
+*/
+func NewConnector(uri string, options ...StatementOption) (driver.Connector, error) {
+	c, err := (&Driver{}).newConnector(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	c.options = options
+	return c, nil
+}
+
+/*
+newConnector validates the URI and creates a client for it.
+*/
+func (d *Driver) newConnector(uri string) (*connector, error) {
+	cs, err := connstring.ParseAndValidate(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if cs.Database == "" {
+		return nil, fmt.Errorf("connection uri must name a database")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	return &connector{driver: d, client: client, database: cs.Database}, nil
+}
+
+/*
+connector hands out connections that share one MongoDB client. The client
+manages its own connection pool, so a driver connection is only a handle
+to the database.
+*/
+type connector struct {
+	driver   *Driver
+	client   *mongo.Client
+	database string
+	options  []StatementOption // The options every statement is built with
+}
+
+/*
+Connect returns a connection that uses the shared client.
+*/
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &conn{executor: c.executor(), options: c.options}, nil
+}
+
+/*
+Driver returns the driver that created the connector.
+*/
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+/*
+Close disconnects the shared client. database/sql calls it when the sql.DB
+is closed.
+*/
+func (c *connector) Close() error {
+	return c.client.Disconnect(context.Background())
+}
+
+/*
+executor returns an Executor for the connector's database.
+*/
+func (c *connector) executor() *Executor {
+	return NewExecutor(c.client.Database(c.database))
+}
+
+/*
+conn is a single driver connection. It only supports queries; transactions
+and statements that modify data are rejected.
+*/
+type conn struct {
+	client   *mongo.Client // The client owned by the connection, if any
+	executor *Executor
+	options  []StatementOption // The options every statement is built with
+}
+
+/*
+Prepare returns a prepared statement bound to this connection. The SQL is
+translated each time the statement is queried, once its arguments are known.
+*/
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+/*
+Close disconnects the MongoDB client if the connection owns it. Connections
+handed out by a connector leave the shared client connected.
+*/
+func (c *conn) Close() error {
+	if c.client == nil {
+		return nil
+	}
+	return c.client.Disconnect(context.Background())
+}
+
+/*
+Begin is not supported, as squeel only translates read queries.
+*/
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("squeel: transactions are not supported")
+}
+
+/*
+QueryContext binds the arguments into the SQL, translates it into a Query,
+executes it and exposes the resulting documents as rows. The context is used
+for both the MongoDB operation and reading its cursor, so cancelling it
+aborts the query.
+
+Parameters:
+- ctx: The context for the query
+- query: The SQL query, optionally containing ? or :name placeholders
+- args: The values for the placeholders
+
+Returns:
+- The rows produced by the query
+- Any error that occurred while binding, translating or executing
+*/
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	raw, err := bindArgs(query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := NewStatement(raw, c.options...)
+
+	q, err := statement.Build(NewQuery())
+	if err != nil {
+		return nil, err
+	}
+
+	q.Context = ctx
+
+	result, err := c.executor.Execute(q)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close(ctx)
+
+	var documents []bson.D
+	if err := result.All(ctx, &documents); err != nil {
+		return nil, err
+	}
+
+	return newRows(statement, result.Operation, documents), nil
+}
+
+/*
+stmt is a prepared statement. It holds the raw SQL until it is queried.
+*/
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+/*
+Close is a no-op, as nothing is held on the server for a statement.
+*/
+func (s *stmt) Close() error {
+	return nil
+}
+
+/*
+NumInput returns -1, since placeholders are only counted when the SQL is parsed.
+*/
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+/*
+Exec is not supported, as squeel only translates read queries.
+*/
+func (s *stmt) Exec(_ []driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("squeel: exec is not supported")
+}
+
+/*
+Query runs the statement with positional arguments.
+*/
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return s.conn.QueryContext(context.Background(), s.query, named)
+}
+
+/*
+QueryContext runs the statement with the given arguments and context.
+*/
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+/*
+bindArgs substitutes placeholder arguments into the SQL as literals. The
+parser names positional ? placeholders :v1, :v2 and so on, which are matched
+to the unnamed arguments in order, while named placeholders keep their own name.
+The SQL is rewritten with rewriteSQL first, so that syntax only squeel reads,
+such as ILIKE, parses.
+
+Parameters:
+- query: The SQL query containing placeholders
+- args: The values to substitute
+
+Returns:
+- The SQL query with every placeholder replaced by its literal value
+- Any error that occurred during parsing or substitution
+*/
+func bindArgs(query string, args []driver.NamedValue) (string, error) {
+	if len(args) == 0 {
+		return query, nil
+	}
+
+	parsed, err := sqlparser.Parse(rewriteSQL(query))
+	if err != nil {
+		return "", err
+	}
+
+	bindVariables := make(map[string]*querypb.BindVariable, len(args))
+	literals := make(map[string]sqlparser.Encodable)
+	position := 0
+
+	for _, arg := range args {
+		name := arg.Name
+		if name == "" {
+			position++
+			name = "v" + strconv.Itoa(position)
+		}
+
+		if literal, ok := bindLiteral(arg.Value); ok {
+			literals[name] = literal
+			continue
+		}
+
+		bindVariable, err := sqltypes.BuildBindVariable(arg.Value)
+		if err != nil {
+			return "", fmt.Errorf("argument %s: %w", name, err)
+		}
+		bindVariables[name] = bindVariable
+	}
+
+	out, err := sqlparser.NewParsedQuery(parsed).GenerateQuery(bindVariables, literals)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+/*
+sqlLiteral is SQL text that is substituted for a placeholder as it is.
+*/
+type sqlLiteral string
+
+/*
+EncodeSQL writes the literal into the generated query.
+*/
+func (literal sqlLiteral) EncodeSQL(buf *bytes.Buffer) {
+	buf.WriteString(string(literal))
+}
+
+/*
+bindLiteral converts driver values that have no bind variable type of their
+own into the literal the same value is written as in SQL. Booleans become
+true or false, so they match BSON booleans, and times become TIMESTAMP
+literals in UTC, so they match BSON dates.
+
+Parameters:
+- value: The argument value
+
+Returns:
+- The literal to substitute
+- Whether the value is written as a literal of its own
+*/
+func bindLiteral(value driver.Value) (sqlLiteral, bool) {
+	switch value := value.(type) {
+	case bool:
+		return sqlLiteral(strconv.FormatBool(value)), true
+	case time.Time:
+		return sqlLiteral("timestamp '" + value.UTC().Format(time.RFC3339Nano) + "'"), true
+	}
+	return "", false
+}
+
+/*
+rows exposes a set of result documents as driver rows. Each column is read
+from the document key at the same index in keys.
+*/
+type rows struct {
+	columns   []string
+	keys      []string
+	documents []bson.D
+	index     int
+}
+
+/*
+newRows determines the columns of the result and wraps the documents. Columns
+follow the SELECT list order; for SELECT * they are the union of the top-level
+keys of all documents, in the order they were first seen.
+
+Parameters:
+- statement: The built statement that produced the documents
+- operation: The operation that was executed
+- documents: The result documents
+
+Returns:
+- The rows for the documents
+*/
+func newRows(statement *Statement, operation string, documents []bson.D) *rows {
+	columns, keys, star := statement.selectColumns()
+	if star {
+		columns = documentKeys(documents)
+		keys = columns
+	}
+
+	if operation == "count" && len(columns) == 1 {
+		keys = []string{"count"}
+	}
+
+	return &rows{columns: columns, keys: keys, documents: documents}
+}
+
+/*
+selectColumns returns the column names of the SELECT list, together with the
+document key each column is read from. A column is named by its alias, while
+its key is the field the translator produces for it, which for a plain column
+without an alias is the source field.
+
+Returns:
+- The column names in SELECT list order
+- The document key of each column
+- Whether the SELECT list contains a star expression
+*/
+func (statement *Statement) selectColumns() (columns, keys []string, star bool) {
+	selectNode, ok := statement.stmt.(*sqlparser.Select)
+	if !ok {
+		return nil, nil, false
+	}
+
+	for _, expr := range selectNode.SelectExprs {
+		aliased, ok := expr.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, nil, true
+		}
+		columns = append(columns, statement.columnName(aliased))
+		keys = append(keys, statement.columnKey(aliased))
+	}
+
+	return columns, keys, false
+}
+
+/*
+columnName determines the name of a single SELECT expression, which is its
+alias when it has one, and the last segment of a column's path otherwise.
+*/
+func (statement *Statement) columnName(aliased *sqlparser.AliasedExpr) string {
+	if aliased.As.String() != "" {
+		return aliased.As.String()
+	}
+	if col, ok := aliased.Expr.(*sqlparser.ColName); ok {
+		segments := columnSegments(col)
+		return segments[len(segments)-1]
+	}
+	return statement.columnKey(aliased)
+}
+
+/*
+columnKey determines the document key a single SELECT expression is read
+from, using the same naming the translator uses for the fields it produces.
+Plain columns are read from their path, while aliased columns and aggregates
+are stored under their alias.
+*/
+func (statement *Statement) columnKey(aliased *sqlparser.AliasedExpr) string {
+	switch expr := aliased.Expr.(type) {
+	case *sqlparser.ColName:
+		return statement.projectedKey(expr, aliased.As.String())
+	case *sqlparser.SQLVal, *sqlparser.NullVal, sqlparser.BoolVal:
+		return literalColumnName(aliased)
+	case *sqlparser.ParenExpr:
+		return statement.columnKey(&sqlparser.AliasedExpr{Expr: expr.Expr, As: aliased.As})
+	case *sqlparser.FuncExpr:
+		return statement.getAggregateAlias(aliased, expr, expr.Name.Lowered())
+	}
+
+	if aliased.As.String() != "" {
+		return aliased.As.String()
+	}
+	return sqlparser.String(aliased.Expr)
+}
+
+/*
+documentKeys returns the union of the top-level keys of the documents, in the
+order in which they are first seen.
+*/
+func documentKeys(documents []bson.D) []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+
+	for _, document := range documents {
+		for _, element := range document {
+			if !seen[element.Key] {
+				seen[element.Key] = true
+				keys = append(keys, element.Key)
+			}
+		}
+	}
+
+	return keys
+}
+
+/*
+Columns returns the column names of the rows.
+*/
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+/*
+Close releases the buffered documents.
+*/
+func (r *rows) Close() error {
+	r.documents = nil
+	return nil
+}
+
+/*
+Next fills dest with the values of the next document, or returns io.EOF
+when all documents have been read. Missing fields are returned as NULL.
+*/
+func (r *rows) Next(dest []driver.Value) error {
+	if r.index >= len(r.documents) {
+		return io.EOF
+	}
+
+	document := r.documents[r.index]
+	r.index++
+
+	for i, key := range r.keys {
+		dest[i] = driverValue(lookupPath(document, key))
+	}
+
+	return nil
+}
+
+/*
+lookupPath returns the value at a key of a document. Dotted keys are followed
+into embedded documents when the document has no key with the literal name.
+*/
+func lookupPath(document bson.D, key string) interface{} {
+	for _, element := range document {
+		if element.Key == key {
+			return element.Value
+		}
+	}
+
+	head, rest, found := strings.Cut(key, ".")
+	if !found {
+		return nil
+	}
+
+	for _, element := range document {
+		if element.Key == head {
+			if embedded, ok := element.Value.(bson.D); ok {
+				return lookupPath(embedded, rest)
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+driverValue converts a BSON value into one of the types allowed by
+database/sql. Embedded documents and arrays are returned as extended JSON.
+*/
+func driverValue(value interface{}) driver.Value {
+	switch value := value.(type) {
+	case nil, int64, float64, bool, string, time.Time, []byte:
+		return value
+	case int32:
+		return int64(value)
+	case int:
+		return int64(value)
+	case primitive.DateTime:
+		return value.Time().UTC()
+	case primitive.Timestamp:
+		return time.Unix(int64(value.T), 0).UTC()
+	case primitive.ObjectID:
+		return value.Hex()
+	case primitive.Decimal128:
+		return value.String()
+	case primitive.Binary:
+		return value.Data
+	case primitive.Null, primitive.Undefined:
+		return nil
+	case bson.D, bson.A, bson.M:
+		out, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, false, false)
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+		return strings.TrimSuffix(strings.TrimPrefix(string(out), `{"v":`), "}")
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,152 @@
+package squeel
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDriver(t *testing.T) {
+	Convey("Given placeholder arguments", t, func() {
+		Convey("It should bind positional and named values as literals", func() {
+			raw, err := bindArgs("SELECT * FROM users WHERE age > ? AND name = :name AND active = ?", []driver.NamedValue{
+				{Ordinal: 1, Value: int64(21)},
+				{Name: "name", Ordinal: 2, Value: "O'Brien"},
+				{Ordinal: 3, Value: true},
+			})
+			So(err, ShouldBeNil)
+			So(raw, ShouldEqual, "select * from users where age > 21 and name = 'O\\'Brien' and active = true")
+		})
+
+		Convey("It should bind arguments into SQL that is rewritten before it is parsed", func() {
+			for sql, filter := range map[string]bson.D{
+				"SELECT * FROM users WHERE name ILIKE ?": {
+					{Key: "name", Value: bson.D{{Key: "$regex", Value: "^ann$"}, {Key: "$options", Value: "i"}}},
+				},
+				"SELECT * FROM users WHERE created_at >= DATE '2020-01-01' AND name = ?": {
+					{Key: "created_at", Value: bson.M{"$gte": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}},
+					{Key: "name", Value: "ann"},
+				},
+				"SELECT * FROM users WHERE EXTRACT(YEAR FROM created_at) = 2020 AND name = ?": {
+					{Key: "$expr", Value: bson.M{"$eq": bson.A{bson.M{"$year": "$created_at"}, int64(2020)}}},
+					{Key: "name", Value: "ann"},
+				},
+				"SELECT * FROM users WHERE address.geo.location.city = ?": {
+					{Key: "address.geo.location.city", Value: "ann"},
+				},
+			} {
+				raw, err := bindArgs(sql, []driver.NamedValue{{Ordinal: 1, Value: "ann"}})
+				So(err, ShouldBeNil)
+
+				q, err := NewStatement(raw).Build(NewQuery())
+				So(err, ShouldBeNil)
+				So(q.Filter, ShouldResemble, filter)
+			}
+		})
+
+		Convey("It should bind times as TIMESTAMP literals that match BSON dates", func() {
+			at := time.Date(2020, 1, 2, 10, 0, 0, 0, time.FixedZone("CET", 3600))
+			raw, err := bindArgs("SELECT * FROM users WHERE created_at >= ? AND active = ?", []driver.NamedValue{
+				{Ordinal: 1, Value: at},
+				{Ordinal: 2, Value: false},
+			})
+			So(err, ShouldBeNil)
+			So(raw, ShouldEqual, "select * from users where created_at >= timestamp '2020-01-02T09:00:00Z' and active = false")
+
+			q, err := NewStatement(raw).Build(NewQuery())
+			So(err, ShouldBeNil)
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "created_at", Value: bson.M{"$gte": at.UTC()}},
+				{Key: "active", Value: false},
+			})
+		})
+	})
+
+	Convey("Given a connector", t, func() {
+		Convey("It should share one client across connections", func() {
+			opened, err := (&Driver{}).OpenConnector("mongodb://localhost:27017/app")
+			So(err, ShouldBeNil)
+
+			first, err := opened.Connect(context.Background())
+			So(err, ShouldBeNil)
+			second, err := opened.Connect(context.Background())
+			So(err, ShouldBeNil)
+
+			So(first.(*conn).client, ShouldBeNil)
+			So(second.(*conn).client, ShouldBeNil)
+			So(first.Close(), ShouldBeNil)
+
+			// Disconnecting an already disconnected client fails, so this shows
+			// that closing a connection left the shared client connected.
+			So(opened.(io.Closer).Close(), ShouldBeNil)
+		})
+
+		Convey("It should require a database in the URI", func() {
+			_, err := (&Driver{}).OpenConnector("mongodb://localhost:27017")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a connection backed by a fake collection", t, func() {
+		fake := &fakeCollection{documents: []interface{}{
+			bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "one"}, {Key: "age", Value: int32(30)}},
+			bson.D{{Key: "_id", Value: 2}, {Key: "name", Value: "two"}, {Key: "email", Value: "two@example.com"}},
+		}}
+		c := &conn{executor: newFakeExecutor(fake)}
+
+		Convey("It should order columns by the SELECT list", func() {
+			// The documents as the projection {age: 1, n: "$name"} returns them
+			fake.documents = []interface{}{
+				bson.D{{Key: "_id", Value: 1}, {Key: "age", Value: int32(30)}, {Key: "n", Value: "one"}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "n", Value: "two"}},
+			}
+
+			r, err := c.QueryContext(context.Background(), "SELECT age, name AS n FROM users WHERE age > ?", []driver.NamedValue{
+				{Ordinal: 1, Value: int64(21)},
+			})
+			So(err, ShouldBeNil)
+			So(r.Columns(), ShouldResemble, []string{"age", "n"})
+			So(fake.find.Projection, ShouldResemble, bson.D{{Key: "age", Value: 1}, {Key: "n", Value: "$name"}})
+
+			dest := make([]driver.Value, 2)
+			So(r.Next(dest), ShouldBeNil)
+			So(dest, ShouldResemble, []driver.Value{int64(30), "one"})
+			So(r.Next(dest), ShouldBeNil)
+			So(dest, ShouldResemble, []driver.Value{nil, "two"})
+		})
+
+		Convey("It should use the union of top-level keys for SELECT *", func() {
+			r, err := c.QueryContext(context.Background(), "SELECT * FROM users", nil)
+			So(err, ShouldBeNil)
+			So(r.Columns(), ShouldResemble, []string{"_id", "name", "age", "email"})
+
+			dest := make([]driver.Value, 4)
+			So(r.Next(dest), ShouldBeNil)
+			So(dest, ShouldResemble, []driver.Value{int64(1), "one", int64(30), nil})
+			So(r.Next(dest), ShouldBeNil)
+			So(dest, ShouldResemble, []driver.Value{int64(2), "two", nil, "two@example.com"})
+			So(r.Next(dest), ShouldEqual, io.EOF)
+		})
+
+		Convey("It should build statements with the options of the connection", func() {
+			c.options = []StatementOption{WithDateFields("users", "created_at")}
+			_, err := c.QueryContext(context.Background(), "SELECT name FROM users WHERE created_at >= ?", []driver.NamedValue{
+				{Ordinal: 1, Value: "2020-01-01"},
+			})
+			So(err, ShouldBeNil)
+			So(fake.filter, ShouldResemble, bson.D{{Key: "created_at", Value: bson.M{"$gte": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}}})
+		})
+
+		Convey("It should fail when the context is cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := c.QueryContext(ctx, "SELECT * FROM users", nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,292 @@
+package squeel
+
+import (
+	"fmt"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+Evaluator runs a built Query against in-memory collections of documents,
+without a MongoDB server. It implements the filter, projection, sort and
+pagination semantics of find operations, and the aggregation stages that
+squeel emits, so translated queries can be checked against real result rows.
+
+Documents are normalized through a BSON round trip when the Evaluator is
+created, so they have the same types they would have when read from MongoDB.
+*/
+type Evaluator struct {
+	collections map[string][]bson.D
+}
+
+/*
+NewEvaluator creates a new Evaluator over the given collections. Each
+collection is a slice of documents, which may be bson.D, bson.M or any
+other value that marshals to a BSON document.
+
+Parameters:
+- collections: The documents of each collection, keyed by collection name
+
+Returns:
+- A new Evaluator instance
+- Any error that occurred while normalizing the documents
+*/
+func NewEvaluator(collections map[string][]interface{}) (*Evaluator, error) {
+	evaluator := &Evaluator{collections: make(map[string][]bson.D, len(collections))}
+
+	for name, documents := range collections {
+		normalized := make([]bson.D, 0, len(documents))
+		for _, document := range documents {
+			doc, err := normalizeDocument(document)
+			if err != nil {
+				return nil, fmt.Errorf("collection %s: %w", name, err)
+			}
+			normalized = append(normalized, doc)
+		}
+		evaluator.collections[name] = normalized
+	}
+
+	return evaluator, nil
+}
+
+/*
+Evaluate runs the Query against its target collection and returns the result
+documents. The shape of the result matches the Executor: count operations
+return a single {count: n} document, and distinct operations return one
+document per value, keyed by the distinct field.
+
+Parameters:
+- q: The built Query to evaluate
+
+Returns:
+- The result documents
+- Any error that occurred during evaluation
+*/
+func (evaluator *Evaluator) Evaluate(q *Query) ([]bson.D, error) {
+	if q == nil {
+		return nil, fmt.Errorf("query is nil")
+	}
+
+	if q.VerifyOperation() || q.VerifyCollection() {
+		return nil, fmt.Errorf("query is incomplete: operation=%q collection=%q", q.Operation, q.Collection)
+	}
+
+	documents := evaluator.collection(q.Collection)
+
+	switch q.Operation {
+	case "find":
+		return evaluator.find(documents, q, q.Limit)
+	case "findone":
+		one := int64(1)
+		return evaluator.find(documents, q, &one)
+	case "aggregate":
+		pipeline, err := normalizeValue(q.Pipeline)
+		if err != nil {
+			return nil, err
+		}
+		return evaluator.runPipeline(documents, asArray(pipeline), nil)
+	case "count":
+		return evaluator.count(documents, q)
+	case "distinct":
+		return evaluator.distinct(documents, q)
+	}
+
+	return nil, fmt.Errorf("unsupported operation: %s", q.Operation)
+}
+
+/*
+collection returns a copy of the named collection's document slice, so stages
+can reorder it without affecting the Evaluator.
+*/
+func (evaluator *Evaluator) collection(name string) []bson.D {
+	return append([]bson.D(nil), evaluator.collections[name]...)
+}
+
+/*
+find applies the Query's filter, sort, offset, limit and projection, in the
+order MongoDB applies them.
+*/
+func (evaluator *Evaluator) find(documents []bson.D, q *Query, limit *int64) ([]bson.D, error) {
+	documents, err := evaluator.filter(documents, q.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(q.Sort) > 0 {
+		spec, err := normalizeDocument(q.Sort)
+		if err != nil {
+			return nil, err
+		}
+		sortDocuments(documents, spec)
+	}
+
+	documents = paginate(documents, q.Offset, limit)
+
+	if len(q.Projection) == 0 {
+		return documents, nil
+	}
+
+	spec, err := normalizeDocument(q.Projection)
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluator.project(documents, spec, nil)
+}
+
+/*
+count returns the number of documents matching the Query's filter. As in
+SQL, the offset and limit apply to the single {count: n} document rather
+than to the documents that are counted.
+*/
+func (evaluator *Evaluator) count(documents []bson.D, q *Query) ([]bson.D, error) {
+	documents, err := evaluator.filter(documents, q.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return paginate([]bson.D{{{Key: "count", Value: int64(len(documents))}}}, q.Offset, q.Limit), nil
+}
+
+/*
+distinct returns the distinct values of the first projected field among the
+documents matching the Query's filter. Array values contribute each of their
+elements, as they do in MongoDB.
+*/
+func (evaluator *Evaluator) distinct(documents []bson.D, q *Query) ([]bson.D, error) {
+	if len(q.Projection) == 0 {
+		return nil, fmt.Errorf("distinct requires a projected field")
+	}
+
+	documents, err := evaluator.filter(documents, q.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	field := q.Projection[0].Key
+	seen := newValueSet()
+	out := make([]bson.D, 0)
+
+	for _, document := range documents {
+		for _, value := range lookupCandidates(document, field) {
+			if _, isArray := value.(bson.A); isArray {
+				continue
+			}
+			if seen.add(value) {
+				out = append(out, bson.D{{Key: field, Value: value}})
+			}
+		}
+	}
+
+	return out, nil
+}
+
+/*
+filter returns the documents that match a filter document.
+*/
+func (evaluator *Evaluator) filter(documents []bson.D, filter interface{}) ([]bson.D, error) {
+	spec, err := normalizeValue(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return evaluator.match(documents, asDocument(spec), nil)
+}
+
+/*
+match returns the documents that match an already normalized filter. The
+variables are those in scope for $expr, such as the let variables of a
+pipeline-style $lookup.
+*/
+func (evaluator *Evaluator) match(documents []bson.D, filter bson.D, vars map[string]interface{}) ([]bson.D, error) {
+	out := make([]bson.D, 0, len(documents))
+
+	for _, document := range documents {
+		ok, err := matchDocument(document, filter, vars)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, document)
+		}
+	}
+
+	return out, nil
+}
+
+/*
+paginate applies an offset and a limit to a slice of documents.
+*/
+func paginate(documents []bson.D, offset, limit *int64) []bson.D {
+	if offset != nil && *offset > 0 {
+		if *offset >= int64(len(documents)) {
+			return []bson.D{}
+		}
+		documents = documents[*offset:]
+	}
+
+	if limit != nil && *limit > 0 && *limit < int64(len(documents)) {
+		documents = documents[:*limit]
+	}
+
+	return documents
+}
+
+/*
+sortDocuments sorts documents in place by a sort specification of the form
+{field: 1|-1, ...}. The sort is stable, so documents that compare equal keep
+their original order.
+*/
+func sortDocuments(documents []bson.D, spec bson.D) {
+	sort.SliceStable(documents, func(i, j int) bool {
+		for _, key := range spec {
+			descending := toInt(key.Value) < 0
+			left, _ := fieldValue(documents[i], key.Key)
+			right, _ := fieldValue(documents[j], key.Key)
+
+			cmp := compareValues(sortValue(left, descending), sortValue(right, descending))
+			if cmp == 0 {
+				continue
+			}
+
+			if descending {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+}
+
+/*
+sortValue returns the value a document is sorted by. As in MongoDB, an array
+sorts by its smallest element in an ascending sort and by its largest in a
+descending one, and an empty array sorts before null.
+
+Parameters:
+- value: The value of the sort field
+- descending: Whether the sort is descending
+
+Returns:
+- The value to compare
+*/
+func sortValue(value interface{}, descending bool) interface{} {
+	array, ok := value.(bson.A)
+	if !ok {
+		return value
+	}
+
+	if len(array) == 0 {
+		return primitive.MinKey{}
+	}
+
+	extreme := array[0]
+	for _, element := range array[1:] {
+		if cmp := compareValues(element, extreme); descending && cmp > 0 || !descending && cmp < 0 {
+			extreme = element
+		}
+	}
+	return extreme
+}
@@ -0,0 +1,277 @@
+package squeel
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+dateArgument reads a date from the evaluated arguments of a date operator,
+in UTC. Null gives a zero time and false, so the operator yields null.
+
+Parameters:
+- name: The name of the operator, for errors
+- value: The value to read
+
+Returns:
+- The time
+- Whether the value is a date
+- An error when the value is neither a date nor null
+*/
+func dateArgument(name string, value interface{}) (time.Time, bool, error) {
+	switch value.(type) {
+	case primitive.DateTime, time.Time:
+		return toTime(value), true, nil
+	}
+	if isNullish(value) {
+		return time.Time{}, false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("%s requires a date, got %T", name, value)
+}
+
+/*
+dateSpec reads the date field of the document argument of a date operator,
+such as the startDate of $dateAdd, and the unit it is given.
+*/
+func dateSpec(name string, args bson.A, field string) (bson.D, time.Time, bool, error) {
+	spec, ok := args[0].(bson.D)
+	if len(args) != 1 || !ok {
+		return nil, time.Time{}, false, fmt.Errorf("%s requires a document", name)
+	}
+
+	value, _ := documentField(spec, field)
+	t, ok, err := dateArgument(name, value)
+	return spec, t, ok, err
+}
+
+/*
+exprDatePart returns an operator returning a part of a date, such as $year.
+$dayOfWeek counts from 1 for Sunday, and $week counts the weeks starting on
+Sunday, with the days before the first Sunday in week 0.
+*/
+func exprDatePart(name string) exprOperator {
+	return func(args bson.A) (interface{}, error) {
+		if err := requireArgs(name, args, 1); err != nil {
+			return nil, err
+		}
+
+		t, ok, err := dateArgument(name, args[0])
+		if !ok {
+			return nil, err
+		}
+
+		switch name {
+		case "$year":
+			return int32(t.Year()), nil
+		case "$month":
+			return int32(t.Month()), nil
+		case "$dayOfMonth":
+			return int32(t.Day()), nil
+		case "$dayOfWeek":
+			return int32(t.Weekday()) + 1, nil
+		case "$dayOfYear":
+			return int32(t.YearDay()), nil
+		case "$week":
+			return int32((t.YearDay() + 6 - int(t.Weekday())) / 7), nil
+		case "$hour":
+			return int32(t.Hour()), nil
+		case "$minute":
+			return int32(t.Minute()), nil
+		}
+		return int32(t.Second()), nil
+	}
+}
+
+/*
+exprDateTrunc rounds a date down to the start of its year, quarter, month,
+week (starting on Sunday), day, hour, minute or second.
+*/
+func exprDateTrunc(args bson.A) (interface{}, error) {
+	spec, t, ok, err := dateSpec("$dateTrunc", args, "date")
+	if !ok {
+		return nil, err
+	}
+
+	unit, _ := documentField(spec, "unit")
+	truncated, err := truncateTime(t, stringOf(unit))
+	if err != nil {
+		return nil, err
+	}
+	return primitive.NewDateTimeFromTime(truncated), nil
+}
+
+/*
+truncateTime rounds a time down to a unit.
+*/
+func truncateTime(t time.Time, unit string) (time.Time, error) {
+	switch unit {
+	case "year":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC), nil
+	case "quarter":
+		return time.Date(t.Year(), (t.Month()-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC), nil
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -int(day.Weekday())), nil
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	case "hour":
+		return t.Truncate(time.Hour), nil
+	case "minute":
+		return t.Truncate(time.Minute), nil
+	case "second":
+		return t.Truncate(time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("unsupported date unit: %s", unit)
+}
+
+/*
+exprDateToString formats a date with the %Y, %m, %d, %H, %M, %S, %L, %j and
+%U specifiers of $dateToString.
+*/
+func exprDateToString(args bson.A) (interface{}, error) {
+	spec, t, ok, err := dateSpec("$dateToString", args, "date")
+	if !ok {
+		return nil, err
+	}
+
+	format := "%Y-%m-%dT%H:%M:%S.%LZ"
+	if value, ok := documentField(spec, "format"); ok {
+		format = stringOf(value)
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			out.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&out, "%04d", t.Year())
+		case 'm':
+			fmt.Fprintf(&out, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&out, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&out, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&out, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&out, "%02d", t.Second())
+		case 'L':
+			fmt.Fprintf(&out, "%03d", t.Nanosecond()/int(time.Millisecond))
+		case 'j':
+			fmt.Fprintf(&out, "%03d", t.YearDay())
+		case 'U':
+			fmt.Fprintf(&out, "%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+		case '%':
+			out.WriteByte('%')
+		default:
+			return nil, fmt.Errorf("unsupported $dateToString format specifier: %%%c", format[i])
+		}
+	}
+	return out.String(), nil
+}
+
+/*
+exprDateAdd returns $dateAdd, or $dateSubtract for a negative sign. Adding
+months to the end of a month ends at the end of the target month, as in
+MongoDB, rather than overflowing into the next one.
+*/
+func exprDateAdd(sign int) exprOperator {
+	return func(args bson.A) (interface{}, error) {
+		spec, t, ok, err := dateSpec("$dateAdd", args, "startDate")
+		if !ok {
+			return nil, err
+		}
+
+		unit, _ := documentField(spec, "unit")
+		value, _ := documentField(spec, "amount")
+		if isNullish(value) {
+			return nil, nil
+		}
+		amount := sign * int(toInt(value))
+
+		switch stringOf(unit) {
+		case "year":
+			t = addMonths(t, 12*amount)
+		case "quarter":
+			t = addMonths(t, 3*amount)
+		case "month":
+			t = addMonths(t, amount)
+		case "week":
+			t = t.AddDate(0, 0, 7*amount)
+		case "day":
+			t = t.AddDate(0, 0, amount)
+		case "hour":
+			t = t.Add(time.Duration(amount) * time.Hour)
+		case "minute":
+			t = t.Add(time.Duration(amount) * time.Minute)
+		case "second":
+			t = t.Add(time.Duration(amount) * time.Second)
+		default:
+			return nil, fmt.Errorf("unsupported date unit: %v", unit)
+		}
+		return primitive.NewDateTimeFromTime(t), nil
+	}
+}
+
+/*
+addMonths adds months to a time, keeping the day within the target month.
+*/
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC).AddDate(0, months, 0)
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(t.Day(), last)-1)
+}
+
+/*
+exprDateDiff counts the boundaries of a unit that are crossed between a
+start and an end date, negative when the end comes first.
+*/
+func exprDateDiff(args bson.A) (interface{}, error) {
+	spec, start, ok, err := dateSpec("$dateDiff", args, "startDate")
+	if !ok {
+		return nil, err
+	}
+
+	value, _ := documentField(spec, "endDate")
+	end, ok, err := dateArgument("$dateDiff", value)
+	if !ok {
+		return nil, err
+	}
+
+	unit, _ := documentField(spec, "unit")
+	switch stringOf(unit) {
+	case "year":
+		return int64(end.Year() - start.Year()), nil
+	case "quarter":
+		return int64((end.Year()*12+int(end.Month())-1)/3 - (start.Year()*12+int(start.Month())-1)/3), nil
+	case "month":
+		return int64(end.Year()*12 + int(end.Month()) - start.Year()*12 - int(start.Month())), nil
+	}
+
+	steps := map[string]time.Duration{
+		"week":   7 * 24 * time.Hour,
+		"day":    24 * time.Hour,
+		"hour":   time.Hour,
+		"minute": time.Minute,
+		"second": time.Second,
+	}
+	step, ok := steps[stringOf(unit)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported date unit: %v", unit)
+	}
+
+	from, _ := truncateTime(start, stringOf(unit))
+	to, _ := truncateTime(end, stringOf(unit))
+	return int64(to.Sub(from) / step), nil
+}
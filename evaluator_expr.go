@@ -0,0 +1,797 @@
+package squeel
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+exprOperator evaluates an aggregation expression operator against its
+already evaluated arguments.
+*/
+type exprOperator func(args bson.A) (interface{}, error)
+
+/*
+exprOperators maps aggregation expression operators whose arguments are all
+evaluated up front. Operators that evaluate their arguments lazily, such as
+$cond and $literal, are handled in evalOperator.
+*/
+var exprOperators = map[string]exprOperator{
+	"$add":           exprAdd,
+	"$subtract":      exprSubtract,
+	"$multiply":      exprMultiply,
+	"$divide":        exprDivide,
+	"$mod":           exprMod,
+	"$eq":            exprCompare("$eq"),
+	"$ne":            exprCompare("$ne"),
+	"$gt":            exprCompare("$gt"),
+	"$gte":           exprCompare("$gte"),
+	"$lt":            exprCompare("$lt"),
+	"$lte":           exprCompare("$lte"),
+	"$cmp":           exprCmp,
+	"$not":           exprNot,
+	"$in":            exprIn,
+	"$size":          exprSize,
+	"$setDifference": exprSetDifference,
+	"$concat":        exprConcat,
+	"$toUpper":       exprToUpper,
+	"$toLower":       exprToLower,
+	"$substrCP":      exprSubstrCP,
+	"$strLenCP":      exprStrLen("$strLenCP"),
+	"$strLenBytes":   exprStrLen("$strLenBytes"),
+	"$trim":          exprTrim("$trim"),
+	"$ltrim":         exprTrim("$ltrim"),
+	"$rtrim":         exprTrim("$rtrim"),
+	"$replaceAll":    exprReplaceAll,
+	"$split":         exprSplit,
+	"$indexOfCP":     exprIndexOfCP,
+	"$year":          exprDatePart("$year"),
+	"$month":         exprDatePart("$month"),
+	"$dayOfMonth":    exprDatePart("$dayOfMonth"),
+	"$dayOfWeek":     exprDatePart("$dayOfWeek"),
+	"$dayOfYear":     exprDatePart("$dayOfYear"),
+	"$week":          exprDatePart("$week"),
+	"$hour":          exprDatePart("$hour"),
+	"$minute":        exprDatePart("$minute"),
+	"$second":        exprDatePart("$second"),
+	"$dateTrunc":     exprDateTrunc,
+	"$dateToString":  exprDateToString,
+	"$dateAdd":       exprDateAdd(1),
+	"$dateSubtract":  exprDateAdd(-1),
+	"$dateDiff":      exprDateDiff,
+	"$ifNull":        exprIfNull,
+	"$sum":           exprArrayAccumulator("$sum"),
+	"$avg":           exprArrayAccumulator("$avg"),
+	"$min":           exprArrayAccumulator("$min"),
+	"$max":           exprArrayAccumulator("$max"),
+	"$first":         exprArrayElem(0),
+	"$last":          exprArrayElem(-1),
+	"$arrayElemAt":   exprArrayElemAt,
+}
+
+/*
+evalExpr evaluates an aggregation expression against a document. Strings
+starting with $ are field paths, strings starting with $$ are variables,
+documents whose first key starts with $ are operators, and everything else
+is a literal, with embedded documents and arrays evaluated element-wise.
+
+Parameters:
+- expr: The normalized expression to evaluate
+- document: The current document
+- vars: The variables in scope, such as let variables of a $lookup
+
+Returns:
+- The value of the expression; nil when a field path is missing
+- Any error caused by an unsupported or malformed operator
+*/
+func evalExpr(expr interface{}, document bson.D, vars map[string]interface{}) (interface{}, error) {
+	switch expr := expr.(type) {
+	case string:
+		return evalPath(expr, document, vars), nil
+	case bson.A:
+		out := make(bson.A, 0, len(expr))
+		for _, element := range expr {
+			value, err := evalExpr(element, document, vars)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, value)
+		}
+		return out, nil
+	case bson.D:
+		if isOperatorDocument(expr) {
+			return evalOperator(expr[0].Key, expr[0].Value, document, vars)
+		}
+
+		out := make(bson.D, 0, len(expr))
+		for _, element := range expr {
+			value, err := evalExpr(element.Value, document, vars)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, bson.E{Key: element.Key, Value: value})
+		}
+		return out, nil
+	}
+
+	return expr, nil
+}
+
+/*
+evalPath resolves a "$field.path" or "$$variable.path" string. Any other
+string is returned as a literal.
+*/
+func evalPath(expr string, document bson.D, vars map[string]interface{}) interface{} {
+	if strings.HasPrefix(expr, "$$") {
+		name, path, nested := strings.Cut(expr[2:], ".")
+
+		var value interface{}
+		switch name {
+		case "ROOT", "CURRENT":
+			value = document
+		case "NOW":
+			value = primitive.NewDateTimeFromTime(time.Now())
+		default:
+			value = vars[name]
+		}
+
+		if !nested {
+			return value
+		}
+
+		if embedded, ok := value.(bson.D); ok {
+			out, _ := fieldValue(embedded, path)
+			return out
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(expr, "$") {
+		value, _ := fieldValue(document, expr[1:])
+		return value
+	}
+
+	return expr
+}
+
+/*
+evalOperator evaluates a single aggregation operator. Lazily evaluated
+operators are handled here; all others evaluate their arguments and are
+dispatched through exprOperators.
+*/
+func evalOperator(name string, arg interface{}, document bson.D, vars map[string]interface{}) (interface{}, error) {
+	switch name {
+	case "$literal":
+		return arg, nil
+	case "$and":
+		for _, element := range argList(arg) {
+			value, err := evalExpr(element, document, vars)
+			if err != nil || !isTruthy(value) {
+				return false, err
+			}
+		}
+		return true, nil
+	case "$or":
+		for _, element := range argList(arg) {
+			value, err := evalExpr(element, document, vars)
+			if err != nil || isTruthy(value) {
+				return err == nil, err
+			}
+		}
+		return false, nil
+	case "$cond":
+		return evalCond(arg, document, vars)
+	case "$switch":
+		return evalSwitch(arg, document, vars)
+	case "$getField":
+		return evalGetField(arg, document, vars)
+	}
+
+	operator, ok := exprOperators[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported expression operator: %s", name)
+	}
+
+	args, err := evalExpr(argList(arg), document, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	return operator(args.(bson.A))
+}
+
+/*
+argList returns the arguments of an operator as an array, wrapping a single
+argument such as {$size: "$tags"}.
+*/
+func argList(arg interface{}) bson.A {
+	if array, ok := arg.(bson.A); ok {
+		return array
+	}
+	return bson.A{arg}
+}
+
+/*
+evalCond evaluates $cond in both its array form [if, then, else] and its
+document form {if, then, else}, only evaluating the selected branch.
+*/
+func evalCond(arg interface{}, document bson.D, vars map[string]interface{}) (interface{}, error) {
+	var branches [3]interface{}
+
+	switch arg := arg.(type) {
+	case bson.A:
+		if len(arg) != 3 {
+			return nil, fmt.Errorf("$cond requires 3 arguments, got %d", len(arg))
+		}
+		copy(branches[:], arg)
+	case bson.D:
+		branches[0], _ = documentField(arg, "if")
+		branches[1], _ = documentField(arg, "then")
+		branches[2], _ = documentField(arg, "else")
+	default:
+		return nil, fmt.Errorf("$cond requires an array or document")
+	}
+
+	condition, err := evalExpr(branches[0], document, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if isTruthy(condition) {
+		return evalExpr(branches[1], document, vars)
+	}
+	return evalExpr(branches[2], document, vars)
+}
+
+/*
+evalSwitch evaluates $switch, returning the value of the first branch whose
+case is true, or the default when none is.
+*/
+func evalSwitch(arg interface{}, document bson.D, vars map[string]interface{}) (interface{}, error) {
+	spec, ok := arg.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("$switch requires a document")
+	}
+
+	branches, _ := documentField(spec, "branches")
+	for _, branch := range argList(branches) {
+		branch, ok := branch.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("$switch requires documents as branches")
+		}
+
+		condition, _ := documentField(branch, "case")
+		value, err := evalExpr(condition, document, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		if isTruthy(value) {
+			then, _ := documentField(branch, "then")
+			return evalExpr(then, document, vars)
+		}
+	}
+
+	otherwise, ok := documentField(spec, "default")
+	if !ok {
+		return nil, fmt.Errorf("$switch matched no branch and has no default")
+	}
+	return evalExpr(otherwise, document, vars)
+}
+
+/*
+evalGetField evaluates $getField in both its short form, a field name read
+from the current document, and its document form {field, input}. The field
+name is taken as it is, so it may contain dots or start with a dollar sign.
+*/
+func evalGetField(arg interface{}, document bson.D, vars map[string]interface{}) (interface{}, error) {
+	var field, input interface{} = arg, "$$CURRENT"
+
+	if arg, ok := arg.(bson.D); ok && !isOperatorDocument(arg) {
+		field, _ = documentField(arg, "field")
+		if value, ok := documentField(arg, "input"); ok {
+			input = value
+		}
+	}
+
+	name, err := evalExpr(field, document, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := name.(string); !ok {
+		return nil, fmt.Errorf("$getField requires a string field name")
+	}
+
+	value, err := evalExpr(input, document, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	embedded, ok := value.(bson.D)
+	if !ok {
+		return nil, nil
+	}
+
+	out, _ := documentField(embedded, name.(string))
+	return out, nil
+}
+
+/*
+requireArgs checks the number of arguments of an operator.
+*/
+func requireArgs(name string, args bson.A, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("%s requires %d arguments, got %d", name, n, len(args))
+	}
+	return nil
+}
+
+/*
+isNullish reports whether a value is null or missing.
+*/
+func isNullish(value interface{}) bool {
+	return typeOrder(value) == typeOrder(nil)
+}
+
+/*
+numericResult returns an integer result as int64 when every operand was an
+integer, and as float64 otherwise.
+*/
+func numericResult(f float64, integers bool) interface{} {
+	if integers && f == math.Trunc(f) {
+		return int64(f)
+	}
+	return f
+}
+
+/*
+exprAdd adds numbers.
+*/
+func exprAdd(args bson.A) (interface{}, error) {
+	sum, integers := 0.0, true
+	for _, arg := range args {
+		if isNullish(arg) {
+			return nil, nil
+		}
+		if !isNumber(arg) {
+			return nil, fmt.Errorf("$add only supports numeric types, got %T", arg)
+		}
+		sum += toFloat(arg)
+		integers = integers && isInteger(arg)
+	}
+	return numericResult(sum, integers), nil
+}
+
+/*
+exprSubtract subtracts the second number from the first.
+*/
+func exprSubtract(args bson.A) (interface{}, error) {
+	if err := requireArgs("$subtract", args, 2); err != nil {
+		return nil, err
+	}
+	if isNullish(args[0]) || isNullish(args[1]) {
+		return nil, nil
+	}
+	if !isNumber(args[0]) || !isNumber(args[1]) {
+		return nil, fmt.Errorf("$subtract only supports numeric types")
+	}
+	return numericResult(toFloat(args[0])-toFloat(args[1]), isInteger(args[0]) && isInteger(args[1])), nil
+}
+
+/*
+exprMultiply multiplies numbers.
+*/
+func exprMultiply(args bson.A) (interface{}, error) {
+	product, integers := 1.0, true
+	for _, arg := range args {
+		if isNullish(arg) {
+			return nil, nil
+		}
+		if !isNumber(arg) {
+			return nil, fmt.Errorf("$multiply only supports numeric types, got %T", arg)
+		}
+		product *= toFloat(arg)
+		integers = integers && isInteger(arg)
+	}
+	return numericResult(product, integers), nil
+}
+
+/*
+exprDivide divides the first number by the second, always yielding a double.
+*/
+func exprDivide(args bson.A) (interface{}, error) {
+	if err := requireArgs("$divide", args, 2); err != nil {
+		return nil, err
+	}
+	if isNullish(args[0]) || isNullish(args[1]) {
+		return nil, nil
+	}
+	if toFloat(args[1]) == 0 {
+		return nil, fmt.Errorf("can't $divide by zero")
+	}
+	return toFloat(args[0]) / toFloat(args[1]), nil
+}
+
+/*
+exprMod returns the remainder of dividing the first number by the second.
+*/
+func exprMod(args bson.A) (interface{}, error) {
+	if err := requireArgs("$mod", args, 2); err != nil {
+		return nil, err
+	}
+	if isNullish(args[0]) || isNullish(args[1]) {
+		return nil, nil
+	}
+	if toFloat(args[1]) == 0 {
+		return nil, fmt.Errorf("can't $mod by zero")
+	}
+	return numericResult(math.Mod(toFloat(args[0]), toFloat(args[1])), isInteger(args[0]) && isInteger(args[1])), nil
+}
+
+/*
+exprCompare returns an operator comparing two values of any type, using
+MongoDB's cross-type ordering.
+*/
+func exprCompare(name string) exprOperator {
+	return func(args bson.A) (interface{}, error) {
+		if err := requireArgs(name, args, 2); err != nil {
+			return nil, err
+		}
+		return satisfiesComparison(name, compareValues(args[0], args[1])), nil
+	}
+}
+
+/*
+exprCmp returns -1, 0 or 1 as the first value is less than, equal to or
+greater than the second.
+*/
+func exprCmp(args bson.A) (interface{}, error) {
+	if err := requireArgs("$cmp", args, 2); err != nil {
+		return nil, err
+	}
+
+	cmp := compareValues(args[0], args[1])
+	switch {
+	case cmp < 0:
+		return int32(-1), nil
+	case cmp > 0:
+		return int32(1), nil
+	}
+	return int32(0), nil
+}
+
+/*
+exprNot negates the truthiness of its argument.
+*/
+func exprNot(args bson.A) (interface{}, error) {
+	if err := requireArgs("$not", args, 1); err != nil {
+		return nil, err
+	}
+	return !isTruthy(args[0]), nil
+}
+
+/*
+exprIn reports whether a value is an element of an array.
+*/
+func exprIn(args bson.A) (interface{}, error) {
+	if err := requireArgs("$in", args, 2); err != nil {
+		return nil, err
+	}
+
+	array, ok := args[1].(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("$in requires an array as a second argument")
+	}
+
+	for _, element := range array {
+		if valuesEqual(args[0], element) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/*
+exprSetDifference returns the distinct elements of the first array that do
+not occur in the second.
+*/
+func exprSetDifference(args bson.A) (interface{}, error) {
+	if err := requireArgs("$setDifference", args, 2); err != nil {
+		return nil, err
+	}
+
+	left, lok := args[0].(bson.A)
+	right, rok := args[1].(bson.A)
+	if !lok || !rok {
+		return nil, fmt.Errorf("both operands of $setDifference must be arrays")
+	}
+
+	exclude := newValueSet()
+	for _, element := range right {
+		exclude.add(element)
+	}
+
+	out := bson.A{}
+	for _, element := range left {
+		if exclude.add(element) {
+			out = append(out, element)
+		}
+	}
+	return out, nil
+}
+
+/*
+exprSize returns the number of elements of an array.
+*/
+func exprSize(args bson.A) (interface{}, error) {
+	if err := requireArgs("$size", args, 1); err != nil {
+		return nil, err
+	}
+
+	array, ok := args[0].(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("the argument to $size must be an array")
+	}
+	return int32(len(array)), nil
+}
+
+/*
+exprConcat concatenates strings, yielding null when any argument is null.
+*/
+func exprConcat(args bson.A) (interface{}, error) {
+	var builder strings.Builder
+	for _, arg := range args {
+		if isNullish(arg) {
+			return nil, nil
+		}
+		s, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("$concat only supports strings, got %T", arg)
+		}
+		builder.WriteString(s)
+	}
+	return builder.String(), nil
+}
+
+/*
+exprToUpper converts a string to upper case; null becomes the empty string.
+*/
+func exprToUpper(args bson.A) (interface{}, error) {
+	if err := requireArgs("$toUpper", args, 1); err != nil {
+		return nil, err
+	}
+	return strings.ToUpper(stringOf(args[0])), nil
+}
+
+/*
+exprToLower converts a string to lower case; null becomes the empty string.
+*/
+func exprToLower(args bson.A) (interface{}, error) {
+	if err := requireArgs("$toLower", args, 1); err != nil {
+		return nil, err
+	}
+	return strings.ToLower(stringOf(args[0])), nil
+}
+
+/*
+exprSubstrCP returns the substring of a string starting at a code point
+index with a number of code points; null becomes the empty string.
+*/
+func exprSubstrCP(args bson.A) (interface{}, error) {
+	if err := requireArgs("$substrCP", args, 3); err != nil {
+		return nil, err
+	}
+	if !isNumber(args[1]) || !isNumber(args[2]) || toInt(args[1]) < 0 || toInt(args[2]) < 0 {
+		return nil, fmt.Errorf("$substrCP requires a non-negative index and count")
+	}
+
+	runes := []rune(stringOf(args[0]))
+	start := min(int(toInt(args[1])), len(runes))
+	end := min(start+int(toInt(args[2])), len(runes))
+	return string(runes[start:end]), nil
+}
+
+/*
+exprStrLen returns $strLenCP, which counts the code points of a string, or
+$strLenBytes, which counts its UTF-8 bytes.
+*/
+func exprStrLen(name string) exprOperator {
+	return func(args bson.A) (interface{}, error) {
+		if err := requireArgs(name, args, 1); err != nil {
+			return nil, err
+		}
+
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s requires a string, got %T", name, args[0])
+		}
+
+		if name == "$strLenBytes" {
+			return int32(len(s)), nil
+		}
+		return int32(len([]rune(s))), nil
+	}
+}
+
+/*
+exprTrim returns $trim, $ltrim or $rtrim, removing the given characters, or
+whitespace, from the ends of the input; null stays null.
+*/
+func exprTrim(name string) exprOperator {
+	return func(args bson.A) (interface{}, error) {
+		spec, ok := args[0].(bson.D)
+		if len(args) != 1 || !ok {
+			return nil, fmt.Errorf("%s requires a document", name)
+		}
+
+		input, _ := documentField(spec, "input")
+		if isNullish(input) {
+			return nil, nil
+		}
+
+		chars := " \t\n\v\f\r\x00"
+		if value, ok := documentField(spec, "chars"); ok {
+			chars = stringOf(value)
+		}
+
+		switch name {
+		case "$ltrim":
+			return strings.TrimLeft(stringOf(input), chars), nil
+		case "$rtrim":
+			return strings.TrimRight(stringOf(input), chars), nil
+		}
+		return strings.Trim(stringOf(input), chars), nil
+	}
+}
+
+/*
+exprReplaceAll replaces every occurrence of a string in the input; null in
+any of its fields gives null.
+*/
+func exprReplaceAll(args bson.A) (interface{}, error) {
+	spec, ok := args[0].(bson.D)
+	if len(args) != 1 || !ok {
+		return nil, fmt.Errorf("$replaceAll requires a document")
+	}
+
+	input, _ := documentField(spec, "input")
+	find, _ := documentField(spec, "find")
+	replacement, _ := documentField(spec, "replacement")
+	if isNullish(input) || isNullish(find) || isNullish(replacement) {
+		return nil, nil
+	}
+
+	return strings.ReplaceAll(stringOf(input), stringOf(find), stringOf(replacement)), nil
+}
+
+/*
+exprSplit splits a string on a delimiter into an array; null stays null.
+*/
+func exprSplit(args bson.A) (interface{}, error) {
+	if err := requireArgs("$split", args, 2); err != nil {
+		return nil, err
+	}
+	if isNullish(args[0]) {
+		return nil, nil
+	}
+
+	out := bson.A{}
+	for _, part := range strings.Split(stringOf(args[0]), stringOf(args[1])) {
+		out = append(out, part)
+	}
+	return out, nil
+}
+
+/*
+exprIndexOfCP returns the code point index of the first occurrence of a
+substring at or after an optional start index, or -1; null stays null.
+*/
+func exprIndexOfCP(args bson.A) (interface{}, error) {
+	if len(args) < 2 || len(args) > 4 {
+		return nil, fmt.Errorf("$indexOfCP requires 2 to 4 arguments, got %d", len(args))
+	}
+	if isNullish(args[0]) {
+		return nil, nil
+	}
+
+	runes := []rune(stringOf(args[0]))
+	start, end := 0, len(runes)
+	if len(args) > 2 {
+		start = min(int(toInt(args[2])), len(runes))
+	}
+	if len(args) > 3 {
+		end = max(min(int(toInt(args[3])), len(runes)), start)
+	}
+
+	index := strings.Index(string(runes[start:end]), stringOf(args[1]))
+	if index < 0 {
+		return int32(-1), nil
+	}
+	return int32(start + len([]rune(string(runes[start:end])[:index]))), nil
+}
+
+/*
+exprIfNull returns the first argument that is not null.
+*/
+func exprIfNull(args bson.A) (interface{}, error) {
+	for _, arg := range args {
+		if !isNullish(arg) {
+			return arg, nil
+		}
+	}
+	return nil, nil
+}
+
+/*
+exprArrayAccumulator returns an operator that applies an accumulator to the
+elements of an array argument, or to a list of arguments, as $sum, $avg,
+$min and $max do outside of $group.
+*/
+func exprArrayAccumulator(name string) exprOperator {
+	return func(args bson.A) (interface{}, error) {
+		values := args
+		if len(args) == 1 {
+			if array, ok := args[0].(bson.A); ok {
+				values = array
+			}
+		}
+
+		accumulator := newAccumulator(name)
+		for _, value := range values {
+			accumulator.add(value)
+		}
+		return accumulator.result(), nil
+	}
+}
+
+/*
+exprArrayElem returns an operator yielding the element of an array at an
+index, where negative indexes count from the end.
+*/
+func exprArrayElem(index int) exprOperator {
+	return func(args bson.A) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("array element operators require 1 argument, got %d", len(args))
+		}
+
+		array, ok := args[0].(bson.A)
+		if !ok || len(array) == 0 {
+			return nil, nil
+		}
+		if index < 0 {
+			return array[len(array)+index], nil
+		}
+		return array[index], nil
+	}
+}
+
+/*
+exprArrayElemAt returns the element of an array at the index given as its
+second argument, where negative indexes count from the end. An index out of
+range yields null.
+*/
+func exprArrayElemAt(args bson.A) (interface{}, error) {
+	if err := requireArgs("$arrayElemAt", args, 2); err != nil {
+		return nil, err
+	}
+
+	array, ok := args[0].(bson.A)
+	if !ok {
+		return nil, nil
+	}
+
+	index := int(toInt(args[1]))
+	if index < 0 {
+		index += len(array)
+	}
+	if index < 0 || index >= len(array) {
+		return nil, nil
+	}
+	return array[index], nil
+}
@@ -0,0 +1,373 @@
+package squeel
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+matchDocument reports whether a document matches a normalized filter. Every
+element of the filter must match, as with an implicit $and.
+
+Parameters:
+- document: The document to test
+- filter: The filter document
+- vars: The variables in scope for $expr
+
+Returns:
+- Whether the document matches
+- Any error caused by an unsupported or malformed operator
+*/
+func matchDocument(document bson.D, filter bson.D, vars map[string]interface{}) (bool, error) {
+	for _, element := range filter {
+		ok, err := matchElement(document, element, vars)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+/*
+matchElement matches a single filter element, which is either a logical
+operator, an $expr, or a condition on a field.
+*/
+func matchElement(document bson.D, element bson.E, vars map[string]interface{}) (bool, error) {
+	switch element.Key {
+	case "$and", "$or", "$nor":
+		return matchLogical(document, element.Key, asArray(element.Value), vars)
+	case "$expr":
+		value, err := evalExpr(element.Value, document, vars)
+		if err != nil {
+			return false, err
+		}
+		return isTruthy(value), nil
+	case "$comment":
+		return true, nil
+	}
+
+	if strings.HasPrefix(element.Key, "$") {
+		return false, fmt.Errorf("unsupported top-level operator: %s", element.Key)
+	}
+
+	return matchField(lookupCandidates(document, element.Key), element.Value)
+}
+
+/*
+matchLogical evaluates $and, $or and $nor over an array of filters.
+*/
+func matchLogical(document bson.D, operator string, filters bson.A, vars map[string]interface{}) (bool, error) {
+	for _, filter := range filters {
+		ok, err := matchDocument(document, asDocument(filter), vars)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case operator == "$and" && !ok:
+			return false, nil
+		case operator == "$or" && ok:
+			return true, nil
+		case operator == "$nor" && ok:
+			return false, nil
+		}
+	}
+
+	return operator != "$or", nil
+}
+
+/*
+lookupCandidates returns every value a filter on a dotted path is tested
+against. Arrays along the path are traversed, and an array at the end of the
+path contributes both itself and each of its elements, so {tags: "a"} matches
+a document whose tags array contains "a".
+
+Parameters:
+- value: The document or value to read from
+- path: The dotted field path
+
+Returns:
+- The candidate values; empty when the path does not exist
+*/
+func lookupCandidates(value interface{}, path string) []interface{} {
+	head, rest, nested := strings.Cut(path, ".")
+
+	switch value := value.(type) {
+	case bson.D:
+		next, ok := documentField(value, head)
+		if !ok {
+			return nil
+		}
+		if nested {
+			return lookupCandidates(next, rest)
+		}
+		if array, ok := next.(bson.A); ok {
+			return append([]interface{}{array}, array...)
+		}
+		return []interface{}{next}
+	case bson.A:
+		if index, ok := arrayIndex(head); ok {
+			if index >= len(value) {
+				return nil
+			}
+			if nested {
+				return lookupCandidates(value[index], rest)
+			}
+			return []interface{}{value[index]}
+		}
+
+		out := make([]interface{}, 0)
+		for _, element := range value {
+			out = append(out, lookupCandidates(element, path)...)
+		}
+		return out
+	}
+
+	return nil
+}
+
+/*
+matchField matches the candidate values of a field against a condition,
+which is either an operator document, a regular expression, or a value the
+field must equal.
+*/
+func matchField(candidates []interface{}, condition interface{}) (bool, error) {
+	switch condition := condition.(type) {
+	case bson.D:
+		if isOperatorDocument(condition) {
+			return matchOperators(candidates, condition)
+		}
+	case primitive.Regex:
+		return matchRegex(candidates, condition.Pattern, condition.Options)
+	}
+
+	return equalsAny(candidates, condition), nil
+}
+
+/*
+matchOperators matches the candidate values against every operator of an
+operator document such as {$gte: 1, $lt: 5}.
+*/
+func matchOperators(candidates []interface{}, operators bson.D) (bool, error) {
+	for _, operator := range operators {
+		ok, err := matchOperator(candidates, operator, operators)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+/*
+matchOperator matches the candidate values against a single query operator.
+The sibling operators are needed to pair $regex with its $options.
+*/
+func matchOperator(candidates []interface{}, operator bson.E, siblings bson.D) (bool, error) {
+	switch operator.Key {
+	case "$eq":
+		return equalsAny(candidates, operator.Value), nil
+	case "$ne":
+		return !equalsAny(candidates, operator.Value), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return compareAny(candidates, operator.Key, operator.Value), nil
+	case "$in":
+		return inAny(candidates, asArray(operator.Value))
+	case "$nin":
+		ok, err := inAny(candidates, asArray(operator.Value))
+		return !ok, err
+	case "$exists":
+		return (len(candidates) > 0) == isTruthy(operator.Value), nil
+	case "$regex":
+		return matchRegexOperator(candidates, operator.Value, siblings)
+	case "$options":
+		return true, nil
+	case "$not":
+		ok, err := matchField(candidates, operator.Value)
+		return !ok, err
+	case "$size":
+		for _, candidate := range candidates {
+			if array, ok := candidate.(bson.A); ok && int64(len(array)) == toInt(operator.Value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$elemMatch":
+		return matchElemMatch(candidates, asDocument(operator.Value))
+	case "$all":
+		for _, value := range asArray(operator.Value) {
+			if !equalsAny(candidates, value) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	return false, fmt.Errorf("unsupported query operator: %s", operator.Key)
+}
+
+/*
+equalsAny reports whether any candidate equals the value. A null value also
+matches a missing field, as it does in MongoDB.
+*/
+func equalsAny(candidates []interface{}, value interface{}) bool {
+	if typeOrder(value) == typeOrder(nil) && len(candidates) == 0 {
+		return true
+	}
+
+	for _, candidate := range candidates {
+		if valuesEqual(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+compareAny reports whether any candidate of the same type as the value
+satisfies the comparison. Values of other types never match, following
+MongoDB's type bracketing.
+*/
+func compareAny(candidates []interface{}, operator string, value interface{}) bool {
+	for _, candidate := range candidates {
+		if typeOrder(candidate) != typeOrder(value) {
+			continue
+		}
+
+		if satisfiesComparison(operator, compareValues(candidate, value)) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+satisfiesComparison reports whether the result of compareValues satisfies a
+comparison operator.
+*/
+func satisfiesComparison(operator string, cmp int) bool {
+	switch operator {
+	case "$eq":
+		return cmp == 0
+	case "$ne":
+		return cmp != 0
+	case "$gt":
+		return cmp > 0
+	case "$gte":
+		return cmp >= 0
+	case "$lt":
+		return cmp < 0
+	case "$lte":
+		return cmp <= 0
+	}
+	return false
+}
+
+/*
+inAny reports whether any candidate equals, or matches when it is a regular
+expression, any of the values.
+*/
+func inAny(candidates []interface{}, values bson.A) (bool, error) {
+	for _, value := range values {
+		if regex, ok := value.(primitive.Regex); ok {
+			matched, err := matchRegex(candidates, regex.Pattern, regex.Options)
+			if err != nil || matched {
+				return matched, err
+			}
+			continue
+		}
+
+		if equalsAny(candidates, value) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/*
+matchRegexOperator handles {$regex: pattern, $options: flags}, where the
+pattern may be a string or a regular expression value.
+*/
+func matchRegexOperator(candidates []interface{}, pattern interface{}, siblings bson.D) (bool, error) {
+	options, _ := documentField(siblings, "$options")
+
+	switch pattern := pattern.(type) {
+	case primitive.Regex:
+		return matchRegex(candidates, pattern.Pattern, pattern.Options+stringOf(options))
+	case string:
+		return matchRegex(candidates, pattern, stringOf(options))
+	}
+
+	return false, fmt.Errorf("$regex requires a string or regular expression")
+}
+
+/*
+matchRegex reports whether any string candidate matches the pattern.
+*/
+func matchRegex(candidates []interface{}, pattern, options string) (bool, error) {
+	re, err := compileRegex(pattern, options)
+	if err != nil {
+		return false, err
+	}
+
+	for _, candidate := range candidates {
+		if s, ok := candidate.(string); ok && re.MatchString(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/*
+compileRegex compiles a MongoDB regular expression, translating the i, m and
+s options into inline flags.
+*/
+func compileRegex(pattern, options string) (*regexp.Regexp, error) {
+	flags := ""
+	for _, option := range options {
+		if strings.ContainsRune("ims", option) && !strings.ContainsRune(flags, option) {
+			flags += string(option)
+		}
+	}
+
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+
+	return regexp.Compile(pattern)
+}
+
+/*
+matchElemMatch reports whether any candidate array has an element matching
+the condition, which is either a filter on embedded documents or an operator
+document applied to the elements themselves.
+*/
+func matchElemMatch(candidates []interface{}, condition bson.D) (bool, error) {
+	for _, candidate := range candidates {
+		array, ok := candidate.(bson.A)
+		if !ok {
+			continue
+		}
+
+		for _, element := range array {
+			var (
+				matched bool
+				err     error
+			)
+
+			if isOperatorDocument(condition) {
+				matched, err = matchOperators([]interface{}{element}, condition)
+			} else if embedded, ok := element.(bson.D); ok {
+				matched, err = matchDocument(embedded, condition, nil)
+			}
+
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+	}
+	return false, nil
+}
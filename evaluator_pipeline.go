@@ -0,0 +1,645 @@
+package squeel
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+runPipeline runs a normalized aggregation pipeline over a set of documents,
+feeding the output of each stage into the next.
+
+Parameters:
+- documents: The input documents
+- pipeline: The normalized pipeline stages
+- vars: The variables in scope, such as let variables of a $lookup
+
+Returns:
+- The output documents of the last stage
+- Any error caused by an unsupported or malformed stage
+*/
+func (evaluator *Evaluator) runPipeline(documents []bson.D, pipeline bson.A, vars map[string]interface{}) ([]bson.D, error) {
+	for _, raw := range pipeline {
+		stage := asDocument(raw)
+		if len(stage) != 1 {
+			return nil, fmt.Errorf("a pipeline stage must have exactly one field, got %v", raw)
+		}
+
+		var err error
+		if documents, err = evaluator.runStage(documents, stage[0], vars); err != nil {
+			return nil, err
+		}
+	}
+
+	return documents, nil
+}
+
+/*
+runStage runs a single aggregation stage.
+*/
+func (evaluator *Evaluator) runStage(documents []bson.D, stage bson.E, vars map[string]interface{}) ([]bson.D, error) {
+	switch stage.Key {
+	case "$match":
+		return evaluator.match(documents, asDocument(stage.Value), vars)
+	case "$project":
+		return evaluator.project(documents, asDocument(stage.Value), vars)
+	case "$addFields", "$set":
+		return evaluator.addFields(documents, asDocument(stage.Value), vars)
+	case "$unset":
+		return evaluator.unset(documents, stage.Value), nil
+	case "$group":
+		return evaluator.group(documents, asDocument(stage.Value), vars)
+	case "$sort":
+		sortDocuments(documents, asDocument(stage.Value))
+		return documents, nil
+	case "$skip":
+		offset := toInt(stage.Value)
+		return paginate(documents, &offset, nil), nil
+	case "$limit":
+		limit := toInt(stage.Value)
+		return paginate(documents, nil, &limit), nil
+	case "$count":
+		return []bson.D{{{Key: stringOf(stage.Value), Value: int32(len(documents))}}}, nil
+	case "$unwind":
+		return evaluator.unwind(documents, stage.Value)
+	case "$lookup":
+		return evaluator.lookup(documents, asDocument(stage.Value), vars)
+	case "$replaceRoot", "$replaceWith":
+		return evaluator.replaceRoot(documents, stage, vars)
+	}
+
+	return nil, fmt.Errorf("unsupported pipeline stage: %s", stage.Key)
+}
+
+/*
+project applies a projection in either inclusion or exclusion mode. In
+inclusion mode, fields set to 1 or true are copied, _id is kept unless it is
+excluded, and any other value is an expression whose result becomes the field.
+In exclusion mode, every field set to 0 or false is removed.
+*/
+func (evaluator *Evaluator) project(documents []bson.D, spec bson.D, vars map[string]interface{}) ([]bson.D, error) {
+	if isExclusion(spec) {
+		out := make([]bson.D, 0, len(documents))
+		for _, document := range documents {
+			for _, field := range spec {
+				document = removeField(document, field.Key)
+			}
+			out = append(out, document)
+		}
+		return out, nil
+	}
+
+	out := make([]bson.D, 0, len(documents))
+	for _, document := range documents {
+		projected, err := projectInclusion(document, spec, vars)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, projected)
+	}
+
+	return out, nil
+}
+
+/*
+isExclusion reports whether a projection only excludes fields. A projection
+that only excludes _id is an exclusion projection.
+*/
+func isExclusion(spec bson.D) bool {
+	for _, field := range spec {
+		if !isFlag(field.Value) || isTruthy(field.Value) {
+			return false
+		}
+	}
+	return len(spec) > 0
+}
+
+/*
+isFlag reports whether a projection value is an inclusion or exclusion flag
+rather than an expression.
+*/
+func isFlag(value interface{}) bool {
+	_, isBool := value.(bool)
+	return isBool || isNumber(value)
+}
+
+/*
+projectInclusion builds the output document of an inclusion projection.
+*/
+func projectInclusion(document bson.D, spec bson.D, vars map[string]interface{}) (bson.D, error) {
+	out := bson.D{}
+
+	if _, ok := documentField(spec, "_id"); !ok {
+		if id, ok := documentField(document, "_id"); ok {
+			out = append(out, bson.E{Key: "_id", Value: id})
+		}
+	}
+
+	for _, field := range spec {
+		if isFlag(field.Value) {
+			if !isTruthy(field.Value) {
+				continue
+			}
+			if value, ok := fieldValue(document, field.Key); ok {
+				out = setField(out, field.Key, value)
+			}
+			continue
+		}
+
+		value, err := evalExpr(field.Value, document, vars)
+		if err != nil {
+			return nil, err
+		}
+		out = setField(out, field.Key, value)
+	}
+
+	return out, nil
+}
+
+/*
+addFields evaluates each expression of the specification and sets the result
+on every document, keeping all existing fields.
+*/
+func (evaluator *Evaluator) addFields(documents []bson.D, spec bson.D, vars map[string]interface{}) ([]bson.D, error) {
+	out := make([]bson.D, 0, len(documents))
+
+	for _, document := range documents {
+		updated := document
+		for _, field := range spec {
+			value, err := evalExpr(field.Value, document, vars)
+			if err != nil {
+				return nil, err
+			}
+			updated = setField(updated, field.Key, value)
+		}
+		out = append(out, updated)
+	}
+
+	return out, nil
+}
+
+/*
+unset removes one or more fields from every document.
+*/
+func (evaluator *Evaluator) unset(documents []bson.D, spec interface{}) []bson.D {
+	fields := argList(spec)
+	out := make([]bson.D, 0, len(documents))
+
+	for _, document := range documents {
+		for _, field := range fields {
+			document = removeField(document, stringOf(field))
+		}
+		out = append(out, document)
+	}
+
+	return out
+}
+
+/*
+replaceRoot replaces every document with the result of an expression.
+*/
+func (evaluator *Evaluator) replaceRoot(documents []bson.D, stage bson.E, vars map[string]interface{}) ([]bson.D, error) {
+	expr := stage.Value
+	if stage.Key == "$replaceRoot" {
+		expr, _ = documentField(asDocument(stage.Value), "newRoot")
+	}
+
+	out := make([]bson.D, 0, len(documents))
+	for _, document := range documents {
+		value, err := evalExpr(expr, document, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		root, ok := value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s requires the new root to be a document, got %T", stage.Key, value)
+		}
+		out = append(out, root)
+	}
+
+	return out, nil
+}
+
+/*
+group groups documents by the value of the _id expression and computes the
+accumulators of every group. Groups are returned in the order in which they
+were first seen.
+*/
+func (evaluator *Evaluator) group(documents []bson.D, spec bson.D, vars map[string]interface{}) ([]bson.D, error) {
+	idExpr, ok := documentField(spec, "_id")
+	if !ok {
+		return nil, fmt.Errorf("a $group stage requires an _id")
+	}
+
+	type group struct {
+		id           interface{}
+		accumulators []accumulator
+	}
+
+	groups := make(map[string]*group)
+	order := make([]*group, 0)
+
+	for _, document := range documents {
+		id, err := evalExpr(idExpr, document, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		key := valueKey(id)
+		current, ok := groups[key]
+		if !ok {
+			current = &group{id: id}
+			for _, field := range spec {
+				if field.Key == "_id" {
+					continue
+				}
+				accumulator, err := newGroupAccumulator(field)
+				if err != nil {
+					return nil, err
+				}
+				current.accumulators = append(current.accumulators, accumulator)
+			}
+			groups[key] = current
+			order = append(order, current)
+		}
+
+		i := 0
+		for _, field := range spec {
+			if field.Key == "_id" {
+				continue
+			}
+
+			arg := asDocument(field.Value)[0].Value
+			value, err := evalExpr(arg, document, vars)
+			if err != nil {
+				return nil, err
+			}
+			current.accumulators[i].add(value)
+			i++
+		}
+	}
+
+	out := make([]bson.D, 0, len(order))
+	for _, current := range order {
+		document := bson.D{{Key: "_id", Value: current.id}}
+
+		i := 0
+		for _, field := range spec {
+			if field.Key == "_id" {
+				continue
+			}
+			document = append(document, bson.E{Key: field.Key, Value: current.accumulators[i].result()})
+			i++
+		}
+
+		out = append(out, document)
+	}
+
+	return out, nil
+}
+
+/*
+newGroupAccumulator creates the accumulator for a $group output field of the
+form {name: {$accumulator: expression}}.
+*/
+func newGroupAccumulator(field bson.E) (accumulator, error) {
+	spec := asDocument(field.Value)
+	if len(spec) != 1 || !isOperatorDocument(spec) {
+		return nil, fmt.Errorf("the $group field %s must be an accumulator object", field.Key)
+	}
+
+	accumulator := newAccumulator(spec[0].Key)
+	if accumulator == nil {
+		return nil, fmt.Errorf("unsupported accumulator: %s", spec[0].Key)
+	}
+
+	return accumulator, nil
+}
+
+/*
+unwind outputs one document per element of an array field. Documents whose
+field is missing, null or an empty array are dropped unless
+preserveNullAndEmptyArrays is set, in which case an empty array is removed
+from the document as in MongoDB.
+*/
+func (evaluator *Evaluator) unwind(documents []bson.D, spec interface{}) ([]bson.D, error) {
+	path, preserve, indexField := "", false, ""
+
+	switch spec := spec.(type) {
+	case string:
+		path = spec
+	case bson.D:
+		value, _ := documentField(spec, "path")
+		path = stringOf(value)
+		value, _ = documentField(spec, "preserveNullAndEmptyArrays")
+		preserve = isTruthy(value)
+		value, _ = documentField(spec, "includeArrayIndex")
+		indexField = stringOf(value)
+	}
+
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("$unwind requires a path prefixed with $, got %q", path)
+	}
+	path = path[1:]
+
+	out := make([]bson.D, 0, len(documents))
+	for _, document := range documents {
+		value, _ := fieldValue(document, path)
+
+		array, isArray := value.(bson.A)
+		if !isArray && !isNullish(value) {
+			array = bson.A{value}
+		}
+
+		if len(array) == 0 {
+			if preserve {
+				if isArray {
+					document = removeField(document, path)
+				}
+				if indexField != "" {
+					document = setField(document, indexField, nil)
+				}
+				out = append(out, document)
+			}
+			continue
+		}
+
+		for i, element := range array {
+			unwound := setField(document, path, element)
+			if indexField != "" {
+				unwound = setField(unwound, indexField, int64(i))
+			}
+			out = append(out, unwound)
+		}
+	}
+
+	return out, nil
+}
+
+/*
+lookup joins documents from another collection into an array field. Foreign
+documents are selected by equality of localField and foreignField when both
+are given, and are then passed through the lookup's pipeline, in which the
+let variables are bound to values of the local document.
+*/
+func (evaluator *Evaluator) lookup(documents []bson.D, spec bson.D, vars map[string]interface{}) ([]bson.D, error) {
+	from, _ := documentField(spec, "from")
+	as, _ := documentField(spec, "as")
+	localField, _ := documentField(spec, "localField")
+	foreignField, _ := documentField(spec, "foreignField")
+	let, _ := documentField(spec, "let")
+	pipeline, _ := documentField(spec, "pipeline")
+
+	foreign := evaluator.collection(stringOf(from))
+	out := make([]bson.D, 0, len(documents))
+
+	for _, document := range documents {
+		joined := foreign
+
+		if local := stringOf(localField); local != "" {
+			joined = joinOn(document, local, foreign, stringOf(foreignField))
+		}
+
+		scope, err := lookupScope(document, asDocument(let), vars)
+		if err != nil {
+			return nil, err
+		}
+
+		if joined, err = evaluator.runPipeline(joined, asArray(pipeline), scope); err != nil {
+			return nil, err
+		}
+
+		matches := make(bson.A, 0, len(joined))
+		for _, match := range joined {
+			matches = append(matches, match)
+		}
+		out = append(out, setField(document, stringOf(as), matches))
+	}
+
+	return out, nil
+}
+
+/*
+joinOn returns the foreign documents whose foreign field equals the local
+field of the document. When either side is an array, any element matching
+is enough, and a missing local field matches foreign documents whose field
+is null or missing, as in MongoDB.
+*/
+func joinOn(document bson.D, localField string, foreign []bson.D, foreignField string) []bson.D {
+	locals := lookupCandidates(document, localField)
+	if len(locals) == 0 {
+		locals = []interface{}{nil}
+	}
+
+	out := make([]bson.D, 0)
+	for _, candidate := range foreign {
+		foreigns := lookupCandidates(candidate, foreignField)
+		for _, local := range locals {
+			if _, isArray := local.(bson.A); isArray {
+				continue
+			}
+			if equalsAny(foreigns, local) {
+				out = append(out, candidate)
+				break
+			}
+		}
+	}
+
+	return out
+}
+
+/*
+lookupScope evaluates the let variables of a $lookup against the local
+document, on top of the variables already in scope.
+*/
+func lookupScope(document bson.D, let bson.D, vars map[string]interface{}) (map[string]interface{}, error) {
+	scope := make(map[string]interface{}, len(vars)+len(let))
+	for name, value := range vars {
+		scope[name] = value
+	}
+
+	for _, variable := range let {
+		value, err := evalExpr(variable.Value, document, vars)
+		if err != nil {
+			return nil, err
+		}
+		scope[variable.Key] = value
+	}
+
+	return scope, nil
+}
+
+/*
+accumulator computes the value of a $group output field from the values of
+the documents in a group.
+*/
+type accumulator interface {
+	add(value interface{})
+	result() interface{}
+}
+
+/*
+newAccumulator creates an accumulator for an accumulator operator, or
+returns nil when the operator is not supported.
+*/
+func newAccumulator(name string) accumulator {
+	switch name {
+	case "$sum":
+		return &sumAccumulator{integers: true}
+	case "$avg":
+		return &avgAccumulator{}
+	case "$min":
+		return &extremeAccumulator{sign: -1}
+	case "$max":
+		return &extremeAccumulator{sign: 1}
+	case "$first":
+		return &firstAccumulator{}
+	case "$last":
+		return &lastAccumulator{}
+	case "$push":
+		return &pushAccumulator{values: bson.A{}}
+	case "$addToSet":
+		return &pushAccumulator{values: bson.A{}, set: newValueSet()}
+	case "$count":
+		return &countAccumulator{}
+	}
+	return nil
+}
+
+/*
+sumAccumulator sums numeric values, ignoring everything else.
+*/
+type sumAccumulator struct {
+	sum      float64
+	integers bool
+}
+
+func (acc *sumAccumulator) add(value interface{}) {
+	if isNumber(value) {
+		acc.sum += toFloat(value)
+		acc.integers = acc.integers && isInteger(value)
+	}
+}
+
+func (acc *sumAccumulator) result() interface{} {
+	if acc.integers && acc.sum >= math.MinInt32 && acc.sum <= math.MaxInt32 {
+		return int32(acc.sum)
+	}
+	return numericResult(acc.sum, acc.integers)
+}
+
+/*
+avgAccumulator averages numeric values, yielding null when there are none.
+*/
+type avgAccumulator struct {
+	sum   float64
+	count int
+}
+
+func (acc *avgAccumulator) add(value interface{}) {
+	if isNumber(value) {
+		acc.sum += toFloat(value)
+		acc.count++
+	}
+}
+
+func (acc *avgAccumulator) result() interface{} {
+	if acc.count == 0 {
+		return nil
+	}
+	return acc.sum / float64(acc.count)
+}
+
+/*
+extremeAccumulator keeps the smallest or largest non-null value.
+*/
+type extremeAccumulator struct {
+	sign  int
+	value interface{}
+	set   bool
+}
+
+func (acc *extremeAccumulator) add(value interface{}) {
+	if isNullish(value) {
+		return
+	}
+	if !acc.set || compareValues(value, acc.value)*acc.sign > 0 {
+		acc.value, acc.set = value, true
+	}
+}
+
+func (acc *extremeAccumulator) result() interface{} {
+	return acc.value
+}
+
+/*
+firstAccumulator keeps the value of the first document in the group.
+*/
+type firstAccumulator struct {
+	value interface{}
+	set   bool
+}
+
+func (acc *firstAccumulator) add(value interface{}) {
+	if !acc.set {
+		acc.value, acc.set = value, true
+	}
+}
+
+func (acc *firstAccumulator) result() interface{} {
+	return acc.value
+}
+
+/*
+lastAccumulator keeps the value of the last document in the group.
+*/
+type lastAccumulator struct {
+	value interface{}
+}
+
+func (acc *lastAccumulator) add(value interface{}) {
+	acc.value = value
+}
+
+func (acc *lastAccumulator) result() interface{} {
+	return acc.value
+}
+
+/*
+pushAccumulator collects values into an array. With a set, it only keeps
+distinct values, as $addToSet does.
+*/
+type pushAccumulator struct {
+	values bson.A
+	set    *valueSet
+}
+
+func (acc *pushAccumulator) add(value interface{}) {
+	if acc.set != nil && (value == nil || !acc.set.add(value)) {
+		return
+	}
+	acc.values = append(acc.values, value)
+}
+
+func (acc *pushAccumulator) result() interface{} {
+	return acc.values
+}
+
+/*
+countAccumulator counts the documents in the group.
+*/
+type countAccumulator struct {
+	count int32
+}
+
+func (acc *countAccumulator) add(_ interface{}) {
+	acc.count++
+}
+
+func (acc *countAccumulator) result() interface{} {
+	return acc.count
+}
@@ -0,0 +1,243 @@
+package squeel
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+TestMain sends the debug log to the null device, so running the tests does
+not leave a log file in the working directory.
+*/
+func TestMain(m *testing.M) {
+	logPath = os.DevNull
+	os.Exit(m.Run())
+}
+
+/*
+evaluatorFixtures are the in-memory collections used by the evaluator tests.
+*/
+var evaluatorFixtures = map[string][]interface{}{
+	"users": {
+		bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "ann"}, {Key: "age", Value: 31}, {Key: "tags", Value: bson.A{"a", "b"}}},
+		bson.D{{Key: "_id", Value: 2}, {Key: "name", Value: "bob"}, {Key: "age", Value: 17}, {Key: "tags", Value: bson.A{"b"}}},
+		bson.M{"_id": 3, "name": "cid", "age": 45.5, "address": bson.M{"city": "Utrecht"}},
+	},
+	"orders": {
+		bson.D{{Key: "_id", Value: 10}, {Key: "user_id", Value: 1}, {Key: "total", Value: 20}},
+		bson.D{{Key: "_id", Value: 11}, {Key: "user_id", Value: 1}, {Key: "total", Value: 5}},
+		bson.D{{Key: "_id", Value: 12}, {Key: "user_id", Value: 3}, {Key: "total", Value: 7}},
+	},
+}
+
+/*
+evaluate runs the query against the evaluator and converts the result
+into bson.M documents for comparison.
+*/
+func evaluate(evaluator *Evaluator, q *Query) []bson.M {
+	documents, err := evaluator.Evaluate(q)
+	So(err, ShouldBeNil)
+
+	out := make([]bson.M, 0, len(documents))
+	for _, document := range documents {
+		out = append(out, document.Map())
+	}
+	return out
+}
+
+/*
+newEvaluator creates an Evaluator over the given collections.
+*/
+func newEvaluator(collections map[string][]interface{}) *Evaluator {
+	evaluator, err := NewEvaluator(collections)
+	So(err, ShouldBeNil)
+	return evaluator
+}
+
+/*
+build translates a statement into a query, which must succeed.
+*/
+func build(sql string, options ...StatementOption) *Query {
+	q, err := NewStatement(sql, options...).Build(NewQuery())
+	So(err, ShouldBeNil)
+	return q
+}
+
+/*
+buildAggregate translates a statement that needs an aggregation pipeline.
+*/
+func buildAggregate(sql string) *Query {
+	q := build(sql)
+	So(q.Operation, ShouldEqual, "aggregate")
+	return q
+}
+
+/*
+ids runs a statement against the evaluator and returns the _id of every
+document it returns, in order.
+*/
+func ids(evaluator *Evaluator, sql string, options ...StatementOption) []interface{} {
+	out := []interface{}{}
+	for _, document := range evaluate(evaluator, build(sql, options...)) {
+		out = append(out, document["_id"])
+	}
+	return out
+}
+
+func TestEvaluator(t *testing.T) {
+	Convey("Given an Evaluator over in-memory collections", t, func() {
+		evaluator := newEvaluator(evaluatorFixtures)
+
+		Convey("It should filter, sort and project find queries", func() {
+			q := NewQuery()
+			q.Operation = "find"
+			q.Collection = "users"
+			q.Filter = bson.D{{Key: "age", Value: bson.M{"$gt": 18}}}
+			q.Projection = bson.D{{Key: "name", Value: 1}}
+			q.Sort = bson.D{{Key: "age", Value: -1}}
+
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(3), "name": "cid"},
+				{"_id": int32(1), "name": "ann"},
+			})
+		})
+
+		Convey("It should match array elements and nested paths", func() {
+			q := NewQuery()
+			q.Operation = "find"
+			q.Collection = "users"
+			q.Filter = bson.D{{Key: "$or", Value: []bson.M{
+				{"tags": "a"},
+				{"address.city": bson.M{"$regex": "^utr", "$options": "i"}},
+			}}}
+
+			So(evaluate(evaluator, q), ShouldHaveLength, 2)
+		})
+
+		Convey("It should sort arrays by their smallest or largest element", func() {
+			q := NewQuery()
+			q.Operation = "find"
+			q.Collection = "users"
+			q.Projection = bson.D{{Key: "_id", Value: 1}}
+
+			q.Sort = bson.D{{Key: "tags", Value: -1}}
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}, {"_id": int32(2)}, {"_id": int32(3)}})
+
+			values := newEvaluator(map[string][]interface{}{"values": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "v", Value: "m"}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "v", Value: bson.A{"z", "a"}}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "v", Value: bson.A{}}},
+				bson.D{{Key: "_id", Value: 4}},
+			}})
+			q.Collection = "values"
+
+			q.Sort = bson.D{{Key: "v", Value: 1}}
+			So(evaluate(values, q), ShouldResemble, []bson.M{{"_id": int32(3)}, {"_id": int32(4)}, {"_id": int32(2)}, {"_id": int32(1)}})
+
+			q.Sort = bson.D{{Key: "v", Value: -1}}
+			So(evaluate(values, q), ShouldResemble, []bson.M{{"_id": int32(2)}, {"_id": int32(1)}, {"_id": int32(4)}, {"_id": int32(3)}})
+		})
+
+		Convey("It should apply offset and limit", func() {
+			offset, limit := int64(1), int64(1)
+			q := NewQuery()
+			q.Operation = "find"
+			q.Collection = "users"
+			q.Offset, q.Limit = &offset, &limit
+			q.Projection = bson.D{{Key: "_id", Value: 1}}
+
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2)}})
+		})
+
+		Convey("It should count and select distinct values", func() {
+			q := NewQuery()
+			q.Operation = "count"
+			q.Collection = "orders"
+			q.Filter = bson.D{{Key: "user_id", Value: 1}}
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"count": int64(2)}})
+
+			limit, offset := int64(1), int64(1)
+			q.Limit = &limit
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"count": int64(2)}})
+			q.Offset = &offset
+			So(evaluate(evaluator, q), ShouldBeEmpty)
+
+			q.Operation = "distinct"
+			q.Limit, q.Offset = nil, nil
+			q.Filter = nil
+			q.Projection = bson.D{{Key: "user_id", Value: 1}}
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"user_id": int32(1)}, {"user_id": int32(3)}})
+		})
+
+		Convey("It should run $lookup, $unwind, $group and $sort stages", func() {
+			q := NewQuery()
+			q.Operation = "aggregate"
+			q.Collection = "users"
+			q.Pipeline = mongo.Pipeline{
+				{{Key: "$lookup", Value: bson.M{"from": "orders", "localField": "_id", "foreignField": "user_id", "as": "orders"}}},
+				{{Key: "$unwind", Value: "$orders"}},
+				{{Key: "$group", Value: bson.D{
+					{Key: "_id", Value: "$name"},
+					{Key: "spent", Value: bson.M{"$sum": "$orders.total"}},
+					{Key: "orders", Value: bson.M{"$sum": 1}},
+				}}},
+				{{Key: "$sort", Value: bson.D{{Key: "spent", Value: -1}}}},
+				{{Key: "$project", Value: bson.D{
+					{Key: "_id", Value: 0},
+					{Key: "name", Value: "$_id"},
+					{Key: "spent", Value: 1},
+					{Key: "average", Value: bson.M{"$divide": bson.A{"$spent", "$orders"}}},
+				}}},
+			}
+
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"name": "ann", "spent": int32(25), "average": 12.5},
+				{"name": "cid", "spent": int32(7), "average": 7.0},
+			})
+		})
+
+		Convey("It should bind let variables in pipeline-style $lookup", func() {
+			q := NewQuery()
+			q.Operation = "aggregate"
+			q.Collection = "users"
+			q.Pipeline = mongo.Pipeline{
+				{{Key: "$lookup", Value: bson.D{
+					{Key: "from", Value: "orders"},
+					{Key: "let", Value: bson.M{"uid": "$_id"}},
+					{Key: "pipeline", Value: bson.A{
+						bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+							bson.M{"$eq": bson.A{"$user_id", "$$uid"}},
+							bson.M{"$gte": bson.A{"$total", 7}},
+						}}}},
+					}},
+					{Key: "as", Value: "big"},
+				}}},
+				{{Key: "$addFields", Value: bson.M{"count": bson.M{"$size": "$big"}}}},
+				{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 0}}}},
+				{{Key: "$project", Value: bson.M{"_id": 1}}},
+			}
+
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}, {"_id": int32(3)}})
+		})
+
+		Convey("It should evaluate translated SQL", func() {
+			q, err := NewStatement("SELECT name FROM users WHERE name LIKE '%i%'").Build(NewQuery())
+			So(err, ShouldBeNil)
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(3), "name": "cid"}})
+		})
+
+		Convey("It should reject unsupported stages", func() {
+			q := NewQuery()
+			q.Operation = "aggregate"
+			q.Collection = "users"
+			q.Pipeline = mongo.Pipeline{{{Key: "$graphLookup", Value: bson.M{}}}}
+
+			_, err := evaluator.Evaluate(q)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
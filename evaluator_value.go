@@ -0,0 +1,532 @@
+package squeel
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+normalizeValue converts any Go value into the types produced when decoding
+BSON into a bson.D: embedded documents become bson.D, arrays become bson.A,
+times become primitive.DateTime and so on. This lets the Evaluator treat
+queries and documents built from bson.M, bson.D and Go slices alike.
+
+Parameters:
+- value: The value to normalize
+
+Returns:
+- The normalized value
+- Any error that occurred while marshalling the value
+*/
+func normalizeValue(value interface{}) (interface{}, error) {
+	raw, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return nil, err
+	}
+
+	var out bson.D
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+
+	return out[0].Value, nil
+}
+
+/*
+normalizeDocument normalizes a value that must be a document.
+*/
+func normalizeDocument(value interface{}) (bson.D, error) {
+	normalized, err := normalizeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return asDocument(normalized), nil
+}
+
+/*
+asDocument returns a normalized value as a document, or nil when it is not one.
+*/
+func asDocument(value interface{}) bson.D {
+	if document, ok := value.(bson.D); ok {
+		return document
+	}
+	return nil
+}
+
+/*
+asArray returns a normalized value as an array, or nil when it is not one.
+*/
+func asArray(value interface{}) bson.A {
+	if array, ok := value.(bson.A); ok {
+		return array
+	}
+	return nil
+}
+
+/*
+isOperatorDocument reports whether a document is an operator expression such
+as {$gt: 5}, rather than a literal embedded document.
+*/
+func isOperatorDocument(document bson.D) bool {
+	return len(document) > 0 && strings.HasPrefix(document[0].Key, "$")
+}
+
+/*
+fieldValue returns the value at a dotted path in a document. Numeric path
+segments index into arrays; other segments applied to an array collect the
+field from every embedded document, as MongoDB does for "$a.b" expressions.
+
+Parameters:
+- document: The document to read from
+- path: The dotted field path
+
+Returns:
+- The value at the path
+- Whether the path exists in the document
+*/
+func fieldValue(document bson.D, path string) (interface{}, bool) {
+	var current interface{} = document
+
+	for _, segment := range strings.Split(path, ".") {
+		switch value := current.(type) {
+		case bson.D:
+			next, ok := documentField(value, segment)
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case bson.A:
+			if index, ok := arrayIndex(segment); ok {
+				if index >= len(value) {
+					return nil, false
+				}
+				current = value[index]
+				continue
+			}
+
+			collected := bson.A{}
+			for _, element := range value {
+				if embedded, ok := element.(bson.D); ok {
+					if next, ok := fieldValue(embedded, segment); ok {
+						collected = append(collected, next)
+					}
+				}
+			}
+			current = collected
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+/*
+documentField returns the value of a top-level key of a document.
+*/
+func documentField(document bson.D, key string) (interface{}, bool) {
+	for _, element := range document {
+		if element.Key == key {
+			return element.Value, true
+		}
+	}
+	return nil, false
+}
+
+/*
+arrayIndex parses a path segment as an array index.
+*/
+func arrayIndex(segment string) (int, bool) {
+	if segment == "" {
+		return 0, false
+	}
+
+	index := 0
+	for _, r := range segment {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+		index = index*10 + int(r-'0')
+	}
+	return index, true
+}
+
+/*
+setField returns a copy of a document with the value at a dotted path set,
+creating embedded documents along the way.
+
+Parameters:
+- document: The document to modify
+- path: The dotted field path
+- value: The value to set
+
+Returns:
+- The modified copy of the document
+*/
+func setField(document bson.D, path string, value interface{}) bson.D {
+	head, rest, nested := strings.Cut(path, ".")
+	out := append(bson.D(nil), document...)
+
+	for i, element := range out {
+		if element.Key != head {
+			continue
+		}
+
+		if !nested {
+			out[i].Value = value
+			return out
+		}
+
+		embedded, _ := element.Value.(bson.D)
+		out[i].Value = setField(embedded, rest, value)
+		return out
+	}
+
+	if !nested {
+		return append(out, bson.E{Key: head, Value: value})
+	}
+
+	return append(out, bson.E{Key: head, Value: setField(nil, rest, value)})
+}
+
+/*
+removeField returns a copy of a document without the value at a dotted path.
+*/
+func removeField(document bson.D, path string) bson.D {
+	head, rest, nested := strings.Cut(path, ".")
+	out := make(bson.D, 0, len(document))
+
+	for _, element := range document {
+		if element.Key != head {
+			out = append(out, element)
+			continue
+		}
+
+		if embedded, ok := element.Value.(bson.D); ok && nested {
+			out = append(out, bson.E{Key: head, Value: removeField(embedded, rest)})
+		}
+	}
+
+	return out
+}
+
+/*
+typeOrder returns the position of a value's type in MongoDB's comparison
+order, which is used both for sorting and for type bracketing in filters.
+*/
+func typeOrder(value interface{}) int {
+	switch value.(type) {
+	case primitive.MinKey:
+		return 0
+	case nil, primitive.Null, primitive.Undefined:
+		return 1
+	case int, int32, int64, float64, primitive.Decimal128:
+		return 2
+	case string, primitive.Symbol:
+		return 3
+	case bson.D:
+		return 4
+	case bson.A:
+		return 5
+	case primitive.Binary, []byte:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime, time.Time:
+		return 9
+	case primitive.Timestamp:
+		return 10
+	case primitive.Regex:
+		return 11
+	}
+	return 12
+}
+
+/*
+compareValues compares two values using MongoDB's ordering. Values of
+different types are ordered by type; values of the same type by content.
+
+Returns:
+- A negative number, zero or a positive number when a sorts before, equal to or after b
+*/
+func compareValues(a, b interface{}) int {
+	if orderA, orderB := typeOrder(a), typeOrder(b); orderA != orderB {
+		return orderA - orderB
+	}
+
+	switch a := a.(type) {
+	case nil, primitive.Null, primitive.Undefined, primitive.MinKey:
+		return 0
+	case string, primitive.Symbol:
+		return strings.Compare(stringOf(a), stringOf(b))
+	case bool:
+		return compareBool(a, b.(bool))
+	case bson.D:
+		return compareDocuments(a, b.(bson.D))
+	case bson.A:
+		return compareArrays(a, b.(bson.A))
+	case primitive.Binary:
+		if other, ok := b.(primitive.Binary); ok && a.Subtype != other.Subtype {
+			return int(a.Subtype) - int(other.Subtype)
+		}
+		return bytes.Compare(a.Data, toBytes(b))
+	case primitive.ObjectID:
+		other := b.(primitive.ObjectID)
+		return bytes.Compare(a[:], other[:])
+	case primitive.DateTime, time.Time:
+		return compareTimes(toTime(a), toTime(b))
+	}
+
+	if isNumber(a) {
+		return compareFloats(toFloat(a), toFloat(b))
+	}
+
+	return strings.Compare(stringOf(a), stringOf(b))
+}
+
+/*
+compareBool orders false before true.
+*/
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case !a:
+		return -1
+	}
+	return 1
+}
+
+/*
+compareFloats compares two numbers.
+*/
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+/*
+compareTimes compares two points in time.
+*/
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+/*
+compareDocuments compares documents field by field, first by key and then by value.
+*/
+func compareDocuments(a, b bson.D) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if cmp := strings.Compare(a[i].Key, b[i].Key); cmp != 0 {
+			return cmp
+		}
+		if cmp := compareValues(a[i].Value, b[i].Value); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(a) - len(b)
+}
+
+/*
+compareArrays compares arrays element by element.
+*/
+func compareArrays(a, b bson.A) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if cmp := compareValues(a[i], b[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(a) - len(b)
+}
+
+/*
+valuesEqual reports whether two values are equal under MongoDB semantics,
+so that numbers of different Go types compare by value.
+*/
+func valuesEqual(a, b interface{}) bool {
+	return compareValues(a, b) == 0
+}
+
+/*
+isNumber reports whether a value is numeric.
+*/
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int, int32, int64, float64, primitive.Decimal128:
+		return true
+	}
+	return false
+}
+
+/*
+isInteger reports whether a value is an integer type.
+*/
+func isInteger(value interface{}) bool {
+	switch value.(type) {
+	case int, int32, int64:
+		return true
+	}
+	return false
+}
+
+/*
+toFloat converts a numeric value to a float64, returning NaN for non-numbers.
+*/
+func toFloat(value interface{}) float64 {
+	switch value := value.(type) {
+	case int:
+		return float64(value)
+	case int32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case float64:
+		return value
+	case primitive.Decimal128:
+		if f, err := strconv.ParseFloat(value.String(), 64); err == nil {
+			return f
+		}
+	}
+	return math.NaN()
+}
+
+/*
+toInt converts a numeric value to an int64, truncating floats.
+*/
+func toInt(value interface{}) int64 {
+	switch value := value.(type) {
+	case int:
+		return int64(value)
+	case int32:
+		return int64(value)
+	case int64:
+		return value
+	case float64:
+		return int64(value)
+	case bool:
+		if value {
+			return 1
+		}
+	}
+	return 0
+}
+
+/*
+toTime converts a date value to a time.Time.
+*/
+func toTime(value interface{}) time.Time {
+	switch value := value.(type) {
+	case primitive.DateTime:
+		return value.Time().UTC()
+	case time.Time:
+		return value.UTC()
+	}
+	return time.Time{}
+}
+
+/*
+toBytes returns the bytes of a binary value.
+*/
+func toBytes(value interface{}) []byte {
+	switch value := value.(type) {
+	case primitive.Binary:
+		return value.Data
+	case []byte:
+		return value
+	}
+	return nil
+}
+
+/*
+stringOf returns a string value, or the empty string for non-strings.
+*/
+func stringOf(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case primitive.Symbol:
+		return string(value)
+	}
+	return ""
+}
+
+/*
+isTruthy reports whether a value counts as true in an aggregation expression.
+Only false, null, missing values and zero are false.
+*/
+func isTruthy(value interface{}) bool {
+	switch value := value.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return false
+	case bool:
+		return value
+	}
+
+	if isNumber(value) {
+		return toFloat(value) != 0
+	}
+
+	return true
+}
+
+/*
+valueSet is an insertion-ordered set of values, keyed by their BSON
+encoding so that equal documents and arrays are recognized.
+*/
+type valueSet struct {
+	keys map[string]bool
+}
+
+/*
+newValueSet creates an empty valueSet.
+*/
+func newValueSet() *valueSet {
+	return &valueSet{keys: make(map[string]bool)}
+}
+
+/*
+add adds a value to the set and reports whether it was not already present.
+*/
+func (set *valueSet) add(value interface{}) bool {
+	key := valueKey(value)
+	if set.keys[key] {
+		return false
+	}
+	set.keys[key] = true
+	return true
+}
+
+/*
+valueKey returns a key identifying a value for grouping. Numbers are keyed by
+their float value, so 1 and 1.0 fall into the same group as in MongoDB.
+*/
+func valueKey(value interface{}) string {
+	if isNumber(value) {
+		value = toFloat(value)
+	}
+
+	raw, err := bson.Marshal(bson.D{{Key: "k", Value: value}})
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
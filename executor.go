@@ -0,0 +1,255 @@
+package squeel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+Collection is the subset of the MongoDB driver's collection API used by the
+Executor. It is satisfied by *mongo.Collection, and allows a fake collection
+to be substituted through NewCollectionExecutor when no MongoDB server is available.
+*/
+type Collection interface {
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error)
+}
+
+/*
+Result is the unified result of executing a Query. Every operation is exposed
+through a cursor, so callers can iterate over find, findone, aggregate, count
+and distinct results in the same way.
+
+  - find, aggregate: the documents returned by the server
+  - findone: zero or one document
+  - count: a single document of the form {count: n}
+  - distinct: one document per value, keyed by the distinct field
+*/
+type Result struct {
+	*mongo.Cursor
+	Operation string // The operation that produced the result
+}
+
+/*
+Executor runs a built Query against a MongoDB database, dispatching to the
+driver call that matches the Query's operation.
+*/
+type Executor struct {
+	collection func(name string) Collection
+}
+
+/*
+NewExecutor creates a new Executor that runs queries against the given database.
+
+Parameters:
+- db: The MongoDB database to run queries against
+
+Returns:
+- A new Executor instance
+*/
+func NewExecutor(db *mongo.Database) *Executor {
+	return NewCollectionExecutor(func(name string) Collection {
+		return db.Collection(name)
+	})
+}
+
+/*
+NewCollectionExecutor creates a new Executor that resolves collections through
+the given provider. This allows queries to run against any implementation of
+Collection, such as a wrapper that adds tracing or a fake used in tests.
+
+Parameters:
+- collection: Returns the Collection for a collection name
+
+Returns:
+- A new Executor instance
+*/
+func NewCollectionExecutor(collection func(name string) Collection) *Executor {
+	return &Executor{collection: collection}
+}
+
+/*
+Execute runs the Query against its target collection. It applies the filter,
+projection, sort, limit, offset, comment and context of the Query to the
+driver call selected by its operation.
+
+Parameters:
+- q: The built Query to execute
+
+Returns:
+- The Result of the operation
+- Any error that occurred during validation or execution
+*/
+func (executor *Executor) Execute(q *Query) (*Result, error) {
+	if q == nil {
+		return nil, fmt.Errorf("query is nil")
+	}
+
+	if q.Fails() {
+		return nil, fmt.Errorf("query is incomplete: operation=%q collection=%q", q.Operation, q.Collection)
+	}
+
+	collection := executor.collection(q.Collection)
+
+	var (
+		cursor *mongo.Cursor
+		err    error
+	)
+
+	switch q.Operation {
+	case "find":
+		cursor, err = executor.find(collection, q)
+	case "findone":
+		cursor, err = executor.findOne(collection, q)
+	case "aggregate":
+		cursor, err = executor.aggregate(collection, q)
+	case "count":
+		cursor, err = executor.count(collection, q)
+	case "distinct":
+		cursor, err = executor.distinct(collection, q)
+	default:
+		return nil, fmt.Errorf("unsupported operation: %s", q.Operation)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{Cursor: cursor, Operation: q.Operation}, nil
+}
+
+/*
+find runs a find operation with the Query's filter, projection, sort,
+limit, offset and comment.
+*/
+func (executor *Executor) find(collection Collection, q *Query) (*mongo.Cursor, error) {
+	opts := options.Find().SetComment(q.Comment)
+
+	if len(q.Projection) > 0 {
+		opts.SetProjection(q.Projection)
+	}
+
+	if len(q.Sort) > 0 {
+		opts.SetSort(q.Sort)
+	}
+
+	if q.Limit != nil {
+		opts.SetLimit(*q.Limit)
+	}
+
+	if q.Offset != nil {
+		opts.SetSkip(*q.Offset)
+	}
+
+	return collection.Find(q.Context, filterOf(q), opts)
+}
+
+/*
+findOne runs a findOne operation and wraps the single document, if any,
+in a cursor. A missing document results in an empty cursor rather than
+an error, matching the behavior of a find without results.
+*/
+func (executor *Executor) findOne(collection Collection, q *Query) (*mongo.Cursor, error) {
+	opts := options.FindOne().SetComment(q.Comment)
+
+	if len(q.Projection) > 0 {
+		opts.SetProjection(q.Projection)
+	}
+
+	if len(q.Sort) > 0 {
+		opts.SetSort(q.Sort)
+	}
+
+	if q.Offset != nil {
+		opts.SetSkip(*q.Offset)
+	}
+
+	raw, err := collection.FindOne(q.Context, filterOf(q), opts).Raw()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return mongo.NewCursorFromDocuments(nil, nil, nil)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mongo.NewCursorFromDocuments([]interface{}{raw}, nil, nil)
+}
+
+/*
+aggregate runs the Query's aggregation pipeline. The pipeline is expected to
+contain every stage of the query, so the filter, sort, limit and offset are
+not applied separately.
+*/
+func (executor *Executor) aggregate(collection Collection, q *Query) (*mongo.Cursor, error) {
+	pipeline := q.Pipeline
+	if pipeline == nil {
+		pipeline = mongo.Pipeline{}
+	}
+
+	return collection.Aggregate(q.Context, pipeline, options.Aggregate().SetComment(q.Comment))
+}
+
+/*
+count runs a CountDocuments operation and wraps the result in a cursor
+holding a single {count: n} document. As in SQL, the offset and limit apply
+to that document rather than to the documents that are counted.
+*/
+func (executor *Executor) count(collection Collection, q *Query) (*mongo.Cursor, error) {
+	n, err := collection.CountDocuments(q.Context, filterOf(q), options.Count().SetComment(q.Comment))
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]interface{}, 0, 1)
+	for _, document := range paginate([]bson.D{{{Key: "count", Value: n}}}, q.Offset, q.Limit) {
+		documents = append(documents, document)
+	}
+
+	return mongo.NewCursorFromDocuments(documents, nil, nil)
+}
+
+/*
+distinct runs a Distinct operation on the first projected field and wraps
+every value in a document keyed by that field.
+*/
+func (executor *Executor) distinct(collection Collection, q *Query) (*mongo.Cursor, error) {
+	if len(q.Projection) == 0 {
+		return nil, fmt.Errorf("distinct requires a projected field")
+	}
+
+	field := q.Projection[0].Key
+
+	values, err := collection.Distinct(
+		q.Context, field, filterOf(q), options.Distinct().SetComment(q.Comment),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		documents = append(documents, bson.D{{Key: field, Value: value}})
+	}
+
+	return mongo.NewCursorFromDocuments(documents, nil, nil)
+}
+
+/*
+filterOf returns the Query's filter, substituting an empty document when no
+filter was set, since the driver rejects a nil filter.
+*/
+func filterOf(q *Query) bson.D {
+	if q.Filter == nil {
+		return bson.D{}
+	}
+	return q.Filter
+}
@@ -0,0 +1,150 @@
+package squeel
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/*
+fakeCollection records the calls made by the Executor and answers them
+from a fixed set of documents.
+*/
+type fakeCollection struct {
+	documents []interface{}
+	calls     []string
+	filter    interface{}
+	pipeline  interface{}
+	field     string
+	find      *options.FindOptions
+}
+
+func (fake *fakeCollection) Find(_ context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	fake.calls = append(fake.calls, "find")
+	fake.filter = filter
+	fake.find = options.MergeFindOptions(opts...)
+	return mongo.NewCursorFromDocuments(fake.documents, nil, nil)
+}
+
+func (fake *fakeCollection) FindOne(_ context.Context, filter interface{}, _ ...*options.FindOneOptions) *mongo.SingleResult {
+	fake.calls = append(fake.calls, "findone")
+	fake.filter = filter
+	if len(fake.documents) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(fake.documents[0], nil, nil)
+}
+
+func (fake *fakeCollection) Aggregate(_ context.Context, pipeline interface{}, _ ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	fake.calls = append(fake.calls, "aggregate")
+	fake.pipeline = pipeline
+	return mongo.NewCursorFromDocuments(fake.documents, nil, nil)
+}
+
+func (fake *fakeCollection) CountDocuments(_ context.Context, filter interface{}, _ ...*options.CountOptions) (int64, error) {
+	fake.calls = append(fake.calls, "count")
+	fake.filter = filter
+	return int64(len(fake.documents)), nil
+}
+
+func (fake *fakeCollection) Distinct(_ context.Context, field string, filter interface{}, _ ...*options.DistinctOptions) ([]interface{}, error) {
+	fake.calls = append(fake.calls, "distinct")
+	fake.field = field
+	fake.filter = filter
+	return []interface{}{"a", "b"}, nil
+}
+
+func newFakeExecutor(fake *fakeCollection) *Executor {
+	return NewCollectionExecutor(func(string) Collection { return fake })
+}
+
+func TestExecutor(t *testing.T) {
+	Convey("Given an Executor with a fake collection", t, func() {
+		fake := &fakeCollection{documents: []interface{}{
+			bson.D{{Key: "name", Value: "one"}},
+			bson.D{{Key: "name", Value: "two"}},
+		}}
+		executor := newFakeExecutor(fake)
+
+		Convey("It should run find with filter, sort, limit and offset", func() {
+			q := build("SELECT name FROM users WHERE name = 'one' LIMIT 10 OFFSET 2")
+			result, err := executor.Execute(q)
+			So(err, ShouldBeNil)
+
+			var docs []bson.M
+			So(result.All(context.Background(), &docs), ShouldBeNil)
+			So(docs, ShouldHaveLength, 2)
+			So(fake.calls, ShouldResemble, []string{"find"})
+			So(fake.filter, ShouldResemble, q.Filter)
+			So(*fake.find.Limit, ShouldEqual, 10)
+			So(*fake.find.Skip, ShouldEqual, 2)
+			So(fake.find.Projection, ShouldResemble, q.Projection)
+		})
+
+		Convey("It should run findone and yield a single document", func() {
+			result, err := executor.Execute(build("SELECT * FROM users LIMIT 1"))
+			So(err, ShouldBeNil)
+
+			var docs []bson.M
+			So(result.All(context.Background(), &docs), ShouldBeNil)
+			So(docs, ShouldHaveLength, 1)
+			So(docs[0]["name"], ShouldEqual, "one")
+		})
+
+		Convey("It should yield an empty result when findone has no match", func() {
+			fake.documents = nil
+			result, err := executor.Execute(build("SELECT * FROM users LIMIT 1"))
+			So(err, ShouldBeNil)
+			So(result.Next(context.Background()), ShouldBeFalse)
+		})
+
+		Convey("It should wrap count in a single document", func() {
+			result, err := executor.Execute(build("SELECT COUNT(q.*) FROM users AS q"))
+			So(err, ShouldBeNil)
+
+			var docs []bson.M
+			So(result.All(context.Background(), &docs), ShouldBeNil)
+			So(docs, ShouldResemble, []bson.M{{"count": int64(2)}})
+		})
+
+		Convey("It should apply LIMIT and OFFSET to the count rather than the documents", func() {
+			result, err := executor.Execute(build("SELECT COUNT(*) FROM users LIMIT 1"))
+			So(err, ShouldBeNil)
+
+			var docs []bson.M
+			So(result.All(context.Background(), &docs), ShouldBeNil)
+			So(docs, ShouldResemble, []bson.M{{"count": int64(2)}})
+
+			result, err = executor.Execute(build("SELECT COUNT(*) FROM users LIMIT 1 OFFSET 1"))
+			So(err, ShouldBeNil)
+			So(result.Next(context.Background()), ShouldBeFalse)
+		})
+
+		Convey("It should key distinct values by the projected field", func() {
+			result, err := executor.Execute(build("SELECT DISTINCT(theme) FROM questions"))
+			So(err, ShouldBeNil)
+			So(fake.field, ShouldEqual, "theme")
+
+			var docs []bson.M
+			So(result.All(context.Background(), &docs), ShouldBeNil)
+			So(docs, ShouldResemble, []bson.M{{"theme": "a"}, {"theme": "b"}})
+		})
+
+		Convey("It should pass the pipeline to aggregate", func() {
+			q := build("SELECT category, AVG(price) AS avg_price FROM products GROUP BY category")
+			_, err := executor.Execute(q)
+			So(err, ShouldBeNil)
+			So(fake.calls, ShouldResemble, []string{"aggregate"})
+			So(fake.pipeline, ShouldResemble, q.Pipeline)
+		})
+
+		Convey("It should reject an incomplete query", func() {
+			_, err := executor.Execute(NewQuery())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
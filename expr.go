@@ -0,0 +1,269 @@
+package squeel
+
+import (
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+isExprComparison reports whether a comparison has to be evaluated with $expr,
+because its right side is a column or expression rather than a literal, or
+its left side is computed. A field filter can only compare a field with a
+constant.
+
+Parameters:
+- expr: The comparison expression
+
+Returns:
+- Whether the comparison needs $expr
+*/
+func isExprComparison(expr *sqlparser.ComparisonExpr) bool {
+	if !isValidOperator(expr.Operator) {
+		return false
+	}
+
+	if _, ok := expr.Left.(*sqlparser.FuncExpr); ok && !isComputed(expr.Left) {
+		return false
+	}
+
+	switch right := expr.Right.(type) {
+	case *sqlparser.SQLVal, *sqlparser.NullVal, sqlparser.BoolVal:
+		return isComputed(expr.Left)
+	case sqlparser.ValTuple, *sqlparser.Subquery:
+		return false
+	case *sqlparser.ConvertExpr:
+		_, ok := dateLiteral(right)
+		return !ok || isComputed(expr.Left)
+	}
+	return true
+}
+
+/*
+isLiteralTuple reports whether the right side of IN is a list of literals,
+which a field filter can match with $in. A list holding a column or an
+expression has to be compared with $expr.
+
+Parameters:
+- expr: The right side of the IN comparison
+
+Returns:
+- Whether the right side is not a list, or a list of literals only
+*/
+func isLiteralTuple(expr sqlparser.Expr) bool {
+	tuple, ok := expr.(sqlparser.ValTuple)
+	if !ok {
+		return true
+	}
+
+	for _, value := range tuple {
+		switch value := value.(type) {
+		case *sqlparser.SQLVal, *sqlparser.NullVal, sqlparser.BoolVal:
+		case *sqlparser.ConvertExpr:
+			if _, ok := dateLiteral(value); !ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+/*
+parseExprComparison processes a comparison between two columns, or a column
+and an expression, converting it into an $expr condition, as in
+updated_at > created_at becoming {$gt: ["$updated_at", "$created_at"]}. With
+SQL null semantics the condition also requires every column it refers to to
+be non-null, as SQL never matches a comparison with NULL.
+
+Parameters:
+- q: The Query object to modify
+- expr: The comparison expression to process
+
+Returns:
+- The modified Query object with the $expr condition applied
+*/
+func (statement *Statement) parseExprComparison(q *Query, expr *sqlparser.ComparisonExpr) *Query {
+	left, ok := statement.comparisonOperand(expr.Left, expr.Right)
+	if !ok {
+		statement.unsupported(expr.Left, expr.Operator)
+		return q
+	}
+
+	right, ok := statement.comparisonOperand(expr.Right, expr.Left)
+	if !ok {
+		statement.unsupported(expr.Right, expr.Operator)
+		return q
+	}
+
+	condition := bson.M{mongoOperator(expr.Operator): bson.A{left, right}}
+
+	if statement.nulls != MongoNulls {
+		conditions := bson.A{}
+		for _, field := range statement.columnFields(expr.Left, expr.Right) {
+			conditions = append(conditions, bson.M{"$gt": bson.A{field, nil}})
+		}
+		if len(conditions) > 0 {
+			condition = bson.M{"$and": append(conditions, condition)}
+		}
+	}
+
+	q.Filter = appendExpr(q.Filter, condition)
+	return q
+}
+
+/*
+expression compiles a SQL expression into an aggregation expression. Columns
+become field paths, literals are typed, with strings that would be read as a
+field path wrapped in $literal, arithmetic becomes $add, $subtract,
+$multiply, $divide and $mod, CASE becomes $cond or $switch, and scalar
+functions such as UPPER become their aggregation operators.
+
+Parameters:
+- expr: The expression to compile
+
+Returns:
+- The aggregation expression
+- Whether the expression could be compiled
+*/
+func (statement *Statement) expression(expr sqlparser.Expr) (interface{}, bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		return statement.fieldExpression(expr), true
+	case *sqlparser.SQLVal, *sqlparser.NullVal, sqlparser.BoolVal, *sqlparser.ConvertExpr:
+		value, ok := statement.literal(expr)
+		if text, isText := value.(string); isText && strings.HasPrefix(text, "$") {
+			return bson.M{"$literal": text}, true
+		}
+		return value, ok
+	case *sqlparser.ParenExpr:
+		return statement.expression(expr.Expr)
+	case *sqlparser.BinaryExpr:
+		return statement.binaryExpression(expr)
+	case *sqlparser.UnaryExpr:
+		return statement.unaryExpression(expr)
+	case *sqlparser.CaseExpr:
+		return statement.caseExpression(expr)
+	case *sqlparser.FuncExpr:
+		return statement.functionExpression(expr)
+	case *sqlparser.SubstrExpr:
+		args := []sqlparser.Expr{expr.Name, expr.From}
+		if expr.To != nil {
+			args = append(args, expr.To)
+		}
+		return statement.callFunction(expr, "substring", args)
+	}
+
+	return nil, false
+}
+
+/*
+comparisonOperand compiles one side of a comparison. A string literal that
+is compared with a date, as in DATE(created_at) = '2020-01-01', is parsed as
+a date, since a string never equals the BSON date the other side computes.
+
+Parameters:
+- expr: The side of the comparison to compile
+- other: The side it is compared with
+
+Returns:
+- The aggregation expression
+- Whether the side could be compiled
+*/
+func (statement *Statement) comparisonOperand(expr, other sqlparser.Expr) (interface{}, bool) {
+	if val, ok := expr.(*sqlparser.SQLVal); ok && val.Type == sqlparser.StrVal && argumentType(other) == dateType {
+		if t, err := parseTimeWithFormats(string(val.Val)); err == nil {
+			return t, true
+		}
+	}
+	return statement.expression(expr)
+}
+
+/*
+isComputed reports whether an expression computes a value, with arithmetic,
+CASE or a scalar function, so it can only be evaluated as an aggregation
+expression.
+
+Parameters:
+- expr: The expression to check
+
+Returns:
+- Whether the expression is computed
+*/
+func isComputed(expr sqlparser.Expr) bool {
+	switch expr := expr.(type) {
+	case *sqlparser.CaseExpr, *sqlparser.SubstrExpr:
+		return true
+	case *sqlparser.FuncExpr:
+		_, ok := lookupFunction(expr.Name.Lowered())
+		return ok
+	case *sqlparser.ParenExpr:
+		return isComputed(expr.Expr)
+	}
+	return isArithmetic(expr)
+}
+
+/*
+columnFields returns the expressions reading the columns that expressions
+refer to, each column once.
+
+Parameters:
+- exprs: The expressions to search
+
+Returns:
+- The field expressions, in the order their columns first appear
+*/
+func (statement *Statement) columnFields(exprs ...sqlparser.Expr) []interface{} {
+	fields := make([]interface{}, 0)
+	seen := map[string]bool{}
+
+	for _, expr := range exprs {
+		_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			switch node := node.(type) {
+			case *sqlparser.ColName:
+				if path := strings.Join(statement.documentSegments(node), pathSeparator); !seen[path] {
+					seen[path] = true
+					fields = append(fields, statement.fieldExpression(node))
+				}
+			case *sqlparser.Subquery:
+				return false, nil
+			}
+			return true, nil
+		}, expr)
+	}
+
+	return fields
+}
+
+/*
+appendExpr adds an $expr condition to a filter. A filter can only hold one
+$expr, so a second condition is combined with the first using $and.
+
+Parameters:
+- filter: The filter to add the condition to
+- condition: The aggregation expression to add
+
+Returns:
+- The filter with the condition added
+*/
+func appendExpr(filter bson.D, condition interface{}) bson.D {
+	for i, element := range filter {
+		if element.Key != "$expr" {
+			continue
+		}
+
+		if existing, ok := element.Value.(bson.M); ok && len(existing) == 1 {
+			if and, ok := existing["$and"].(bson.A); ok {
+				filter[i].Value = bson.M{"$and": append(and, condition)}
+				return filter
+			}
+		}
+
+		filter[i].Value = bson.M{"$and": bson.A{element.Value, condition}}
+		return filter
+	}
+
+	return append(filter, bson.E{Key: "$expr", Value: condition})
+}
@@ -0,0 +1,88 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestExprComparison(t *testing.T) {
+	Convey("Given SQL comparing two columns", t, func() {
+		evaluator := newEvaluator(map[string][]interface{}{
+			"posts": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "created_at", Value: 10}, {Key: "updated_at", Value: 20}, {Key: "author", Value: "ann"}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "created_at", Value: 10}, {Key: "updated_at", Value: 10}, {Key: "author", Value: "ann"}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "created_at", Value: 30}, {Key: "updated_at", Value: 40}, {Key: "author", Value: "bob"}},
+				bson.D{{Key: "_id", Value: 4}, {Key: "created_at", Value: 30}, {Key: "author", Value: "bob"}},
+			},
+		})
+
+		Convey("It should compare the fields with $expr", func() {
+			q := build("SELECT _id FROM posts WHERE updated_at > created_at")
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "$expr", Value: bson.M{"$gt": bson.A{"$updated_at", "$created_at"}}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}, {"_id": int32(3)}})
+		})
+
+		Convey("It should mix $expr with field filters in the same $match", func() {
+			q := build("SELECT _id FROM posts WHERE author = 'bob' AND updated_at > created_at AND created_at <= (updated_at)")
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "author", Value: "bob"},
+				{Key: "$expr", Value: bson.M{"$and": bson.A{
+					bson.M{"$gt": bson.A{"$updated_at", "$created_at"}},
+					bson.M{"$lte": bson.A{"$created_at", "$updated_at"}},
+				}}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(3)}})
+		})
+
+		Convey("It should compare a literal on the left with a column", func() {
+			q := build("SELECT _id FROM posts WHERE 20 < created_at")
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "$expr", Value: bson.M{"$lt": bson.A{int64(20), "$created_at"}}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(3)}, {"_id": int32(4)}})
+		})
+
+		Convey("It should compare IN lists holding columns with $expr", func() {
+			q := build("SELECT _id FROM posts WHERE updated_at IN (created_at, 40)")
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "$expr", Value: bson.M{"$in": bson.A{"$updated_at", bson.A{"$created_at", int64(40)}}}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2)}, {"_id": int32(3)}})
+
+			q = build("SELECT _id FROM posts WHERE author = 'ann' AND created_at NOT IN (updated_at, updated_at - 10)")
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "author", Value: "ann"},
+				{Key: "$expr", Value: bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$created_at", bson.A{
+					"$updated_at",
+					bson.M{"$subtract": bson.A{"$updated_at", int64(10)}},
+				}}}}}},
+			})
+			So(evaluate(evaluator, q), ShouldBeEmpty)
+		})
+
+		Convey("It should reject a column compared with LIKE", func() {
+			_, err := NewStatement("SELECT _id FROM posts WHERE author LIKE created_at").Build(NewQuery())
+			So(err, ShouldHaveSameTypeAs, &UnsupportedError{})
+		})
+
+		Convey("It should exclude null and missing fields with SQL null semantics", func() {
+			So(evaluate(evaluator, build("SELECT _id FROM posts WHERE updated_at != created_at")), ShouldResemble, []bson.M{
+				{"_id": int32(1)}, {"_id": int32(3)}, {"_id": int32(4)},
+			})
+
+			q := build("SELECT _id FROM posts WHERE updated_at != created_at", WithNullSemantics(SQLNulls))
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "$expr", Value: bson.M{"$and": bson.A{
+					bson.M{"$gt": bson.A{"$updated_at", nil}},
+					bson.M{"$gt": bson.A{"$created_at", nil}},
+					bson.M{"$ne": bson.A{"$updated_at", "$created_at"}},
+				}}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}, {"_id": int32(3)}})
+		})
+	})
+}
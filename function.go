@@ -8,12 +8,11 @@ import (
 const mongoGroupStage = "$group"
 
 /*
-parseFunc processes SQL function expressions and converts them into appropriate
-MongoDB aggregation operations. It handles various SQL functions including COUNT,
-MIN, MAX, SUM, and AVG, converting them into equivalent MongoDB operations.
-
-The function determines whether to use a simple count operation or a more complex
-aggregation pipeline based on the presence of DISTINCT and aliases.
+parseFunc processes SQL function expressions encountered while walking the
+AST. Aggregate functions are accumulated by the SELECT list and the HAVING
+clause, so the only thing decided here is whether the whole statement is a
+plain row count, which MongoDB can answer with CountDocuments instead of an
+aggregation pipeline.
 
 Parameters:
 - q: The Query object to modify
@@ -27,205 +26,164 @@ func (statement *Statement) parseFunc(q *Query, node *sqlparser.FuncExpr) *Query
 		return q
 	}
 
-	switch node.Name.Lowered() {
-	case "count":
-		// Always use aggregate for DISTINCT counts or when there's an alias
-		if node.Distinct {
-			q.Operation = "aggregate"
-			return handleCountStar(q, node)
-		}
-
-		// Check if this COUNT is aliased in the parent expression
-		if parent, ok := statement.getParentExpr(node); ok && parent.As.String() != "" {
-			q.Operation = "aggregate"
-			return handleCountStar(q, node)
-		}
-
-		// For simple COUNT cases
-		if len(node.Exprs) <= 1 {
-			if len(node.Exprs) == 0 || isStarExpr(node.Exprs[0]) || sqlparser.String(node.Exprs[0]) == "q.*" {
-				q.Operation = "count"
-				return q
-			}
-		}
-
-		// For all other cases, use aggregate
-		q.Operation = "aggregate"
-		return handleRegularCount(q, node)
-
-	case "min", "max", "sum", "avg":
-		q.Operation = "aggregate"
-		return handleAggregateFunc(q, node)
+	if node.Name.Lowered() == "count" && statement.isCountQuery(node) {
+		q.Operation = "count"
 	}
 
 	return q
 }
 
 /*
-isStarExpr checks if a SELECT expression is a star (*) expression.
-This is used to identify COUNT(*) type queries.
+isCountQuery reports whether a COUNT function is the entire SELECT list of
+an ungrouped statement, as in SELECT COUNT(*) FROM users. An aliased count
+is left to the aggregation pipeline, so that its result carries the alias.
 
 Parameters:
-- expr: The SELECT expression to check
+- node: The COUNT function expression
 
 Returns:
-- true if the expression is a star expression, false otherwise
+- true if the statement can be run as a count operation
 */
-func isStarExpr(expr sqlparser.SelectExpr) bool {
-	if aliasedExpr, ok := expr.(*sqlparser.AliasedExpr); ok {
-		// Check if it's a star expression by checking the string representation
-		return sqlparser.String(aliasedExpr.Expr) == "*"
+func (statement *Statement) isCountQuery(node *sqlparser.FuncExpr) bool {
+	selectNode, ok := statement.stmt.(*sqlparser.Select)
+	if !ok || len(selectNode.SelectExprs) != 1 || len(selectNode.GroupBy) > 0 || selectNode.Having != nil {
+		return false
 	}
-	return false
+
+	aliased, ok := selectNode.SelectExprs[0].(*sqlparser.AliasedExpr)
+	if !ok || aliased.Expr != node || !aliased.As.IsEmpty() {
+		return false
+	}
+
+	return !node.Distinct && countsRows(node)
 }
 
 /*
-handleCountStar processes COUNT(*) and COUNT(DISTINCT ...) expressions,
-converting them into appropriate MongoDB aggregation stages.
+countsRows reports whether a COUNT function counts rows rather than values,
+as COUNT(*) and COUNT(t.*) do.
 
 Parameters:
-- q: The Query object to modify
-- node: The COUNT function expression to process
+- node: The COUNT function expression
 
 Returns:
-- The modified Query object with the count aggregation configured
+- true if every row is counted, false if only non-null values are
 */
-func handleCountStar(q *Query, node *sqlparser.FuncExpr) *Query {
-	q.Operation = "aggregate"
-	alias := getAlias(node)
-	groupStage := bson.M{
-		"_id": nil,
-		alias: bson.M{"$sum": 1},
+func countsRows(node *sqlparser.FuncExpr) bool {
+	if len(node.Exprs) == 0 {
+		return true
 	}
-	q.Pipeline = append(q.Pipeline, bson.D{{Key: mongoGroupStage, Value: groupStage}})
-	return q
+
+	if _, ok := node.Exprs[0].(*sqlparser.StarExpr); ok {
+		return true
+	}
+
+	return isStarExpr(node.Exprs[0])
 }
 
 /*
-handleRegularCount processes regular COUNT(column) expressions, converting
-them into MongoDB aggregation pipelines that count non-null values.
+isStarExpr checks if a SELECT expression is a star (*) expression.
+This is used to identify COUNT(*) type queries.
 
 Parameters:
-- q: The Query object to modify
-- node: The COUNT function expression to process
+- expr: The SELECT expression to check
 
 Returns:
-- The modified Query object with the count aggregation configured
+- true if the expression is a star expression, false otherwise
 */
-func handleRegularCount(q *Query, node *sqlparser.FuncExpr) *Query {
-	q.Operation = "aggregate"
-	if len(node.Exprs) == 0 {
-		return q
-	}
-
-	alias := getAlias(node)
-	if aliasedExpr, ok := node.Exprs[0].(*sqlparser.AliasedExpr); ok {
-		if colName, ok := aliasedExpr.Expr.(*sqlparser.ColName); ok {
-			field := colName.Name.CompliantName()
-			groupStage := bson.M{
-				"_id": nil,
-				alias: bson.M{"$sum": bson.M{
-					"$cond": []interface{}{
-						bson.M{"$ne": []interface{}{"$" + field, nil}},
-						1,
-						0,
-					},
-				}},
-			}
-			q.Pipeline = append(q.Pipeline, bson.D{{Key: mongoGroupStage, Value: groupStage}})
-		}
+func isStarExpr(expr sqlparser.SelectExpr) bool {
+	if aliasedExpr, ok := expr.(*sqlparser.AliasedExpr); ok {
+		// Check if it's a star expression by checking the string representation
+		return sqlparser.String(aliasedExpr.Expr) == "*"
 	}
-	return q
+	return false
 }
 
 /*
-handleAggregateFunc processes aggregate functions (MIN, MAX, SUM, AVG),
-converting them into appropriate MongoDB aggregation stages.
+aggregate adds an aggregate function to the statement's $group stage and
+returns the name its result is stored under. Without an alias, an aggregate
+that was already accumulated, for example by the SELECT list, is reused;
+otherwise a name is derived from the function and its column.
 
 Parameters:
 - q: The Query object to modify
-- node: The aggregate function expression to process
+- node: The aggregate function expression
+- alias: The name to store the result under, or empty to derive one
 
 Returns:
-- The modified Query object with the aggregation configured
+- The name of the accumulated result
+- Whether the function is a supported aggregate
 */
-func handleAggregateFunc(q *Query, node *sqlparser.FuncExpr) *Query {
-	q.Operation = "aggregate"
-	if len(node.Exprs) == 0 {
-		return q
+func (statement *Statement) aggregate(q *Query, node *sqlparser.FuncExpr, alias string) (string, bool) {
+	accumulator, set, ok := statement.accumulator(node)
+	if !ok {
+		return "", false
 	}
 
-	alias := getAlias(node)
-	if aliasedExpr, ok := node.Exprs[0].(*sqlparser.AliasedExpr); ok {
-		if colName, ok := aliasedExpr.Expr.(*sqlparser.ColName); ok {
-			field := colName.Name.CompliantName()
-			groupStage := bson.M{
-				"_id": nil,
-				alias: bson.M{"$" + node.Name.Lowered(): "$" + field},
-			}
-			q.Pipeline = append(q.Pipeline, bson.D{{Key: mongoGroupStage, Value: groupStage}})
+	sql := sqlparser.String(node)
+	if alias == "" {
+		if existing, ok := statement.pipeline.group.aliases[sql]; ok {
+			return existing, true
 		}
+		alias = statement.getAggregateAlias(&sqlparser.AliasedExpr{Expr: node}, node, node.Name.Lowered())
 	}
-	return q
+
+	q.Operation = "aggregate"
+	statement.pipeline.group.accumulate(sql, alias, accumulator, set)
+	return alias, true
 }
 
 /*
-getAlias determines the appropriate alias for an aggregate function result.
-It checks for explicit aliases in the expression, and if none is found,
-generates one based on the function name and field name.
+accumulator converts an aggregate function into a $group accumulator.
+
+  - COUNT(*) sums 1 for every row
+  - COUNT(column) sums 1 for every row where the column is not null
+  - COUNT(DISTINCT column) collects the set of values, which is counted after grouping
+  - SUM, AVG, MIN and MAX use the accumulator of the same name
+
+The argument is a column, or a computed expression as in SUM(price * qty) or
+SUM(CASE WHEN paid THEN total ELSE 0 END).
 
 Parameters:
-- node: The function expression to get an alias for
+- node: The aggregate function expression
 
 Returns:
-- The determined alias string
+- The accumulator expression
+- Whether the accumulator collects a set that must be counted
+- Whether the function is a supported aggregate
 */
-func getAlias(node *sqlparser.FuncExpr) string {
-	// Check if the first expression has an alias
-	if len(node.Exprs) > 0 {
-		if aliasedExpr, ok := node.Exprs[0].(*sqlparser.AliasedExpr); ok {
-			if aliasedExpr.As.String() != "" {
-				return aliasedExpr.As.String()
-			}
-			if colName, ok := aliasedExpr.Expr.(*sqlparser.ColName); ok {
-				return node.Name.Lowered() + "_" + colName.Name.CompliantName()
-			}
-		}
-	}
-	return node.Name.Lowered()
-}
+func (statement *Statement) accumulator(node *sqlparser.FuncExpr) (bson.M, bool, bool) {
+	name := node.Name.Lowered()
 
-/*
-getParentExpr attempts to find the parent AliasedExpr for a given FuncExpr
-by walking the SQL AST. This is used to determine if a function has an alias
-in its parent context.
+	if name == "count" && !node.Distinct && countsRows(node) {
+		return bson.M{"$sum": 1}, false, true
+	}
 
-Parameters:
-- node: The function expression to find the parent for
+	if len(node.Exprs) == 0 {
+		return nil, false, false
+	}
 
-Returns:
-- The parent AliasedExpr if found, and a boolean indicating success
-*/
-func (statement *Statement) getParentExpr(node *sqlparser.FuncExpr) (*sqlparser.AliasedExpr, bool) {
-	if statement.stmt == nil {
-		return nil, false
+	var field interface{}
+	if colExpr := statement.getColumnFromAliasedExpr(node.Exprs[0]); colExpr != nil {
+		field = statement.fieldExpression(colExpr)
+	} else if aliased, ok := node.Exprs[0].(*sqlparser.AliasedExpr); ok && isComputed(aliased.Expr) {
+		if field, ok = statement.expression(aliased.Expr); !ok {
+			return nil, false, false
+		}
+	} else {
+		return nil, false, false
 	}
 
-	var parent *sqlparser.AliasedExpr
-	found := false
-
-	_ = sqlparser.Walk(func(n sqlparser.SQLNode) (kontinue bool, err error) {
-		if aliased, ok := n.(*sqlparser.AliasedExpr); ok {
-			if funcExpr, ok := aliased.Expr.(*sqlparser.FuncExpr); ok {
-				if funcExpr == node {
-					parent = aliased
-					found = true
-					return false, nil
-				}
-			}
+	switch name {
+	case "count":
+		if node.Distinct {
+			return bson.M{"$addToSet": field}, true, true
 		}
-		return true, nil
-	}, statement.stmt)
+		return bson.M{"$sum": bson.M{
+			"$cond": bson.A{bson.M{"$gt": bson.A{field, nil}}, 1, 0},
+		}}, false, true
+	case "sum", "avg", "min", "max":
+		return bson.M{"$" + name: field}, false, true
+	}
 
-	return parent, found
+	return nil, false, false
 }
@@ -1,62 +1,237 @@
 package squeel
 
 import (
+	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/xwb1989/sqlparser"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
 /*
-parseGroupBy processes SQL GROUP BY clauses and their associated HAVING conditions,
-converting them into MongoDB aggregation pipeline stages. It creates appropriate
-$group stages and handles any HAVING clause filters.
+parseGroupBy processes SQL GROUP BY clauses, registering every grouped column
+or computed expression as a key of the statement's single $group stage. The
+HAVING clause is applied once the SELECT list has been processed, so that its
+aggregates resolve to the same accumulators as the SELECT list.
 
 Parameters:
 - q: The Query object to modify
 - groupBy: The GROUP BY clauses to process
-- having: The HAVING clause conditions to apply after grouping
+- selectExprs: The SELECT list, whose aliases GROUP BY may refer to
 
 Returns:
-- The modified Query object with grouping stages configured
+- The modified Query object with grouping configured
 */
-func (statement *Statement) parseGroupBy(q *Query, groupBy sqlparser.GroupBy, having *sqlparser.Where) *Query {
+func (statement *Statement) parseGroupBy(q *Query, groupBy sqlparser.GroupBy, selectExprs sqlparser.SelectExprs) *Query {
 	if len(groupBy) == 0 {
 		return q
 	}
 
 	q.Operation = "aggregate"
-	groupStage := buildGroupStage(groupBy)
-	q.Pipeline = append(q.Pipeline, bson.D{{Key: mongoGroupStage, Value: groupStage}})
+	for _, expr := range groupBy {
+		expr, name := groupExpr(expr, selectExprs)
+
+		if colName, ok := expr.(*sqlparser.ColName); ok {
+			statement.pipeline.group.key(statement.fieldPath(colName), statement.fieldExpression(colName))
+			continue
+		}
+
+		value, ok := statement.expression(expr)
+		if !ok || !isComputed(expr) {
+			statement.unsupported(expr, "")
+			continue
+		}
+		statement.pipeline.group.key(name, value)
+	}
 
-	return statement.addHavingClause(q, having)
+	return q
 }
 
 /*
-buildGroupStage creates a MongoDB $group stage from SQL GROUP BY expressions.
-It sets up the grouping fields and maintains the original field values in
-the output documents.
+groupExpr resolves a GROUP BY expression against the SELECT list. A column
+that names the alias of a computed SELECT expression, as in GROUP BY label
+for CASE ... END AS label, groups on that expression. A computed expression
+is keyed by the alias it has in the SELECT list, or by its SQL text.
 
 Parameters:
-- groupBy: The GROUP BY expressions to process
+- expr: The GROUP BY expression
+- selectExprs: The SELECT list
 
 Returns:
-- A bson.M document representing the $group stage configuration
+- The expression to group on
+- The name to key a computed expression by
 */
-func buildGroupStage(groupBy sqlparser.GroupBy) bson.M {
-	groupStage := bson.M{
-		"_id": bson.M{},
+func groupExpr(expr sqlparser.Expr, selectExprs sqlparser.SelectExprs) (sqlparser.Expr, string) {
+	col, isCol := expr.(*sqlparser.ColName)
+
+	for _, selectExpr := range selectExprs {
+		aliased, ok := selectExpr.(*sqlparser.AliasedExpr)
+		if !ok || aliased.As.IsEmpty() || !isComputed(aliased.Expr) {
+			continue
+		}
+
+		if isCol && col.Qualifier.IsEmpty() && col.Name.EqualString(aliased.As.String()) ||
+			sqlparser.String(aliased.Expr) == sqlparser.String(expr) {
+			return aliased.Expr, aliased.As.String()
+		}
 	}
 
-	for _, expr := range groupBy {
-		if colName, ok := expr.(*sqlparser.ColName); ok {
-			field := colName.Name.CompliantName()
-			groupStage["_id"].(bson.M)[field] = "$" + field
-			groupStage[field] = bson.M{"$first": "$" + field}
+	return expr, sqlparser.String(expr)
+}
+
+/*
+grouping accumulates the keys and accumulators of the single $group stage
+a SELECT produces. GROUP BY columns become keys of the _id document, and
+every aggregate function becomes an accumulator named after its alias.
+*/
+type grouping struct {
+	keys         bson.D            // The GROUP BY columns, keyed by their name in the _id document
+	names        map[string]string // The name of each GROUP BY column in the _id document, keyed by path
+	accumulators bson.D            // The accumulator expressions, keyed by alias
+	aliases      map[string]string // The alias of each aggregate, keyed by its SQL text
+	sets         []string          // Aliases collected as sets by COUNT(DISTINCT ...)
+}
+
+/*
+newGrouping creates an empty grouping.
+
+Returns:
+- A new grouping instance
+*/
+func newGrouping() *grouping {
+	return &grouping{
+		keys:         make(bson.D, 0),
+		names:        make(map[string]string),
+		accumulators: make(bson.D, 0),
+		aliases:      make(map[string]string),
+	}
+}
+
+/*
+key adds a GROUP BY column to the _id of the $group stage. Keys of the _id
+document cannot hold dots, so a nested path such as address.city is keyed
+as address_city.
+
+Parameters:
+- field: The path of the grouped column
+- value: The expression reading the column
+*/
+func (grouping *grouping) key(field string, value interface{}) {
+	if grouping.isKey(field) {
+		return
+	}
+
+	name := strings.NewReplacer(".", "_", "$", "_").Replace(field)
+	for hasKey(grouping.keys, name) {
+		name += "_"
+	}
+
+	grouping.names[field] = name
+	grouping.keys = append(grouping.keys, bson.E{Key: name, Value: value})
+}
+
+/*
+isKey reports whether a column is one of the GROUP BY keys.
+*/
+func (grouping *grouping) isKey(field string) bool {
+	_, ok := grouping.names[field]
+	return ok
+}
+
+/*
+keyField returns the path of a GROUP BY column in the grouped documents.
+*/
+func (grouping *grouping) keyField(field string) string {
+	return "_id." + grouping.names[field]
+}
+
+/*
+keyExpression returns the path in the grouped documents of the GROUP BY key
+that is read with the given expression, such as the $getField chain of a
+column without a dotted path.
+
+Parameters:
+- value: The expression reading the column
+
+Returns:
+- The path of the key
+- Whether a key is read with the expression
+*/
+func (grouping *grouping) keyExpression(value interface{}) (string, bool) {
+	for _, key := range grouping.keys {
+		if reflect.DeepEqual(key.Value, value) {
+			return "_id." + key.Key, true
 		}
 	}
+	return "", false
+}
+
+/*
+accumulate adds an accumulator to the $group stage under the given alias.
+An aggregate that is already accumulated under the same alias is not added
+again.
+
+Parameters:
+- sql: The SQL text of the aggregate function, or empty if it has none
+- alias: The output name of the accumulator
+- accumulator: The MongoDB accumulator expression
+- set: Whether the accumulator collects a set whose size is the result
+*/
+func (grouping *grouping) accumulate(sql, alias string, accumulator bson.M, set bool) {
+	if _, ok := grouping.aliases[sql]; !ok && sql != "" {
+		grouping.aliases[sql] = alias
+	}
+
+	if hasKey(grouping.accumulators, alias) {
+		return
+	}
+
+	grouping.accumulators = append(grouping.accumulators, bson.E{Key: alias, Value: accumulator})
+	if set {
+		grouping.sets = append(grouping.sets, alias)
+	}
+}
+
+/*
+empty reports whether the SELECT needs no $group stage at all.
+*/
+func (grouping *grouping) empty() bool {
+	return len(grouping.keys) == 0 && len(grouping.accumulators) == 0
+}
+
+/*
+stages builds the $group stage, followed by a $set stage that turns the sets
+collected for COUNT(DISTINCT ...) into counts. NULL values are not counted,
+as in SQL.
+
+Returns:
+- The stages implementing the grouping, or nil if there is nothing to group
+*/
+func (grouping *grouping) stages() []bson.D {
+	if grouping.empty() {
+		return nil
+	}
+
+	var id interface{}
+	if len(grouping.keys) > 0 {
+		id = grouping.keys
+	}
 
-	return groupStage
+	group := append(bson.D{{Key: "_id", Value: id}}, grouping.accumulators...)
+	stages := []bson.D{{{Key: mongoGroupStage, Value: group}}}
+
+	if len(grouping.sets) > 0 {
+		counts := bson.D{}
+		for _, alias := range grouping.sets {
+			counts = append(counts, bson.E{Key: alias, Value: bson.M{
+				"$size": bson.M{"$setDifference": bson.A{"$" + alias, bson.A{nil}}},
+			}})
+		}
+		stages = append(stages, bson.D{{Key: "$set", Value: counts}})
+	}
+
+	return stages
 }
 
 /*
@@ -76,8 +251,8 @@ func (statement *Statement) addHavingClause(q *Query, having *sqlparser.Where) *
 		return q
 	}
 
-	if matchStage := statement.parseHavingExpr(having.Expr); matchStage != nil {
-		q.Pipeline = append(q.Pipeline, bson.D{{Key: "$match", Value: matchStage}})
+	if matchStage := statement.parseHavingExpr(q, having.Expr); matchStage != nil {
+		statement.pipeline.add(clauseHaving, bson.D{{Key: "$match", Value: matchStage}})
 	}
 
 	return q
@@ -89,15 +264,18 @@ converts it into a MongoDB match condition. Currently handles comparison
 expressions.
 
 Parameters:
+- q: The Query object being built
 - expr: The HAVING clause expression to process
 
 Returns:
 - A bson.M document representing the match condition, or nil if not applicable
 */
-func (statement *Statement) parseHavingExpr(expr sqlparser.Expr) bson.M {
+func (statement *Statement) parseHavingExpr(q *Query, expr sqlparser.Expr) bson.M {
 	if compExpr, ok := expr.(*sqlparser.ComparisonExpr); ok {
-		return statement.parseHavingComparison(compExpr)
+		return statement.parseHavingComparison(q, compExpr)
 	}
+
+	statement.unsupported(expr, "")
 	return nil
 }
 
@@ -107,23 +285,22 @@ converting it into a MongoDB comparison operator. It validates the operator
 and handles value conversion.
 
 Parameters:
+- q: The Query object being built
 - expr: The comparison expression to process
 
 Returns:
 - A bson.M document representing the comparison condition, or nil if invalid
 */
-func (statement *Statement) parseHavingComparison(expr *sqlparser.ComparisonExpr) bson.M {
-	if !isValidOperator(expr.Operator) {
-		return nil
-	}
-
+func (statement *Statement) parseHavingComparison(q *Query, expr *sqlparser.ComparisonExpr) bson.M {
 	val, ok := expr.Right.(*sqlparser.SQLVal)
-	if !ok {
+	if !isValidOperator(expr.Operator) || !ok {
+		statement.unsupported(expr, expr.Operator)
 		return nil
 	}
 
-	field := statement.getComparisonField(expr.Left)
+	field := statement.getComparisonField(q, expr.Left)
 	if field == "" {
+		statement.unsupported(expr.Left, "")
 		return nil
 	}
 
@@ -135,56 +312,33 @@ func (statement *Statement) parseHavingComparison(expr *sqlparser.ComparisonExpr
 }
 
 /*
-getComparisonField extracts the field name from a HAVING clause expression,
-handling both simple column references and function calls.
+getComparisonField resolves the left-hand side of a HAVING comparison to the
+field it refers to after grouping. GROUP BY columns live in the _id of the
+grouped documents, select aliases are accumulator names, and aggregate
+functions resolve to the accumulator that computes them.
 
 Parameters:
+- q: The Query object being built
 - left: The left-hand side of the comparison
 
 Returns:
 - The field name to use in the MongoDB comparison, or empty string if invalid
 */
-func (statement *Statement) getComparisonField(left sqlparser.Expr) string {
+func (statement *Statement) getComparisonField(q *Query, left sqlparser.Expr) string {
 	switch left := left.(type) {
 	case *sqlparser.ColName:
-		return left.Name.CompliantName()
+		field := statement.fieldPath(left)
+		if statement.pipeline.group.isKey(field) {
+			return statement.pipeline.group.keyField(field)
+		}
+		return field
 	case *sqlparser.FuncExpr:
-		return statement.getFuncField(left)
+		alias, _ := statement.aggregate(q, left, "")
+		return alias
 	}
 	return ""
 }
 
-/*
-getFuncField determines the appropriate field name for a function expression
-in a HAVING clause. It handles both aliased and non-aliased function calls.
-
-Parameters:
-- funcExpr: The function expression to process
-
-Returns:
-- The field name to use in the MongoDB comparison
-*/
-func (statement *Statement) getFuncField(funcExpr *sqlparser.FuncExpr) string {
-	if len(funcExpr.Exprs) == 0 {
-		return funcExpr.Name.Lowered()
-	}
-
-	aliasedExpr, ok := funcExpr.Exprs[0].(*sqlparser.AliasedExpr)
-	if !ok {
-		return funcExpr.Name.Lowered()
-	}
-
-	if aliasedExpr.As.String() != "" {
-		return aliasedExpr.As.String()
-	}
-
-	if colName, ok := aliasedExpr.Expr.(*sqlparser.ColName); ok {
-		return funcExpr.Name.Lowered() + "_" + colName.Name.CompliantName()
-	}
-
-	return funcExpr.Name.Lowered()
-}
-
 /*
 isValidOperator checks if a comparison operator is supported in HAVING clauses.
 Supported operators include standard comparison operators.
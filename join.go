@@ -1,44 +1,468 @@
 package squeel
 
 import (
+	"reflect"
+	"strings"
+
 	"github.com/xwb1989/sqlparser"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
 /*
 parseJoin processes a SQL JOIN expression and converts it into MongoDB's $lookup aggregation.
-It extracts information from the JoinTableExpr node to build a lookup stage that will be
-added to the query pipeline. The function handles both the left and right expressions
-of the join, setting up the appropriate collection references and field mappings.
+The table on the left of the JOIN becomes the collection the query runs on, and the table
+on the right is looked up into it, nested under its alias. A chain of JOINs parses into a
+tree whose left side is the preceding JOIN, which is processed first, so the chain becomes
+an ordered sequence of stages in which later ON clauses can refer to the tables joined
+before them.
 
-The function modifies the Query object in place, adding a $lookup stage to its pipeline
-with the following components:
+The $lookup stage is added to the FROM slot of the pipeline, with the following
+components:
 - from: the collection to join with
-- as: the field to store the joined documents
 - localField: the field from the main collection
 - foreignField: the field from the joined collection
+- as: the alias of the joined table
+
+Any other ON clause, such as a compound or non-equi condition, becomes a $lookup
+with let variables for the local fields and a pipeline that matches them, see
+joinPipeline.
+
+The joined array is then unwound, so every row holds a single joined document:
+- INNER JOIN drops rows without a match
+- LEFT JOIN keeps rows without a match, with preserveNullAndEmptyArrays
+- RIGHT JOIN is rewritten as a LEFT JOIN with both tables swapped
+
+Parameters:
+- q: The Query object to modify
+- node: The JOIN expression to process
+
+Returns:
+- The modified Query object with the join stages added
 */
 func (statement *Statement) parseJoin(q *Query, node *sqlparser.JoinTableExpr) *Query {
-	if name := statement.aliasedTableName(node.LeftExpr); name != "" {
-		q.Collection = name
-		q.Pipeline = append(q.Pipeline, bson.D{{
-			Key:   "$lookup",
-			Value: bson.M{},
-		}})
+	root, joined := node.LeftExpr, node.RightExpr
+	preserve := node.Join == sqlparser.LeftJoinStr
+
+	if node.Join == sqlparser.RightJoinStr {
+		root, joined = joined, root
+		preserve = true
+	}
+
+	if !statement.parseJoinRoot(q, root) {
+		return q
 	}
 
-	if name := statement.aliasedTableName(node.RightExpr); name != "" {
-		q.Pipeline[0][0].Value.(bson.M)["from"] = name
-		q.Pipeline[0][0].Value.(bson.M)["as"] = name
+	joinedTable, ok := unparenTable(joined).(*sqlparser.AliasedTableExpr)
+	if !ok {
+		statement.unsupported(joined, node.Join)
+		return q
 	}
 
-	if name := statement.colName(statement.on(node).Left); name != "" {
-		q.Pipeline[0][0].Value.(bson.M)["localField"] = name
+	from, as := tableName(joinedTable)
+	if from == "" {
+		statement.unsupported(joinedTable, node.Join)
+		return q
 	}
+	statement.tables.add(as, from)
+
+	lookup := bson.D{{Key: "from", Value: from}}
 
-	if name := statement.colName(statement.on(node).Right); name != "" {
-		q.Pipeline[0][0].Value.(bson.M)["localField"] = name
+	if localField, foreignField, ok := statement.joinFields(node.Condition, as); ok {
+		lookup = append(lookup,
+			bson.E{Key: "localField", Value: localField},
+			bson.E{Key: "foreignField", Value: foreignField},
+		)
+	} else if let, pipeline, ok := statement.joinPipeline(q, node.Condition.On, as); ok {
+		if len(let) > 0 {
+			lookup = append(lookup, bson.E{Key: "let", Value: let})
+		}
+		lookup = append(lookup, bson.E{Key: "pipeline", Value: pipeline})
+	} else {
+		statement.unsupported(node, node.Join)
+		return q
 	}
 
+	statement.pipeline.add(clauseFrom,
+		bson.D{{Key: "$lookup", Value: append(lookup, bson.E{Key: "as", Value: as})}},
+		unwindStage(as, preserve),
+	)
+
 	return q
 }
+
+/*
+parseJoinRoot processes the side of a JOIN that the joined table is looked
+up into: either the table the query runs on, or the JOINs that precede it
+in a chain.
+
+Parameters:
+- q: The Query object to modify
+- expr: The table expression on the root side of the JOIN
+
+Returns:
+- true if the expression was processed, false if it is not supported
+*/
+func (statement *Statement) parseJoinRoot(q *Query, expr sqlparser.TableExpr) bool {
+	switch expr := unparenTable(expr).(type) {
+	case *sqlparser.AliasedTableExpr:
+		statement.handleAliasedTable(q, expr)
+		return true
+	case *sqlparser.JoinTableExpr:
+		statement.parseJoin(q, expr)
+		return true
+	}
+
+	statement.unsupported(expr, "")
+	return false
+}
+
+/*
+unparenTable removes the parentheses around a single table expression, as
+in FROM (a JOIN b ON ...) JOIN c ON ....
+
+Parameters:
+- expr: The table expression
+
+Returns:
+- The table expression without parentheses
+*/
+func unparenTable(expr sqlparser.TableExpr) sqlparser.TableExpr {
+	for {
+		paren, ok := expr.(*sqlparser.ParenTableExpr)
+		if !ok || len(paren.Exprs) != 1 {
+			return expr
+		}
+		expr = paren.Exprs[0]
+	}
+}
+
+/*
+joinFields resolves the condition of an equi-join into the localField and
+foreignField of a $lookup. The column of the comparison that belongs to the
+joined table becomes the foreignField, in whichever order the ON clause
+names them, and the other column is resolved against the tables that are
+already part of the query, so it can be a field of an earlier joined table
+such as u.Accounts. A qualifier that names none of these tables is left to
+joinPipeline, which rejects it.
+
+Parameters:
+- condition: The ON or USING condition of the join
+- joined: The name of the joined table
+
+Returns:
+- The local field
+- The foreign field
+- Whether the condition is an equality between a column of the joined table and another column
+*/
+func (statement *Statement) joinFields(condition sqlparser.JoinCondition, joined string) (string, string, bool) {
+	if len(condition.Using) == 1 {
+		field := condition.Using[0].String()
+		return field, field, true
+	}
+
+	comparison, ok := condition.On.(*sqlparser.ComparisonExpr)
+	if !ok || comparison.Operator != sqlparser.EqualStr {
+		return "", "", false
+	}
+
+	local, ok := comparison.Left.(*sqlparser.ColName)
+	if !ok {
+		return "", "", false
+	}
+
+	foreign, ok := comparison.Right.(*sqlparser.ColName)
+	if !ok {
+		return "", "", false
+	}
+
+	if columnQualifier(local) == joined {
+		local, foreign = foreign, local
+	}
+
+	segments := columnSegments(foreign)
+	if columnQualifier(foreign) == joined {
+		segments = segments[1:]
+	} else if len(segments) > 1 {
+		return "", "", false
+	}
+
+	if qualifier := columnQualifier(local); qualifier == joined || qualifier != "" && !statement.tables.has(qualifier) {
+		return "", "", false
+	}
+
+	if !statement.plainColumn(local) || !plainSegments(segments) {
+		return "", "", false
+	}
+
+	return statement.fieldPath(local), strings.Join(segments, "."), true
+}
+
+/*
+joinPipeline compiles an arbitrary ON clause into the let variables and the
+pipeline of a $lookup. Every column of an earlier table is bound to a let
+variable, since the pipeline only sees the documents of the joined table.
+The conditions compile as they would in WHERE, so comparisons between a
+column of the joined table and a literal become plain $match conditions,
+which can use the joined collection's indexes, and every other comparison
+becomes part of an $expr.
+
+For example, ON o.user_id = u._id AND o.total > 10 becomes:
+
+	let: {local__id: "$_id"}
+	pipeline: [{$match: {$expr: {$eq: ["$user_id", "$$local__id"]}, total: {$gt: 10}}}]
+
+Parameters:
+- q: The Query object being built
+- on: The ON clause of the join
+- joined: The name of the joined table
+
+Returns:
+- The let variables
+- The lookup pipeline
+- Whether every part of the ON clause could be compiled
+*/
+func (statement *Statement) joinPipeline(q *Query, on sqlparser.Expr, joined string) (bson.D, bson.A, bool) {
+	if on == nil {
+		return nil, nil, false
+	}
+
+	condition := &joinCondition{
+		statement:  statement,
+		joined:     joined,
+		collection: statement.tables.collection(joined, q.Collection),
+		fallback:   q.Collection,
+		let:        bson.D{},
+	}
+
+	match, ok := condition.compile(on)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return condition.let, bson.A{bson.D{{Key: "$match", Value: match}}}, true
+}
+
+/*
+joinCondition compiles the ON clause of a join into the $match stage of a
+pipeline-style $lookup, collecting the let variables it refers to.
+*/
+type joinCondition struct {
+	statement   *Statement
+	joined      string // The name of the joined table
+	collection  string // The collection of the joined table
+	fallback    string // The collection the query runs on
+	let         bson.D // The let variables, keyed by variable name
+	unqualified bool   // Whether unqualified columns belong to the joined table, as in a subquery
+}
+
+/*
+compile converts a boolean ON expression into a $match document with the
+WHERE compiler, which reads the columns of the joined table from the joined
+documents and the columns of earlier tables from let variables. A column
+whose qualifier names no table of the statement cannot be compiled, as it
+would otherwise be read as a field of the documents the query runs on.
+
+Parameters:
+- expr: The expression to compile
+
+Returns:
+- The $match document
+- Whether the expression could be compiled
+*/
+func (condition *joinCondition) compile(expr sqlparser.Expr) (bson.D, bool) {
+	statement := condition.statement
+	if !condition.supported(expr) {
+		statement.unsupported(expr, "")
+		return nil, false
+	}
+
+	collection := condition.fallback
+	if condition.unqualified {
+		collection = condition.collection
+	}
+
+	scope, strict, failed := statement.scope, statement.strict, statement.unsupportedErr
+	statement.scope, statement.strict, statement.unsupportedErr = condition, true, nil
+	q := statement.parseWhereExpr(&Query{Collection: collection}, expr)
+	err := statement.unsupportedErr
+	statement.scope, statement.strict, statement.unsupportedErr = scope, strict, failed
+
+	if err != nil {
+		if strict && failed == nil {
+			statement.unsupportedErr = err
+		}
+		return nil, false
+	}
+
+	if q.Filter == nil {
+		return bson.D{}, true
+	}
+	return q.Filter, true
+}
+
+/*
+supported reports whether every column of an ON expression belongs to a
+table of the statement, and the expression holds no subquery.
+
+Parameters:
+- expr: The expression to check
+
+Returns:
+- Whether the expression can be compiled
+*/
+func (condition *joinCondition) supported(expr sqlparser.Expr) bool {
+	supported := true
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.Subquery:
+			supported = false
+		case *sqlparser.ColName:
+			qualifier := columnQualifier(node)
+			if !condition.unqualified && qualifier != "" && qualifier != condition.joined && !condition.statement.tables.has(qualifier) {
+				supported = false
+			}
+		}
+		return supported, nil
+	}, expr)
+	return supported
+}
+
+/*
+foreign reports whether a column belongs to the joined table.
+*/
+func (condition *joinCondition) foreign(col *sqlparser.ColName) bool {
+	qualifier := columnQualifier(col)
+	if qualifier == condition.joined {
+		return true
+	}
+	return condition.unqualified && !condition.statement.tables.has(qualifier)
+}
+
+/*
+path returns the path of a column of the joined table within the joined
+documents.
+*/
+func (condition *joinCondition) path(col *sqlparser.ColName) []string {
+	segments := columnSegments(col)
+	if len(segments) > 1 && segments[0] == condition.joined {
+		return segments[1:]
+	}
+	return segments
+}
+
+/*
+literal converts a literal compared with a column, converting UUIDs that are
+compared with an ID field of an uppercase collection to Binary.
+
+Parameters:
+- col: The column the literal is compared with
+- val: The literal
+
+Returns:
+- The converted value
+*/
+func (condition *joinCondition) literal(col *sqlparser.ColName, val *sqlparser.SQLVal) interface{} {
+	value := condition.statement.parseValue(val)
+
+	collection := condition.collection
+	if !condition.foreign(col) {
+		collection = condition.statement.tables.collection(condition.statement.resolveColumn(col).table, condition.fallback)
+	}
+
+	if isIDField(col.Name.String(), collection) {
+		if id, err := condition.statement.parseIDValue(value, collection); err == nil {
+			return id
+		}
+	}
+
+	return value
+}
+
+/*
+variable returns the name of the let variable bound to a local column,
+binding it on first use. Variable names must start with a lowercase letter
+and may only contain letters, digits and underscores.
+
+Parameters:
+- col: The local column
+
+Returns:
+- The name of the variable
+*/
+func (condition *joinCondition) variable(col *sqlparser.ColName) string {
+	scope := condition.statement.scope
+	condition.statement.scope = nil
+	defer func() { condition.statement.scope = scope }()
+
+	path := condition.statement.fieldPath(col)
+	value := condition.statement.fieldExpression(col)
+
+	for _, variable := range condition.let {
+		if reflect.DeepEqual(variable.Value, value) {
+			return variable.Key
+		}
+	}
+
+	name := "local_" + strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, path)
+
+	for hasKey(condition.let, name) {
+		name += "_"
+	}
+
+	condition.let = append(condition.let, bson.E{Key: name, Value: value})
+	return name
+}
+
+/*
+splitExpr splits a boolean expression into its operands, flattening nested
+ANDs, or ORs, and parentheses.
+
+Parameters:
+- expr: The expression to split
+- and: Whether to split on AND rather than OR
+
+Returns:
+- The operands of the expression
+*/
+func splitExpr(expr sqlparser.Expr, and bool) []sqlparser.Expr {
+	switch node := expr.(type) {
+	case *sqlparser.ParenExpr:
+		return splitExpr(node.Expr, and)
+	case *sqlparser.AndExpr:
+		if and {
+			return append(splitExpr(node.Left, and), splitExpr(node.Right, and)...)
+		}
+	case *sqlparser.OrExpr:
+		if !and {
+			return append(splitExpr(node.Left, and), splitExpr(node.Right, and)...)
+		}
+	}
+	return []sqlparser.Expr{expr}
+}
+
+/*
+unwindStage builds the $unwind stage that turns the array a $lookup produces
+into a single joined document per row.
+
+Parameters:
+- as: The field the joined documents are stored in
+- preserve: Whether rows without a joined document are kept
+
+Returns:
+- The $unwind stage
+*/
+func unwindStage(as string, preserve bool) bson.D {
+	if !preserve {
+		return bson.D{{Key: "$unwind", Value: "$" + as}}
+	}
+
+	return bson.D{{Key: "$unwind", Value: bson.D{
+		{Key: "path", Value: "$" + as},
+		{Key: "preserveNullAndEmptyArrays", Value: true},
+	}}}
+}
@@ -0,0 +1,214 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestJoin(t *testing.T) {
+	Convey("Given SQL with a JOIN", t, func() {
+		evaluator := newEvaluator(evaluatorFixtures)
+
+		Convey("It should look up the joined table by its own column", func() {
+			q := buildAggregate("SELECT o._id, u.name FROM orders o JOIN users u ON u._id = o.user_id ORDER BY o._id")
+			So(q.Collection, ShouldEqual, "orders")
+			So(q.Pipeline[0], ShouldResemble, bson.D{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "users"},
+				{Key: "localField", Value: "user_id"},
+				{Key: "foreignField", Value: "_id"},
+				{Key: "as", Value: "u"},
+			}}})
+			So(q.Pipeline[1], ShouldResemble, bson.D{{Key: "$unwind", Value: "$u"}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(10), "u": bson.D{{Key: "name", Value: "ann"}}},
+				{"_id": int32(11), "u": bson.D{{Key: "name", Value: "ann"}}},
+				{"_id": int32(12), "u": bson.D{{Key: "name", Value: "cid"}}},
+			})
+		})
+
+		Convey("It should drop rows without a match for an INNER JOIN", func() {
+			q := buildAggregate("SELECT u.name FROM users u INNER JOIN orders o ON u._id = o.user_id ORDER BY u.name")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(1), "name": "ann"},
+				{"_id": int32(1), "name": "ann"},
+				{"_id": int32(3), "name": "cid"},
+			})
+		})
+
+		Convey("It should keep rows without a match for a LEFT JOIN", func() {
+			q := buildAggregate("SELECT u.name, o.total FROM users u LEFT JOIN orders o ON u._id = o.user_id ORDER BY u.name, o.total")
+			So(q.Pipeline[1], ShouldResemble, bson.D{{Key: "$unwind", Value: bson.D{
+				{Key: "path", Value: "$o"},
+				{Key: "preserveNullAndEmptyArrays", Value: true},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(1), "name": "ann", "o": bson.D{{Key: "total", Value: int32(5)}}},
+				{"_id": int32(1), "name": "ann", "o": bson.D{{Key: "total", Value: int32(20)}}},
+				{"_id": int32(2), "name": "bob"},
+				{"_id": int32(3), "name": "cid", "o": bson.D{{Key: "total", Value: int32(7)}}},
+			})
+		})
+
+		Convey("It should run a RIGHT JOIN as a LEFT JOIN on the right table", func() {
+			q := buildAggregate("SELECT u.name FROM orders o RIGHT JOIN users u ON o.user_id = u._id ORDER BY u.name")
+			So(q.Collection, ShouldEqual, "users")
+			So(q.Pipeline[0][0].Value.(bson.D)[1:3], ShouldResemble, bson.D{
+				{Key: "localField", Value: "_id"},
+				{Key: "foreignField", Value: "user_id"},
+			})
+			So(evaluate(evaluator, q), ShouldHaveLength, 4)
+		})
+
+		Convey("It should join on a column named in USING", func() {
+			q := buildAggregate("SELECT * FROM orders JOIN invoices i USING (user_id)")
+			So(q.Pipeline[0][0].Value.(bson.D)[1:3], ShouldResemble, bson.D{
+				{Key: "localField", Value: "user_id"},
+				{Key: "foreignField", Value: "user_id"},
+			})
+		})
+
+		Convey("It should convert ids of a joined uppercase collection to Binary", func() {
+			q := buildAggregate("SELECT * FROM devices d JOIN User u ON d.UserId = u._id WHERE u._id = '" + uuidIn + "' AND d.UserId = '" + uuidIn + "'")
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "u._id", Value: uuidBin},
+				{Key: "UserId", Value: uuidIn},
+			})
+		})
+	})
+
+	Convey("Given SQL with a chain of JOINs", t, func() {
+		evaluator := newEvaluator(map[string][]interface{}{
+			"Device": {
+				bson.D{{Key: "_id", Value: "d1"}, {Key: "UserId", Value: 1}, {Key: "PushToken", Value: "t1"}},
+				bson.D{{Key: "_id", Value: "d2"}, {Key: "UserId", Value: 2}, {Key: "PushToken", Value: "t2"}},
+				bson.D{{Key: "_id", Value: "d3"}, {Key: "UserId", Value: 9}, {Key: "PushToken", Value: "t3"}},
+			},
+			"User": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "Accounts", Value: bson.A{100, 101}}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "Accounts", Value: bson.A{101}}},
+			},
+			"Account": {
+				bson.D{{Key: "_id", Value: 100}, {Key: "Name", Value: "a"}},
+				bson.D{{Key: "_id", Value: 101}, {Key: "Name", Value: "b"}},
+			},
+		})
+
+		Convey("It should look up every table in order, nested under its alias", func() {
+			q, err := NewStatement("SELECT d.PushToken FROM Device d JOIN User u ON d.UserId = u._id JOIN Account a ON a._id = u.Accounts WHERE a.Name = 'b' ORDER BY d.PushToken").Build(NewQuery())
+			So(err, ShouldBeNil)
+			So(q.Collection, ShouldEqual, "Device")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$lookup", "$unwind", "$lookup", "$unwind", "$match", "$project", "$sort"})
+			So(q.Pipeline[2], ShouldResemble, bson.D{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "Account"},
+				{Key: "localField", Value: "u.Accounts"},
+				{Key: "foreignField", Value: "_id"},
+				{Key: "as", Value: "a"},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": "d1", "PushToken": "t1"},
+				{"_id": "d2", "PushToken": "t2"},
+			})
+		})
+
+		Convey("It should join parenthesized chains of LEFT JOINs", func() {
+			q, err := NewStatement("SELECT d.PushToken FROM (Device d LEFT JOIN User u ON d.UserId = u._id) LEFT JOIN Account a ON u.Accounts = a._id ORDER BY d.PushToken").Build(NewQuery())
+			So(err, ShouldBeNil)
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": "d1", "PushToken": "t1"},
+				{"_id": "d1", "PushToken": "t1"},
+				{"_id": "d2", "PushToken": "t2"},
+				{"_id": "d3", "PushToken": "t3"},
+			})
+		})
+
+		Convey("It should convert ids compared with any joined uppercase collection", func() {
+			q, err := NewStatement("SELECT d._id FROM Device d JOIN User u ON d.UserId = u._id JOIN Account a ON u.Accounts = a._id WHERE a._id = '" + uuidIn + "' AND u.Deleted = 'x'").Build(NewQuery())
+			So(err, ShouldBeNil)
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "a._id", Value: uuidBin},
+				{Key: "u.Deleted", Value: "x"},
+			})
+		})
+	})
+}
+
+func TestJoinPipeline(t *testing.T) {
+	Convey("Given SQL with a compound or non-equi JOIN condition", t, func() {
+		evaluator := newEvaluator(evaluatorFixtures)
+
+		Convey("It should match literals on the joined collection and columns with $expr", func() {
+			q := buildAggregate("SELECT u.name FROM users u JOIN orders o ON o.user_id = u._id AND o.total > 6 ORDER BY u.name")
+			So(q.Pipeline[0], ShouldResemble, bson.D{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"},
+				{Key: "let", Value: bson.D{{Key: "local__id", Value: "$_id"}}},
+				{Key: "pipeline", Value: bson.A{bson.D{{Key: "$match", Value: bson.D{
+					{Key: "$expr", Value: bson.M{"$eq": bson.A{"$user_id", "$$local__id"}}},
+					{Key: "total", Value: bson.M{"$gt": int64(6)}},
+				}}}}},
+				{Key: "as", Value: "o"},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(1), "name": "ann"},
+				{"_id": int32(3), "name": "cid"},
+			})
+		})
+
+		Convey("It should join on inequalities between columns", func() {
+			q := buildAggregate("SELECT o._id FROM orders o JOIN users u ON u.age < o.total")
+			So(q.Pipeline[0][0].Value.(bson.D)[1:3], ShouldResemble, bson.D{
+				{Key: "let", Value: bson.D{{Key: "local_total", Value: "$total"}}},
+				{Key: "pipeline", Value: bson.A{bson.D{{Key: "$match", Value: bson.D{
+					{Key: "$expr", Value: bson.M{"$lt": bson.A{"$age", "$$local_total"}}},
+				}}}}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(10)}})
+		})
+
+		Convey("It should combine several column comparisons and OR", func() {
+			q := buildAggregate("SELECT o._id FROM orders o LEFT JOIN users u ON u._id = o.user_id AND (u.age > 40 OR 18 > u.age) AND o.total >= u.age ORDER BY o._id")
+			So(q.Pipeline[0][0].Value.(bson.D)[2].Value, ShouldResemble, bson.A{bson.D{{Key: "$match", Value: bson.D{
+				{Key: "$expr", Value: bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$_id", "$$local_user_id"}},
+					bson.M{"$gte": bson.A{"$$local_total", "$age"}},
+				}}},
+				{Key: "$or", Value: bson.A{
+					bson.D{{Key: "age", Value: bson.M{"$gt": int64(40)}}},
+					bson.D{{Key: "$expr", Value: bson.M{"$gt": bson.A{int64(18), "$age"}}}},
+				}},
+			}}}})
+			So(evaluate(evaluator, q), ShouldHaveLength, 3)
+		})
+
+		Convey("It should convert UUID literals compared with ids of an uppercase collection", func() {
+			q := buildAggregate("SELECT * FROM Device d JOIN User u ON u._id = d.UserId AND u.TenantId = '" + uuidIn + "'")
+			So(q.Pipeline[0][0].Value.(bson.D)[2].Value, ShouldResemble, bson.A{bson.D{{Key: "$match", Value: bson.D{
+				{Key: "$expr", Value: bson.M{"$eq": bson.A{"$_id", "$$local_UserId"}}},
+				{Key: "TenantId", Value: uuidBin},
+			}}}})
+		})
+
+		Convey("It should compile conditions on the joined table like WHERE", func() {
+			q := buildAggregate("SELECT o._id FROM orders o JOIN users u ON u._id = o.user_id AND u.name LIKE 'a%' ORDER BY o._id")
+			So(q.Pipeline[0][0].Value.(bson.D)[2].Value, ShouldResemble, bson.A{bson.D{{Key: "$match", Value: bson.D{
+				{Key: "$expr", Value: bson.M{"$eq": bson.A{"$_id", "$$local_user_id"}}},
+				{Key: "name", Value: bson.M{"$regex": "^a"}},
+			}}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(10)}, {"_id": int32(11)}})
+
+			for sql, want := range map[string][]bson.M{
+				"SELECT o._id FROM orders o JOIN users u ON u._id = o.user_id AND u.age IN (17, 45.5) ORDER BY o._id": {{"_id": int32(12)}},
+				"SELECT o._id FROM orders o JOIN users u ON u._id = o.user_id AND u.address IS NULL ORDER BY o._id":   {{"_id": int32(10)}, {"_id": int32(11)}},
+				"SELECT o._id FROM orders o JOIN users u ON u._id = o._id - 9 ORDER BY o._id":                         {{"_id": int32(10)}, {"_id": int32(11)}, {"_id": int32(12)}},
+			} {
+				So(evaluate(evaluator, buildAggregate(sql)), ShouldResemble, want)
+			}
+		})
+
+		Convey("It should reject columns of tables the statement does not have", func() {
+			_, err := NewStatement("SELECT o._id FROM orders o JOIN users u ON u._id = c.user_id").Build(NewQuery())
+			So(err, ShouldHaveSameTypeAs, &UnsupportedError{})
+		})
+	})
+}
@@ -0,0 +1,158 @@
+package squeel
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+ilikeCollation is the collation rewriteSQL gives ILIKE before parsing, as
+the parser has no ILIKE operator. Like any collation ending in _ci, it makes the
+LIKE it applies to case-insensitive.
+*/
+const ilikeCollation = "squeel_ilike_ci"
+
+/*
+ilikeRegex matches ILIKE and NOT ILIKE as words.
+*/
+var ilikeRegex = regexp.MustCompile(`(?i)^(not\s+)?ilike\b`)
+
+/*
+WithCaseInsensitiveLike sets whether LIKE ignores case. LIKE is case-sensitive
+by default; ILIKE and LIKE on a column with a _ci collation always ignore case.
+
+Parameters:
+- insensitive: Whether LIKE ignores case
+
+Returns:
+- The option to pass to NewStatement
+*/
+func WithCaseInsensitiveLike(insensitive bool) StatementOption {
+	return func(statement *Statement) {
+		statement.likeInsensitive = insensitive
+	}
+}
+
+/*
+likeFilter converts the pattern of a LIKE or NOT LIKE comparison into a
+regular expression condition. The expression is anchored, so the whole value
+has to match, and every character other than the wildcards is quoted.
+
+Parameters:
+- expr: The LIKE comparison
+- pattern: The LIKE pattern
+
+Returns:
+- The condition on the field
+- Whether the pattern could be converted
+*/
+func (statement *Statement) likeFilter(expr *sqlparser.ComparisonExpr, pattern string) (interface{}, bool) {
+	escape := '\\'
+	if expr.Escape != nil {
+		val, ok := expr.Escape.(*sqlparser.SQLVal)
+		if !ok || val.Type != sqlparser.StrVal || utf8.RuneCount(val.Val) > 1 {
+			return nil, false
+		}
+		escape, _ = utf8.DecodeRune(val.Val)
+		if len(val.Val) == 0 {
+			escape = 0
+		}
+	}
+
+	regex, wildcard := likeRegex(pattern, escape)
+
+	options := ""
+	if statement.likeIgnoresCase(expr) {
+		options += "i"
+	}
+	if wildcard {
+		// SQL wildcards match line breaks as well
+		options += "s"
+	}
+
+	if expr.Operator == sqlparser.NotLikeStr {
+		return statement.notMatching(bson.D{{Key: "$not", Value: primitive.Regex{Pattern: regex, Options: options}}}), true
+	}
+
+	if options == "" {
+		return bson.M{"$regex": regex}, true
+	}
+	return bson.D{{Key: "$regex", Value: regex}, {Key: "$options", Value: options}}, true
+}
+
+/*
+likeIgnoresCase reports whether a LIKE comparison ignores case, which is set
+by the collation of its column, if any, and otherwise by the statement.
+*/
+func (statement *Statement) likeIgnoresCase(expr *sqlparser.ComparisonExpr) bool {
+	if collate, ok := expr.Left.(*sqlparser.CollateExpr); ok {
+		return strings.HasSuffix(strings.ToLower(collate.Charset), "_ci")
+	}
+	return statement.likeInsensitive
+}
+
+/*
+likeRegex converts a LIKE pattern into a regular expression. The expression is
+anchored at both ends, except where the pattern starts or ends with %, so
+that a pattern such as 'prefix%' becomes ^prefix, which can use an index.
+
+Parameters:
+- pattern: The LIKE pattern
+- escape: The character that makes the next one literal, or 0 for none
+
+Returns:
+- The regular expression
+- Whether the expression contains a wildcard
+*/
+func likeRegex(pattern string, escape rune) (string, bool) {
+	parts := []string{}
+	escaped := false
+
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			parts = append(parts, regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == escape:
+			escaped = true
+		case r == '%':
+			if len(parts) == 0 || parts[len(parts)-1] != ".*" {
+				parts = append(parts, ".*")
+			}
+		case r == '_':
+			parts = append(parts, ".")
+		default:
+			parts = append(parts, regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	if escaped {
+		parts = append(parts, regexp.QuoteMeta(string(escape)))
+	}
+
+	start, end := "^", "$"
+	if len(parts) > 0 && parts[0] == ".*" {
+		parts, start = parts[1:], ""
+	}
+	if len(parts) > 0 && parts[len(parts)-1] == ".*" {
+		parts, end = parts[:len(parts)-1], ""
+	}
+
+	wildcard := false
+	for _, part := range parts {
+		if part == "." || part == ".*" {
+			wildcard = true
+		}
+	}
+
+	if len(parts) == 0 && (start == "" || end == "") {
+		return "", false
+	}
+
+	return start + strings.Join(parts, "") + end, wildcard
+}
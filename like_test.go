@@ -0,0 +1,80 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLike(t *testing.T) {
+	Convey("Given SQL with LIKE", t, func() {
+		evaluator := newEvaluator(map[string][]interface{}{
+			"files": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: "abc"}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "name", Value: "xabcx"}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "name", Value: "ABC.txt"}},
+				bson.D{{Key: "_id", Value: 4}, {Key: "name", Value: "abc.txt"}},
+				bson.D{{Key: "_id", Value: 5}, {Key: "name", Value: "abcxtxt"}},
+				bson.D{{Key: "_id", Value: 6}, {Key: "name", Value: "50%\noff"}},
+			},
+		})
+
+		Convey("It should match the whole value", func() {
+			q := build("SELECT _id FROM files WHERE name LIKE 'abc'")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "name", Value: bson.M{"$regex": "^abc$"}}})
+			So(ids(evaluator, "SELECT _id FROM files WHERE name LIKE 'abc'"), ShouldResemble, []interface{}{int32(1)})
+		})
+
+		Convey("It should quote regular expression metacharacters", func() {
+			q := build("SELECT _id FROM files WHERE name LIKE 'abc.t_t'")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "name", Value: bson.D{
+				{Key: "$regex", Value: `^abc\.t.t$`},
+				{Key: "$options", Value: "s"},
+			}}})
+			So(ids(evaluator, "SELECT _id FROM files WHERE name LIKE 'abc.t_t'"), ShouldResemble, []interface{}{int32(4)})
+			So(ids(evaluator, "SELECT _id FROM files WHERE name LIKE '(a|x)%'"), ShouldBeEmpty)
+		})
+
+		Convey("It should turn a prefix pattern into an index-friendly regex", func() {
+			q := build("SELECT _id FROM files WHERE name LIKE 'abc%'")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "name", Value: bson.M{"$regex": "^abc"}}})
+			So(ids(evaluator, "SELECT _id FROM files WHERE name LIKE 'abc%'"), ShouldResemble, []interface{}{int32(1), int32(4), int32(5)})
+		})
+
+		Convey("It should honour ESCAPE", func() {
+			q := build(`SELECT _id FROM files WHERE name LIKE '50!%%' ESCAPE '!'`)
+			So(q.Filter, ShouldResemble, bson.D{{Key: "name", Value: bson.M{"$regex": "^50%"}}})
+			So(ids(evaluator, `SELECT _id FROM files WHERE name LIKE '50\\%_off' ESCAPE '\\'`), ShouldResemble, []interface{}{int32(6)})
+			So(ids(evaluator, `SELECT _id FROM files WHERE name LIKE 'abc\\.txt'`), ShouldResemble, []interface{}{int32(4)})
+		})
+
+		Convey("It should escape wildcards with a single backslash, as MySQL does", func() {
+			q := build(`SELECT _id FROM files WHERE name LIKE 'abc\_t%'`)
+			So(q.Filter, ShouldResemble, bson.D{{Key: "name", Value: bson.M{"$regex": "^abc_t"}}})
+			So(ids(evaluator, `SELECT _id FROM files WHERE name LIKE 'abc\_txt'`), ShouldBeEmpty)
+			So(ids(evaluator, `SELECT _id FROM files WHERE name LIKE '50\%\noff' OR name LIKE 'abc\%'`), ShouldResemble, []interface{}{int32(6)})
+			So(rewriteSQL(`SELECT 'a\_b\%', 'a\\_b', "\_"`), ShouldEqual, `SELECT 'a\\_b\\%', 'a\\_b', "\\_"`)
+		})
+
+		Convey("It should be case-sensitive unless configured otherwise", func() {
+			So(ids(evaluator, "SELECT _id FROM files WHERE name LIKE 'abc.%'"), ShouldResemble, []interface{}{int32(4)})
+			So(ids(evaluator, "SELECT _id FROM files WHERE name LIKE 'abc.%'", WithCaseInsensitiveLike(true)), ShouldResemble, []interface{}{int32(3), int32(4)})
+			So(ids(evaluator, "SELECT _id FROM files WHERE name COLLATE utf8mb4_general_ci LIKE 'abc.%'"), ShouldResemble, []interface{}{int32(3), int32(4)})
+			So(ids(evaluator, "SELECT _id FROM files WHERE name COLLATE utf8mb4_bin LIKE 'abc.%'", WithCaseInsensitiveLike(true)), ShouldResemble, []interface{}{int32(4)})
+		})
+
+		Convey("It should match case-insensitively with ILIKE", func() {
+			q := build("SELECT _id FROM files WHERE name ILIKE 'abc.%' AND name NOT ilike '%.TXT'")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "name", Value: bson.D{
+				{Key: "$regex", Value: `^abc\.`},
+				{Key: "$options", Value: "i"},
+				{Key: "$not", Value: primitive.Regex{Pattern: `\.TXT$`, Options: "i"}},
+			}}})
+			So(ids(evaluator, "SELECT _id FROM files WHERE name ILIKE 'abc.%'"), ShouldResemble, []interface{}{int32(3), int32(4)})
+			So(ids(evaluator, "SELECT _id FROM files WHERE name not ILIKE '%.TXT'"), ShouldResemble, []interface{}{int32(1), int32(2), int32(5), int32(6)})
+			So(ids(evaluator, "SELECT _id FROM files WHERE name = 'x ilike y'"), ShouldBeEmpty)
+		})
+	})
+}
@@ -10,8 +10,8 @@ parseLimit processes the LIMIT and OFFSET clauses from a SQL query and applies t
 to the MongoDB query configuration. It handles both the row count (LIMIT) and offset
 values, converting them from SQL value nodes to int64 pointers.
 
-The function has special handling for LIMIT 1 queries, automatically converting them
-to use MongoDB's more efficient findOne operation. If any conversion errors occur,
+The function has special handling for LIMIT 1 queries, automatically converting plain
+find queries to use MongoDB's more efficient findOne operation. If any conversion errors occur,
 they are logged using the errnie error handling system.
 
 Parameters:
@@ -44,7 +44,7 @@ func (statement *Statement) parseLimit(q *Query, node *sqlparser.Limit) *Query {
 		}
 	}
 
-	if *q.Limit == 1 {
+	if q.Limit != nil && *q.Limit == 1 && (q.Operation == "" || q.Operation == "find") {
 		q.Operation = "findone"
 	}
 
@@ -14,13 +14,14 @@ using the sync.Once primitive to ensure thread-safe singleton initialization.
 var (
 	fileLogger *log.Logger
 	once       sync.Once
+	logPath    = "squeel.log" // The file the logger writes to, relative to the working directory
 )
 
 /*
 getLogger returns a singleton logger instance that writes to both a file and stdout.
 The logger is initialized only once using sync.Once to ensure thread safety.
-It creates or opens a log file named "squeel.log" in the current working directory
-with append mode and writes a test message to verify initialization.
+It creates or opens the log file at logPath, "squeel.log" in the current working
+directory by default, in append mode, and writes a test message to verify initialization.
 
 Returns:
 - A configured log.Logger instance that writes to both file and stdout
@@ -30,7 +31,7 @@ func getLogger() *log.Logger {
 		currentDir, _ := os.Getwd()
 		fmt.Printf("Current working directory: %s\n", currentDir)
 
-		file, err := os.OpenFile("squeel.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			log.Fatal(err)
 		}
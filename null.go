@@ -0,0 +1,163 @@
+package squeel
+
+import (
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+NullSemantics selects how NULL and missing fields are treated by negated
+comparisons and IS NULL. MongoDB's $ne and $nin match documents in which the
+field is null or missing, while in SQL any comparison with NULL is unknown,
+so x != 5 never matches a row in which x is NULL.
+*/
+type NullSemantics int
+
+const (
+	// MongoNulls keeps MongoDB's semantics: negated comparisons match null and
+	// missing fields, and IS NULL matches both. This is the default.
+	MongoNulls NullSemantics = iota
+	// SQLNulls excludes null and missing fields from negated comparisons, as
+	// SQL does, and IS NULL matches both.
+	SQLNulls
+	// SQLNullsExplicit is SQLNulls, except that IS NULL only matches fields that
+	// exist with a null value, and IS NOT NULL only fields that exist.
+	SQLNullsExplicit
+)
+
+/*
+WithNullSemantics sets how NULL and missing fields are treated, see
+NullSemantics.
+
+Parameters:
+- semantics: The NULL semantics to translate with
+
+Returns:
+- The option to pass to NewStatement
+*/
+func WithNullSemantics(semantics NullSemantics) StatementOption {
+	return func(statement *Statement) {
+		statement.nulls = semantics
+	}
+}
+
+/*
+parseIs processes an IS condition on a column. IS TRUE and IS FALSE match
+the boolean value; IS NOT TRUE and IS NOT FALSE also match NULL, as in SQL.
+
+Parameters:
+- q: The Query object to modify
+- expr: The IS condition to process
+
+Returns:
+- The modified Query object with the condition applied
+*/
+func (statement *Statement) parseIs(q *Query, expr *sqlparser.IsExpr) *Query {
+	col, ok := expr.Expr.(*sqlparser.ColName)
+	if !ok {
+		statement.unsupported(expr, expr.Operator)
+		return q
+	}
+
+	var condition interface{}
+
+	switch expr.Operator {
+	case sqlparser.IsNullStr:
+		condition = statement.isNull()
+	case sqlparser.IsNotNullStr:
+		condition = statement.isNotNull()
+	case sqlparser.IsTrueStr:
+		condition = true
+	case sqlparser.IsNotTrueStr:
+		condition = bson.M{"$ne": true}
+	case sqlparser.IsFalseStr:
+		condition = false
+	case sqlparser.IsNotFalseStr:
+		condition = bson.M{"$ne": false}
+	default:
+		statement.unsupported(expr, expr.Operator)
+		return q
+	}
+
+	field, ok := statement.filterField(col)
+	if !ok {
+		return q
+	}
+
+	q.Filter = append(q.Filter, bson.E{Key: field, Value: condition})
+	return q
+}
+
+/*
+isNull returns the condition for IS NULL.
+*/
+func (statement *Statement) isNull() interface{} {
+	if statement.nulls == SQLNullsExplicit {
+		return bson.D{{Key: "$exists", Value: true}, {Key: "$eq", Value: nil}}
+	}
+	return nil
+}
+
+/*
+isNotNull returns the condition for IS NOT NULL.
+*/
+func (statement *Statement) isNotNull() interface{} {
+	if statement.nulls == SQLNullsExplicit {
+		return bson.D{{Key: "$exists", Value: true}, {Key: "$ne", Value: nil}}
+	}
+	return bson.M{"$ne": nil}
+}
+
+/*
+notEqual returns the condition for a != comparison, which with SQL semantics
+does not match null or missing fields.
+
+Parameters:
+- value: The value compared with
+
+Returns:
+- The condition on the field
+*/
+func (statement *Statement) notEqual(value interface{}) bson.M {
+	if statement.nulls == MongoNulls || value == nil {
+		return bson.M{"$ne": value}
+	}
+	return bson.M{"$nin": []interface{}{value, nil}}
+}
+
+/*
+notIn returns the condition for a NOT IN comparison, which with SQL semantics
+does not match null or missing fields.
+
+Parameters:
+- values: The values compared with
+
+Returns:
+- The condition on the field
+*/
+func (statement *Statement) notIn(values []interface{}) bson.M {
+	if statement.nulls == MongoNulls {
+		return bson.M{"$nin": values}
+	}
+	return bson.M{"$nin": append(append(make([]interface{}, 0, len(values)+1), values...), nil)}
+}
+
+/*
+notMatching adds the exclusion of null and missing fields to a negated
+condition, such as the $not of NOT LIKE, when SQL semantics are used.
+
+Parameters:
+- condition: The negated condition
+
+Returns:
+- The condition on the field
+*/
+func (statement *Statement) notMatching(condition bson.D) interface{} {
+	if statement.nulls != MongoNulls {
+		condition = append(condition, bson.E{Key: "$ne", Value: nil})
+	}
+	if len(condition) == 1 {
+		return condition.Map()
+	}
+	return condition
+}
@@ -0,0 +1,61 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNullSemantics(t *testing.T) {
+	Convey("Given documents with null and missing fields", t, func() {
+		evaluator := newEvaluator(map[string][]interface{}{
+			"accounts": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "plan", Value: "free"}, {Key: "Deleted", Value: "2024-01-01"}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "plan", Value: "pro"}, {Key: "Deleted", Value: nil}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "plan", Value: nil}},
+				bson.D{{Key: "_id", Value: 4}},
+			},
+		})
+
+		Convey("It should keep MongoDB semantics by default", func() {
+			So(ids(evaluator, "SELECT _id FROM accounts WHERE plan != 'free'"), ShouldResemble, []interface{}{int32(2), int32(3), int32(4)})
+			So(ids(evaluator, "SELECT _id FROM accounts WHERE plan NOT IN ('pro')"), ShouldResemble, []interface{}{int32(1), int32(3), int32(4)})
+			So(ids(evaluator, "SELECT _id FROM accounts WHERE Deleted IS NULL"), ShouldResemble, []interface{}{int32(2), int32(3), int32(4)})
+			So(ids(evaluator, "SELECT _id FROM accounts WHERE Deleted IS NOT NULL"), ShouldResemble, []interface{}{int32(1)})
+		})
+
+		Convey("It should exclude null and missing fields from negated comparisons with SQL semantics", func() {
+			q, err := NewStatement("SELECT * FROM accounts WHERE plan != 'free' AND Deleted NOT IN ('x') AND plan NOT LIKE 'f%'", WithNullSemantics(SQLNulls)).Build(NewQuery())
+			So(err, ShouldBeNil)
+			So(q.Filter, ShouldResemble, bson.D{
+				{Key: "plan", Value: bson.D{
+					{Key: "$nin", Value: []interface{}{"free", nil}},
+					{Key: "$not", Value: primitive.Regex{Pattern: "^f"}},
+					{Key: "$ne", Value: nil},
+				}},
+				{Key: "Deleted", Value: bson.M{"$nin": []interface{}{"x", nil}}},
+			})
+
+			So(ids(evaluator, "SELECT _id FROM accounts WHERE plan != 'free'", WithNullSemantics(SQLNulls)), ShouldResemble, []interface{}{int32(2)})
+			So(ids(evaluator, "SELECT _id FROM accounts WHERE plan NOT IN ('pro')", WithNullSemantics(SQLNulls)), ShouldResemble, []interface{}{int32(1)})
+			So(ids(evaluator, "SELECT _id FROM accounts WHERE plan NOT LIKE 'p%'", WithNullSemantics(SQLNulls)), ShouldResemble, []interface{}{int32(1)})
+			So(ids(evaluator, "SELECT _id FROM accounts WHERE Deleted IS NULL", WithNullSemantics(SQLNulls)), ShouldResemble, []interface{}{int32(2), int32(3), int32(4)})
+		})
+
+		Convey("It should tell null values from missing fields with explicit SQL semantics", func() {
+			q, err := NewStatement("SELECT * FROM accounts WHERE Deleted IS NULL", WithNullSemantics(SQLNullsExplicit)).Build(NewQuery())
+			So(err, ShouldBeNil)
+			So(q.Filter, ShouldResemble, bson.D{{Key: "Deleted", Value: bson.D{{Key: "$exists", Value: true}, {Key: "$eq", Value: nil}}}})
+
+			So(ids(evaluator, "SELECT _id FROM accounts WHERE Deleted IS NULL", WithNullSemantics(SQLNullsExplicit)), ShouldResemble, []interface{}{int32(2)})
+			So(ids(evaluator, "SELECT _id FROM accounts WHERE plan IS NOT NULL", WithNullSemantics(SQLNullsExplicit)), ShouldResemble, []interface{}{int32(1), int32(2)})
+		})
+
+		Convey("It should reject IS on an expression that is not a column", func() {
+			_, err := NewStatement("SELECT * FROM accounts WHERE (a = 1) IS TRUE").Build(NewQuery())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -1,14 +1,16 @@
 package squeel
 
 import (
+	"fmt"
+
 	"github.com/xwb1989/sqlparser"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
 /*
-parseOrderBy converts SQL ORDER BY clauses into MongoDB sort operations.
-For simple queries, it creates a sort document that can be used with find operations.
-For more complex queries requiring aggregation, it adds a $sort stage to the pipeline.
+parseOrderBy converts SQL ORDER BY clauses into a MongoDB sort document.
+The sort document is used directly by find operations; for queries that
+require aggregation it becomes the $sort stage of the assembled pipeline.
 
 Parameters:
 - q: The Query object to modify
@@ -22,20 +24,17 @@ func (statement *Statement) parseOrderBy(q *Query, orderBy sqlparser.OrderBy) *Q
 		return q
 	}
 
-	if q.Operation != "aggregate" {
-		if sortDoc := buildSimpleSort(orderBy); len(sortDoc) > 0 {
-			q.Sort = sortDoc
-			return q
-		}
+	if sortDoc := statement.buildSimpleSort(orderBy); len(sortDoc) > 0 {
+		q.Sort = sortDoc
 	}
 
-	return statement.buildAggregatePipelineSort(q, orderBy)
+	return q
 }
 
 /*
 buildSimpleSort creates a MongoDB sort document from SQL ORDER BY clauses.
-It handles basic sorting cases where each clause is a simple column reference
-with an optional ASC/DESC direction.
+Each clause is a column reference or a computed expression, with an
+optional ASC/DESC direction.
 
 Parameters:
 - orderBy: The SQL ORDER BY clauses to convert
@@ -43,65 +42,57 @@ Parameters:
 Returns:
 - A bson.D document containing MongoDB sort specifications
 */
-func buildSimpleSort(orderBy sqlparser.OrderBy) bson.D {
+func (statement *Statement) buildSimpleSort(orderBy sqlparser.OrderBy) bson.D {
 	sortDoc := make(bson.D, 0, len(orderBy))
 	for _, order := range orderBy {
-		if colName, ok := order.Expr.(*sqlparser.ColName); ok {
-			direction := 1
-			if order.Direction == sqlparser.DescScr {
-				direction = -1
-			}
-			sortDoc = append(sortDoc, bson.E{
-				Key:   colName.Name.CompliantName(),
-				Value: direction,
-			})
+		key, ok := statement.sortKey(order.Expr)
+		if !ok {
+			statement.unsupported(order.Expr, "")
+			continue
+		}
+
+		direction := 1
+		if order.Direction == sqlparser.DescScr {
+			direction = -1
 		}
+		sortDoc = append(sortDoc, bson.E{
+			Key:   key,
+			Value: direction,
+		})
 	}
 	return sortDoc
 }
 
 /*
-buildAggregatePipelineSort adds a $sort stage to an aggregation pipeline based on
-SQL ORDER BY clauses. This is used when the query requires aggregation operations
-or when dealing with complex sorting scenarios.
+sortKey returns the field an ORDER BY expression sorts on. A column sorts on
+its own field. A computed expression, or a column without a dotted path,
+is computed into a temporary field before the SELECT list is projected, and
+that field is removed again once the rows are sorted.
 
 Parameters:
-- q: The Query object to modify
-- orderBy: The SQL ORDER BY clauses to process
+- expr: The ORDER BY expression
 
 Returns:
-- The modified Query object with a $sort stage added to its pipeline
+- The field to sort on
+- Whether the expression can be sorted on
 */
-func (statement *Statement) buildAggregatePipelineSort(q *Query, orderBy sqlparser.OrderBy) *Query {
-	sortStage := buildSortStage(orderBy)
-	if len(sortStage) > 0 {
-		q.Operation = "aggregate"
-		q.Pipeline = append(q.Pipeline, bson.D{{Key: "$sort", Value: sortStage}})
+func (statement *Statement) sortKey(expr sqlparser.Expr) (string, bool) {
+	col, isCol := expr.(*sqlparser.ColName)
+	if isCol && statement.plainColumn(col) {
+		return statement.fieldPath(col), true
 	}
-	return q
-}
 
-/*
-buildSortStage creates a MongoDB sort stage document from SQL ORDER BY clauses.
-It converts each ORDER BY clause into a field-direction pair in the format
-expected by MongoDB's $sort operator.
-
-Parameters:
-- orderBy: The SQL ORDER BY clauses to convert
+	if !isComputed(expr) && !isCol || !statement.pipeline.group.empty() {
+		return "", false
+	}
 
-Returns:
-- A bson.M document containing the sort stage configuration
-*/
-func buildSortStage(orderBy sqlparser.OrderBy) bson.M {
-	sortStage := bson.M{}
-	for _, order := range orderBy {
-		if colName, ok := order.Expr.(*sqlparser.ColName); ok {
-			direction := 1
-			if order.Direction == sqlparser.DescScr {
-				direction = -1
-			}
-			sortStage[colName.Name.CompliantName()] = direction
-		}
+	value, ok := statement.expression(expr)
+	if !ok {
+		return "", false
 	}
-	return sortStage
+
+	key := fmt.Sprintf("__sort_%d", len(statement.pipeline.sortKeys)+1)
+	statement.pipeline.sortKeys = append(statement.pipeline.sortKeys, key)
+	statement.pipeline.add(clauseSelect, bson.D{{Key: "$addFields", Value: bson.D{{Key: key, Value: value}}}})
+	return key, true
 }
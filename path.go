@@ -0,0 +1,235 @@
+package squeel
+
+import (
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+pathSeparator separates the segments of a field path that rewriteSQL folds
+into a single quoted identifier, for paths with more segments than the
+parser allows, as in address.geo.location.lat.
+*/
+const pathSeparator = "\x1f"
+
+/*
+columnPath is a column resolved against the tables of a statement: the table
+it is qualified with, if any, and the path of the field within that table's
+documents.
+*/
+type columnPath struct {
+	table    string   // The name of the table the column is qualified with, or empty
+	segments []string // The path of the field within the documents of the table
+}
+
+/*
+columnSegments returns every segment of a column reference, from the
+outermost qualifier to the column name. The parser reads a.b.c as
+database.table.column, but here all three may be segments of a field path.
+
+Parameters:
+- col: The column
+
+Returns:
+- The segments of the column reference
+*/
+func columnSegments(col *sqlparser.ColName) []string {
+	segments := make([]string, 0, 3)
+	if qualifier := col.Qualifier.Qualifier.String(); qualifier != "" {
+		segments = append(segments, qualifier)
+	}
+	if qualifier := col.Qualifier.Name.String(); qualifier != "" {
+		segments = append(segments, qualifier)
+	}
+	return append(segments, strings.Split(col.Name.String(), pathSeparator)...)
+}
+
+/*
+columnQualifier returns the first segment of a column reference with more
+than one segment, which is the table the column belongs to when it names
+one, and otherwise the first embedded document of its path.
+
+Parameters:
+- col: The column
+
+Returns:
+- The first segment, or an empty string for a single-segment column
+*/
+func columnQualifier(col *sqlparser.ColName) string {
+	if segments := columnSegments(col); len(segments) > 1 {
+		return segments[0]
+	}
+	return ""
+}
+
+/*
+resolveColumn tells a table qualifier apart from an embedded document path.
+The first segment of a column is a table when it names a table of the
+statement, as in u.address.city; otherwise every segment is part of the
+path, as in address.geo.lat.
+
+Parameters:
+- col: The column to resolve
+
+Returns:
+- The resolved column
+*/
+func (statement *Statement) resolveColumn(col *sqlparser.ColName) columnPath {
+	segments := columnSegments(col)
+	if len(segments) > 1 && statement.tables.has(segments[0]) {
+		return columnPath{table: segments[0], segments: segments[1:]}
+	}
+	return columnPath{segments: segments}
+}
+
+/*
+documentSegments returns the path of a column within the documents the query
+runs on. Columns of the root table are top-level fields, and columns of a
+joined table are nested under the table's name. While the conditions of a
+$lookup pipeline are compiled, columns of the looked up table are read from
+the looked up documents.
+
+Parameters:
+- col: The column
+
+Returns:
+- The segments of the path
+*/
+func (statement *Statement) documentSegments(col *sqlparser.ColName) []string {
+	if statement.scope != nil && statement.scope.foreign(col) {
+		return statement.scope.path(col)
+	}
+
+	resolved := statement.resolveColumn(col)
+	if resolved.table == "" || resolved.table == statement.tables.root {
+		return resolved.segments
+	}
+	return append([]string{resolved.table}, resolved.segments...)
+}
+
+/*
+fieldPath resolves a column to the dotted path of the document field it
+refers to.
+
+Parameters:
+- col: The column to resolve
+
+Returns:
+- The dotted path of the field
+*/
+func (statement *Statement) fieldPath(col *sqlparser.ColName) string {
+	return strings.Join(statement.documentSegments(col), ".")
+}
+
+/*
+plainColumn reports whether a column can be addressed with a dotted path.
+A quoted segment that contains a dot or starts with a dollar sign cannot,
+and is read with $getField instead. Neither can a column of an earlier table
+in the conditions of a $lookup pipeline, which is a let variable.
+
+Parameters:
+- col: The column
+
+Returns:
+- Whether the column has a dotted path
+*/
+func (statement *Statement) plainColumn(col *sqlparser.ColName) bool {
+	if statement.scope != nil && !statement.scope.foreign(col) {
+		return false
+	}
+	return plainSegments(statement.documentSegments(col))
+}
+
+/*
+fieldExpression returns the aggregation expression that reads a column: a
+field path such as "$address.city", or a chain of $getField for the
+segments that a path cannot address. In the conditions of a $lookup
+pipeline, a column of an earlier table reads the let variable bound to it.
+
+Parameters:
+- col: The column
+
+Returns:
+- The aggregation expression
+*/
+func (statement *Statement) fieldExpression(col *sqlparser.ColName) interface{} {
+	if statement.scope != nil && !statement.scope.foreign(col) {
+		return "$$" + statement.scope.variable(col)
+	}
+	return segmentsExpression(statement.documentSegments(col))
+}
+
+/*
+filterField returns the dotted path of a column for use as the key of a
+filter, reporting the column as unsupported when it has no dotted path.
+
+Parameters:
+- col: The column
+
+Returns:
+- The dotted path of the field
+- Whether the column can be filtered on with a field condition
+*/
+func (statement *Statement) filterField(col *sqlparser.ColName) (string, bool) {
+	if !statement.plainColumn(col) {
+		statement.unsupported(col, "")
+		return "", false
+	}
+	return statement.fieldPath(col), true
+}
+
+/*
+segmentsExpression builds the aggregation expression that reads a field
+path. The leading segments that a dotted path can address form a field
+path, and every segment after them is read with $getField.
+
+Parameters:
+- segments: The segments of the path
+
+Returns:
+- The aggregation expression
+*/
+func segmentsExpression(segments []string) interface{} {
+	plain := 0
+	for plain < len(segments) && plainSegment(segments[plain]) {
+		plain++
+	}
+
+	var expr interface{} = "$$CURRENT"
+	if plain > 0 {
+		expr = "$" + strings.Join(segments[:plain], ".")
+	}
+
+	for _, segment := range segments[plain:] {
+		var field interface{} = segment
+		if strings.HasPrefix(segment, "$") {
+			field = bson.M{"$literal": segment}
+		}
+		expr = bson.M{"$getField": bson.D{{Key: "field", Value: field}, {Key: "input", Value: expr}}}
+	}
+
+	return expr
+}
+
+/*
+plainSegments reports whether every segment of a path can be part of a
+dotted path.
+*/
+func plainSegments(segments []string) bool {
+	for _, segment := range segments {
+		if !plainSegment(segment) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+plainSegment reports whether a segment can be part of a dotted path: it is
+not empty, holds no dot and does not start with a dollar sign.
+*/
+func plainSegment(segment string) bool {
+	return segment != "" && !strings.Contains(segment, ".") && !strings.HasPrefix(segment, "$")
+}
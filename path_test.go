@@ -0,0 +1,134 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFieldPaths(t *testing.T) {
+	Convey("Given SQL with nested field paths", t, func() {
+		evaluator := newEvaluator(map[string][]interface{}{
+			"people": {
+				bson.D{
+					{Key: "_id", Value: 1},
+					{Key: "name", Value: "ann"},
+					{Key: "address", Value: bson.D{
+						{Key: "city", Value: "Amsterdam"},
+						{Key: "geo", Value: bson.D{{Key: "lat", Value: 52}, {Key: "location", Value: bson.D{{Key: "lng", Value: 4}}}}},
+					}},
+					{Key: "stats.v2", Value: bson.D{{Key: "$count", Value: 3}}},
+				},
+				bson.D{
+					{Key: "_id", Value: 2},
+					{Key: "name", Value: "bob"},
+					{Key: "address", Value: bson.D{
+						{Key: "city", Value: "Madrid"},
+						{Key: "geo", Value: bson.D{{Key: "lat", Value: 40}, {Key: "location", Value: bson.D{{Key: "lng", Value: -3}}}}},
+					}},
+					{Key: "stats.v2", Value: bson.D{{Key: "$count", Value: 1}}},
+				},
+				bson.D{
+					{Key: "_id", Value: 3},
+					{Key: "name", Value: "cid"},
+					{Key: "address", Value: bson.D{
+						{Key: "city", Value: "Amsterdam"},
+						{Key: "geo", Value: bson.D{{Key: "lat", Value: 53}, {Key: "location", Value: bson.D{{Key: "lng", Value: 5}}}}},
+					}},
+					{Key: "stats.v2", Value: bson.D{{Key: "$count", Value: 7}}},
+				},
+			},
+			"cities": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "info", Value: bson.D{{Key: "name", Value: "Amsterdam"}, {Key: "country", Value: "NL"}}}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "info", Value: bson.D{{Key: "name", Value: "Madrid"}, {Key: "country", Value: "ES"}}}},
+			},
+		})
+
+		Convey("It should read three-level paths as embedded documents", func() {
+			q := build("SELECT name FROM people WHERE address.geo.lat > 50")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "address.geo.lat", Value: bson.M{"$gt": int64(50)}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1), "name": "ann"}, {"_id": int32(3), "name": "cid"}})
+		})
+
+		Convey("It should tell a table alias apart from an embedded document", func() {
+			q := build("SELECT p.address.city FROM people p WHERE p.address.geo.lat < 50")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "address.geo.lat", Value: bson.M{"$lt": int64(50)}}})
+			So(q.Projection, ShouldResemble, bson.D{{Key: "address.city", Value: 1}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2), "address": bson.D{{Key: "city", Value: "Madrid"}}}})
+		})
+
+		Convey("It should resolve paths with more segments than the parser reads", func() {
+			q := build("SELECT name FROM people p WHERE p.address.geo.location.lng > 0 ORDER BY address.geo.location.lng DESC")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "address.geo.location.lng", Value: bson.M{"$gt": int64(0)}}})
+			So(q.Sort, ShouldResemble, bson.D{{Key: "address.geo.location.lng", Value: -1}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(3), "name": "cid"}, {"_id": int32(1), "name": "ann"}})
+		})
+
+		Convey("It should read quoted segments with dots or dollar signs with $getField", func() {
+			count := bson.M{"$getField": bson.D{
+				{Key: "field", Value: bson.M{"$literal": "$count"}},
+				{Key: "input", Value: bson.M{"$getField": bson.D{{Key: "field", Value: "stats.v2"}, {Key: "input", Value: "$$CURRENT"}}}},
+			}}
+
+			q := build("SELECT name, `stats.v2`.`$count` AS n FROM people WHERE `stats.v2`.`$count` > 2 ORDER BY `stats.v2`.`$count`")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "$expr", Value: bson.M{"$gt": bson.A{count, int64(2)}}}})
+			So(q.Projection, ShouldResemble, bson.D{{Key: "name", Value: 1}, {Key: "n", Value: count}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(1), "name": "ann", "n": int32(3)},
+				{"_id": int32(3), "name": "cid", "n": int32(7)},
+			})
+		})
+
+		Convey("It should group on nested and quoted paths", func() {
+			q := build("SELECT address.city, COUNT(*) AS n FROM people GROUP BY address.city HAVING COUNT(*) > 1")
+			So(q.Pipeline[0], ShouldResemble, bson.D{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: bson.D{{Key: "address_city", Value: "$address.city"}}},
+				{Key: "n", Value: bson.M{"$sum": 1}},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"address": bson.D{{Key: "city", Value: "Amsterdam"}}, "n": int32(2)}})
+
+			q = build("SELECT `stats.v2`.`$count` AS c, SUM(address.geo.lat) AS lat FROM people GROUP BY `stats.v2`.`$count` ORDER BY lat")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"c": int32(1), "lat": int32(40)},
+				{"c": int32(3), "lat": int32(52)},
+				{"c": int32(7), "lat": int32(53)},
+			})
+		})
+
+		Convey("It should join on nested paths of both tables", func() {
+			q := build("SELECT p.name, c.info.country FROM people p JOIN cities c ON p.address.city = c.info.name WHERE c.info.country = 'ES'")
+			So(q.Pipeline[0], ShouldResemble, bson.D{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "cities"},
+				{Key: "localField", Value: "address.city"},
+				{Key: "foreignField", Value: "info.name"},
+				{Key: "as", Value: "c"},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2), "name": "bob", "c": bson.D{{Key: "info", Value: bson.D{{Key: "country", Value: "ES"}}}}}})
+		})
+
+		Convey("It should reject quoted segments where a dotted path is required", func() {
+			for _, sql := range []string{
+				"SELECT name FROM people WHERE `stats.v2`.`$count` IS NULL",
+				"SELECT `stats.v2`.`$count` FROM people",
+			} {
+				_, err := NewStatement(sql).Build(NewQuery())
+				So(err, ShouldHaveSameTypeAs, &UnsupportedError{})
+			}
+		})
+	})
+}
+
+func TestRewriteFieldPaths(t *testing.T) {
+	Convey("Given field paths the parser cannot read", t, func() {
+		Convey("It should fold them into a single quoted column", func() {
+			So(rewriteSQL("SELECT a.b.c.d FROM t WHERE x.0.y = 'a.b.c.d'"), ShouldEqual,
+				"SELECT a.`b\x1fc\x1fd` FROM t WHERE x.`0\x1fy` = 'a.b.c.d'")
+			So(rewriteSQL("SELECT `a`.`b.c`.d.`e``f` FROM t"), ShouldEqual, "SELECT `a`.`b.c\x1fd\x1fe``f` FROM t")
+		})
+
+		Convey("It should leave numbers and shorter paths alone", func() {
+			So(rewriteSQL("SELECT a.b.c FROM t WHERE x > 1.5"), ShouldEqual, "SELECT a.b.c FROM t WHERE x > 1.5")
+		})
+	})
+}
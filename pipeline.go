@@ -0,0 +1,322 @@
+package squeel
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+clause identifies the SQL clause an aggregation stage was produced by. The
+order of the constants is the logical order in which SQL evaluates a SELECT,
+which is the order the stages are assembled in.
+*/
+type clause int
+
+const (
+	clauseFrom     clause = iota // $lookup and $unwind stages from FROM and JOIN
+	clauseWhere                  // $match stage from WHERE
+	clauseGroup                  // $group stages from GROUP BY and aggregates
+	clauseHaving                 // $match stage from HAVING
+	clauseSelect                 // $project stage from the SELECT list
+	clauseDistinct               // $group stage from SELECT DISTINCT
+	clauseOrder                  // $sort stage from ORDER BY
+	clauseOffset                 // $skip stage from OFFSET
+	clauseLimit                  // $limit stage from LIMIT
+	clauseCount
+)
+
+/*
+pipeline collects aggregation stages per SQL clause while the AST is walked.
+Because sqlparser.Walk visits nodes in an order unrelated to how SQL evaluates
+them, stages are only put in order once the whole statement has been seen.
+*/
+type pipeline struct {
+	stages     [clauseCount]mongo.Pipeline // The stages produced by each clause
+	group      *grouping                   // The keys and accumulators of the $group stage
+	subqueries int                         // The number of subqueries looked up so far
+	temporary  []string                    // The fields holding looked up subqueries, removed after WHERE
+	sortKeys   []string                    // The fields computed for ORDER BY expressions, removed after sorting
+}
+
+/*
+newPipeline creates an empty pipeline with a slot for every clause.
+
+Returns:
+- A new pipeline instance
+*/
+func newPipeline() *pipeline {
+	return &pipeline{group: newGrouping()}
+}
+
+/*
+add appends stages to the slot of the clause that produced them.
+
+Parameters:
+- c: The clause the stages belong to
+- stages: The stages to append
+*/
+func (pipeline *pipeline) add(c clause, stages ...bson.D) {
+	pipeline.stages[c] = append(pipeline.stages[c], stages...)
+}
+
+/*
+assemblePipeline fills the slots that are derived from the finished Query,
+and returns every stage in SQL clause order: FROM/JOIN, WHERE, GROUP BY,
+HAVING, SELECT, DISTINCT, ORDER BY, OFFSET and LIMIT. The fields that
+subquery lookups stored their results in are removed after WHERE.
+
+Parameters:
+- q: The Query built from the statement
+- node: The SELECT statement the Query was built from
+
+Returns:
+- The assembled aggregation pipeline
+*/
+func (statement *Statement) assemblePipeline(q *Query, node *sqlparser.Select) mongo.Pipeline {
+	stages := statement.pipeline
+
+	if len(q.Filter) > 0 {
+		stages.add(clauseWhere, bson.D{{Key: "$match", Value: q.Filter}})
+	}
+
+	if len(stages.temporary) > 0 {
+		stages.add(clauseWhere, bson.D{{Key: "$unset", Value: stages.temporary}})
+	}
+
+	project := statement.projectStage(q)
+	selected := project
+	sortOnly := sortOnlyFields(project, q.Sort)
+
+	if len(project) > 0 {
+		for _, field := range sortOnly {
+			project = append(project, statement.projectField(field))
+		}
+		stages.add(clauseSelect, bson.D{{Key: "$project", Value: project}})
+	}
+
+	stages.add(clauseGroup, stages.group.stages()...)
+
+	if node.Distinct != "" {
+		stages.add(clauseDistinct, distinctStages(selected)...)
+	}
+
+	if len(q.Sort) > 0 {
+		stages.add(clauseOrder, bson.D{{Key: "$sort", Value: q.Sort}})
+	}
+
+	if len(project) > 0 && len(sortOnly) > 0 {
+		stages.add(clauseOrder, bson.D{{Key: "$unset", Value: unsetFields(selected, sortOnly)}})
+	} else if len(project) == 0 && len(stages.sortKeys) > 0 {
+		stages.add(clauseOrder, bson.D{{Key: "$unset", Value: stages.sortKeys}})
+	}
+
+	if q.Offset != nil {
+		stages.add(clauseOffset, bson.D{{Key: "$skip", Value: *q.Offset}})
+	}
+
+	if q.Limit != nil {
+		stages.add(clauseLimit, bson.D{{Key: "$limit", Value: *q.Limit}})
+	}
+
+	assembled := make(mongo.Pipeline, 0)
+	for _, slot := range stages.stages {
+		assembled = append(assembled, slot...)
+	}
+
+	return assembled
+}
+
+/*
+projectStage builds the $project specification for the SELECT list. When the
+query is grouped, the _id that the $group stage produces is dropped unless
+it was selected, and renamed columns read the grouped value of the column
+they rename.
+
+Parameters:
+- q: The Query built from the statement
+
+Returns:
+- The projection specification, or nil when every field is selected
+*/
+func (statement *Statement) projectStage(q *Query) bson.D {
+	if q.Projection == nil {
+		return nil
+	}
+
+	group := statement.pipeline.group
+	if group.empty() {
+		return append(bson.D{}, q.Projection...)
+	}
+
+	project := make(bson.D, 0, len(q.Projection)+1)
+	for _, field := range q.Projection {
+		if path, ok := field.Value.(string); ok && strings.HasPrefix(path, "$") {
+			// A renamed column reads the grouped value of its source field
+			source := statement.projectField(path[1:])
+			if source.Value == 1 {
+				source.Value = path
+			}
+			project = append(project, bson.E{Key: field.Key, Value: source.Value})
+			continue
+		}
+		if path, ok := statement.pipeline.group.keyExpression(field.Value); ok {
+			project = append(project, bson.E{Key: field.Key, Value: "$" + path})
+			continue
+		}
+		if field.Value != 1 {
+			project = append(project, field)
+			continue
+		}
+		project = append(project, statement.projectField(field.Key))
+	}
+
+	if !hasKey(project, "_id") {
+		project = append(project, bson.E{Key: "_id", Value: 0})
+	}
+
+	return project
+}
+
+/*
+projectField builds the projection of a single column. After grouping, GROUP
+BY columns are lifted out of the _id document back to their column names,
+aggregates are read from their accumulators, and any other column is carried
+through the group with $first, as MySQL does for columns it does not group on.
+
+Parameters:
+- field: The name of the column
+
+Returns:
+- The projection of the column
+*/
+func (statement *Statement) projectField(field string) bson.E {
+	group := statement.pipeline.group
+
+	switch {
+	case group.empty() || field == "_id" || hasKey(group.accumulators, field):
+		return bson.E{Key: field, Value: 1}
+	case group.isKey(field):
+		return bson.E{Key: field, Value: "$" + group.keyField(field)}
+	}
+
+	group.accumulate("", field, bson.M{"$first": "$" + field}, false)
+	return bson.E{Key: field, Value: 1}
+}
+
+/*
+sortOnlyFields returns the ORDER BY fields that the SELECT list does not
+include. They are kept through the $project stage so that the $sort stage
+can still see them, and removed once the rows are sorted.
+
+Parameters:
+- project: The $project specification of the SELECT list
+- sort: The sort specification of the ORDER BY clause
+
+Returns:
+- The names of the fields that are only needed for sorting
+*/
+func sortOnlyFields(project bson.D, sort bson.D) []string {
+	fields := make([]string, 0)
+	if len(project) == 0 {
+		return fields
+	}
+
+	for _, key := range sort {
+		if !projects(project, key.Key) {
+			fields = append(fields, key.Key)
+		}
+	}
+
+	return fields
+}
+
+/*
+projects reports whether a $project specification keeps a field, either
+directly or through one of its parent documents. The _id is kept unless it
+is excluded explicitly.
+*/
+func projects(project bson.D, field string) bool {
+	for _, element := range project {
+		if element.Key == field || strings.HasPrefix(field, element.Key+".") {
+			return element.Value != 0
+		}
+	}
+	return field == "_id" || strings.HasPrefix(field, "_id.")
+}
+
+/*
+unsetFields returns the fields to remove once the rows are sorted. A nested
+sort field is removed with its outermost document when the SELECT list
+reads nothing else from it, so no empty documents are left behind.
+
+Parameters:
+- project: The $project specification of the SELECT list
+- sortOnly: The fields that are only needed for sorting
+
+Returns:
+- The fields to remove
+*/
+func unsetFields(project bson.D, sortOnly []string) []string {
+	fields := make([]string, 0, len(sortOnly))
+	for _, field := range sortOnly {
+		top, _, _ := strings.Cut(field, ".")
+		for _, element := range project {
+			if element.Key == top || strings.HasPrefix(element.Key, top+".") {
+				top = field
+				break
+			}
+		}
+
+		if !slices.Contains(fields, top) {
+			fields = append(fields, top)
+		}
+	}
+	return fields
+}
+
+/*
+distinctStages builds the stages for SELECT DISTINCT inside a pipeline. The
+rows are grouped on every projected field, and the group keys are promoted
+back to the top level of the output documents.
+
+Parameters:
+- projection: The $project specification of the SELECT list, or nil for SELECT *
+
+Returns:
+- The $group and $replaceRoot stages
+*/
+func distinctStages(projection bson.D) []bson.D {
+	var id interface{} = "$$ROOT"
+
+	if len(projection) > 0 {
+		keys := bson.D{}
+		for _, field := range projection {
+			if field.Value == 0 {
+				continue
+			}
+			keys = append(keys, bson.E{Key: field.Key, Value: "$" + field.Key})
+		}
+		id = keys
+	}
+
+	return []bson.D{
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: id}}}},
+		{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$_id"}}}},
+	}
+}
+
+/*
+hasKey reports whether a document contains the given top-level key.
+*/
+func hasKey(document bson.D, key string) bool {
+	for _, element := range document {
+		if element.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+stageNames returns the operator of every stage in a pipeline, in order.
+*/
+func stageNames(pipeline mongo.Pipeline) []string {
+	names := make([]string, 0, len(pipeline))
+	for _, stage := range pipeline {
+		names = append(names, stage[0].Key)
+	}
+	return names
+}
+
+func TestPipeline(t *testing.T) {
+	Convey("Given SQL that needs an aggregation pipeline", t, func() {
+		evaluator := newEvaluator(evaluatorFixtures)
+
+		Convey("It should emit WHERE, SELECT, ORDER BY, OFFSET and LIMIT in order", func() {
+			q := buildAggregate("SELECT name, age FROM users WHERE name != 'bob' ORDER BY age DESC LIMIT 1 OFFSET 1")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$match", "$project", "$sort", "$skip", "$limit"})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1), "name": "ann", "age": int32(31)}})
+		})
+
+		Convey("It should group after matching and project after grouping", func() {
+			q := buildAggregate("SELECT name FROM users WHERE name != 'bob' GROUP BY name ORDER BY name DESC")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$match", "$group", "$project", "$sort"})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"name": "cid"}, {"name": "ann"}})
+		})
+
+		Convey("It should sort on columns that are not selected", func() {
+			q := buildAggregate("SELECT name FROM users ORDER BY age DESC")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$project", "$sort", "$unset"})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(3), "name": "cid"},
+				{"_id": int32(1), "name": "ann"},
+				{"_id": int32(2), "name": "bob"},
+			})
+		})
+
+		Convey("It should filter groups with HAVING after grouping only", func() {
+			q := buildAggregate("SELECT name FROM users GROUP BY name HAVING name != 'bob' ORDER BY name")
+			So(q.Filter, ShouldBeEmpty)
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$group", "$match", "$project", "$sort"})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"name": "ann"}, {"name": "cid"}})
+		})
+
+		Convey("It should apply DISTINCT before ORDER BY", func() {
+			q := buildAggregate("SELECT DISTINCT user_id FROM orders ORDER BY user_id DESC")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$project", "$group", "$replaceRoot", "$sort"})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"user_id": int32(3)}, {"user_id": int32(1)}})
+		})
+
+		Convey("It should page DISTINCT values with LIMIT and OFFSET", func() {
+			q := buildAggregate("SELECT DISTINCT user_id FROM orders ORDER BY user_id LIMIT 1 OFFSET 1")
+			So(q.Operation, ShouldEqual, "aggregate")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$project", "$group", "$replaceRoot", "$sort", "$skip", "$limit"})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"user_id": int32(3)}})
+
+			q = buildAggregate("SELECT DISTINCT user_id FROM orders LIMIT 1")
+			So(q.Operation, ShouldEqual, "aggregate")
+			So(evaluate(evaluator, q), ShouldHaveLength, 1)
+		})
+
+		Convey("It should put JOIN stages before GROUP BY", func() {
+			q := buildAggregate("SELECT u.name FROM users u JOIN orders o ON u._id = o.user_id GROUP BY u.name")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$lookup", "$unwind", "$group", "$project"})
+		})
+	})
+
+	Convey("Given SQL with aggregate functions", t, func() {
+		evaluator := newEvaluator(evaluatorFixtures)
+
+		Convey("It should compute every aggregate in a single $group", func() {
+			q := buildAggregate("SELECT user_id, SUM(total) AS spent, COUNT(*) AS n, AVG(total) FROM orders GROUP BY user_id ORDER BY user_id")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$group", "$project", "$sort"})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"user_id": int32(1), "spent": int32(25), "n": int32(2), "avg_total": 12.5},
+				{"user_id": int32(3), "spent": int32(7), "n": int32(1), "avg_total": 7.0},
+			})
+		})
+
+		Convey("It should filter on aggregates in HAVING", func() {
+			q := buildAggregate("SELECT user_id, SUM(total) AS spent FROM orders GROUP BY user_id HAVING spent > 10")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"user_id": int32(1), "spent": int32(25)}})
+
+			q = buildAggregate("SELECT user_id FROM orders GROUP BY user_id HAVING COUNT(*) > 1")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$group", "$match", "$project"})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"user_id": int32(1)}})
+		})
+
+		Convey("It should order on a GROUP BY key that is not selected", func() {
+			q := buildAggregate("SELECT SUM(total) AS spent FROM orders GROUP BY user_id ORDER BY user_id DESC")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"spent": int32(7)}, {"spent": int32(25)}})
+		})
+
+		Convey("It should aggregate without GROUP BY", func() {
+			q := buildAggregate("SELECT COUNT(*) AS total, MAX(total) AS largest FROM orders")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$group", "$project"})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"total": int32(3), "largest": int32(20)}})
+		})
+
+		Convey("It should count distinct values", func() {
+			q := buildAggregate("SELECT COUNT(DISTINCT user_id) AS n FROM orders")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$group", "$set", "$project"})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"n": int32(2)}})
+		})
+
+		Convey("It should count non-null values of a column", func() {
+			q := buildAggregate("SELECT COUNT(address) AS n FROM users")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"n": int32(1)}})
+		})
+
+		Convey("It should use a count operation for an unaliased COUNT(*)", func() {
+			q, err := NewStatement("SELECT COUNT(*) FROM users").Build(NewQuery())
+			So(err, ShouldBeNil)
+			So(q.Operation, ShouldEqual, "count")
+		})
+	})
+}
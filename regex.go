@@ -0,0 +1,131 @@
+package squeel
+
+import (
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+regexFilter converts the pattern of a REGEXP, RLIKE or NOT REGEXP comparison
+into a $regex condition, or a $not of the expression for NOT REGEXP. The
+pattern is passed on as it is, so like in MySQL it matches anywhere in the
+value unless it is anchored.
+
+Parameters:
+- operator: The REGEXP or NOT REGEXP operator
+- pattern: The regular expression
+- options: The regular expression options
+
+Returns:
+- The condition on the field
+*/
+func (statement *Statement) regexFilter(operator, pattern, options string) interface{} {
+	if operator == sqlparser.NotRegexpStr {
+		return statement.notMatching(bson.D{{Key: "$not", Value: primitive.Regex{Pattern: pattern, Options: options}}})
+	}
+
+	if options == "" {
+		return bson.M{"$regex": pattern}
+	}
+	return bson.D{{Key: "$regex", Value: pattern}, {Key: "$options", Value: options}}
+}
+
+/*
+handleRegexpLike processes the REGEXP_LIKE(column, pattern[, match_type])
+function, converting it into a $regex condition. The MySQL match types c, i,
+m and n become the MongoDB options for case-sensitivity, case-insensitivity,
+multiline and dotall.
+
+Parameters:
+- q: The Query object to modify
+- expr: The REGEXP_LIKE function expression
+
+Returns:
+- The modified Query object with the regex filter applied
+*/
+func (statement *Statement) handleRegexpLike(q *Query, expr *sqlparser.FuncExpr) *Query {
+	if len(expr.Exprs) < 2 || len(expr.Exprs) > 3 {
+		statement.unsupported(expr, expr.Name.String())
+		return q
+	}
+
+	col := statement.getColumnFromAliasedExpr(expr.Exprs[0])
+	pattern, isPattern := sqlVal(expr.Exprs[1])
+	if col == nil || !isPattern || pattern.Type != sqlparser.StrVal {
+		statement.unsupported(expr, expr.Name.String())
+		return q
+	}
+
+	options := ""
+	if len(expr.Exprs) == 3 {
+		matchType, ok := sqlVal(expr.Exprs[2])
+		if !ok || matchType.Type != sqlparser.StrVal {
+			statement.unsupported(expr, expr.Name.String())
+			return q
+		}
+
+		if options, ok = regexOptions(string(matchType.Val)); !ok {
+			statement.unsupported(expr, expr.Name.String())
+			return q
+		}
+	}
+
+	field, ok := statement.filterField(col)
+	if !ok {
+		return q
+	}
+
+	q.Filter = append(q.Filter, bson.E{
+		Key:   field,
+		Value: statement.regexFilter(sqlparser.RegexpStr, string(pattern.Val), options),
+	})
+	return q
+}
+
+/*
+regexOptions converts a MySQL match type into MongoDB regex options. When
+the match type holds both c and i, the last one wins, as in MySQL.
+
+Parameters:
+- matchType: The MySQL match type, such as "in"
+
+Returns:
+- The MongoDB options
+- Whether every character of the match type is known
+*/
+func regexOptions(matchType string) (string, bool) {
+	insensitive, multiline, dotall := false, false, false
+
+	for _, c := range matchType {
+		switch c {
+		case 'c':
+			insensitive = false
+		case 'i':
+			insensitive = true
+		case 'm':
+			multiline = true
+		case 'n':
+			dotall = true
+		case 'u':
+			// Unix line endings are the only ones MongoDB knows
+		default:
+			return "", false
+		}
+	}
+
+	var options strings.Builder
+	if insensitive {
+		options.WriteByte('i')
+	}
+	if multiline {
+		options.WriteByte('m')
+	}
+	if dotall {
+		options.WriteByte('s')
+	}
+
+	return options.String(), true
+}
@@ -0,0 +1,52 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRegexp(t *testing.T) {
+	Convey("Given SQL with REGEXP", t, func() {
+		evaluator := newEvaluator(map[string][]interface{}{
+			"tickets": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "body", Value: "Refund for order 1234"}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "body", Value: "refund please"}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "body", Value: "login fails\nagain"}},
+				bson.D{{Key: "_id", Value: 4}},
+			},
+		})
+
+		Convey("It should translate REGEXP and RLIKE to $regex", func() {
+			So(build("SELECT _id FROM tickets WHERE body REGEXP 'order [0-9]+$'").Filter, ShouldResemble, bson.D{
+				{Key: "body", Value: bson.M{"$regex": "order [0-9]+$"}},
+			})
+			So(ids(evaluator, "SELECT _id FROM tickets WHERE body REGEXP 'order [0-9]+$'"), ShouldResemble, []interface{}{int32(1)})
+			So(ids(evaluator, "SELECT _id FROM tickets WHERE body RLIKE '^refund'"), ShouldResemble, []interface{}{int32(2)})
+		})
+
+		Convey("It should translate NOT REGEXP to $not", func() {
+			So(build("SELECT _id FROM tickets WHERE body NOT REGEXP '^refund'").Filter, ShouldResemble, bson.D{
+				{Key: "body", Value: bson.M{"$not": primitive.Regex{Pattern: "^refund"}}},
+			})
+			So(ids(evaluator, "SELECT _id FROM tickets WHERE body NOT REGEXP '^refund'"), ShouldResemble, []interface{}{int32(1), int32(3), int32(4)})
+		})
+
+		Convey("It should carry the options of REGEXP_LIKE", func() {
+			So(build("SELECT _id FROM tickets WHERE REGEXP_LIKE(body, '^refund', 'i')").Filter, ShouldResemble, bson.D{
+				{Key: "body", Value: bson.D{{Key: "$regex", Value: "^refund"}, {Key: "$options", Value: "i"}}},
+			})
+			So(ids(evaluator, "SELECT _id FROM tickets WHERE REGEXP_LIKE(body, '^refund', 'i')"), ShouldResemble, []interface{}{int32(1), int32(2)})
+			So(ids(evaluator, "SELECT _id FROM tickets WHERE REGEXP_LIKE(body, 'FAILS.AGAIN', 'ni')"), ShouldResemble, []interface{}{int32(3)})
+			So(ids(evaluator, "SELECT _id FROM tickets WHERE REGEXP_LIKE(body, '^again', 'm')"), ShouldResemble, []interface{}{int32(3)})
+			So(ids(evaluator, "SELECT _id FROM tickets WHERE REGEXP_LIKE(body, 'refund') AND NOT REGEXP_LIKE(body, 'REFUND', 'ic')"), ShouldResemble, []interface{}{int32(2)})
+		})
+
+		Convey("It should reject unknown match types", func() {
+			_, err := NewStatement("SELECT _id FROM tickets WHERE REGEXP_LIKE(body, 'x', 'q')").Build(NewQuery())
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -44,8 +44,9 @@ func (query *Query) handleVisibleAt(values string) error {
 }
 
 /*
-handleBirthDay processes a birthday filter, extracting day and month from
-the input and creating a MongoDB expression to match these components.
+handleBirthDay processes a birthday filter, matching the documents whose
+BirthDay falls on the given day and month of any year. The filter is written
+as SQL, and the filter it compiles into is added to the query.
 
 Parameters:
 - values: The birthday value in DD-MM format
@@ -58,12 +59,15 @@ func (query *Query) handleBirthDay(values string) error {
 	if err != nil {
 		return err
 	}
-	query.Filter = append(query.Filter, bson.E{Key: "$expr", Value: bson.M{
-		"$and": []bson.M{
-			{"$eq": []interface{}{bson.M{"$month": "$BirthDay"}, month}},
-			{"$eq": []interface{}{bson.M{"$dayOfMonth": "$BirthDay"}, day}},
-		},
-	}})
+
+	birthdays, err := NewStatement(fmt.Sprintf(
+		"SELECT * FROM birthdays WHERE MONTH(BirthDay) = %d AND DAYOFMONTH(BirthDay) = %d", month, day,
+	)).Build(NewQuery())
+	if err != nil {
+		return err
+	}
+
+	query.Filter = append(query.Filter, birthdays.Filter...)
 	return nil
 }
 
@@ -164,7 +168,9 @@ func parseTimeWithFormats(value string) (time.Time, error) {
 	formats := []string{
 		time.RFC3339,
 		"2006-01-02 15:04:05.999999999 -0700 MST",
-		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02",
 	}
 	for _, format := range formats {
 		if t, err := time.Parse(format, value); err == nil {
@@ -0,0 +1,244 @@
+package squeel
+
+import (
+	"regexp"
+	"strings"
+)
+
+/*
+typedLiteralRegex matches the start of a DATE or TIMESTAMP literal, up to
+and including its opening quote.
+*/
+var typedLiteralRegex = regexp.MustCompile(`(?i)^(date|timestamp)\s*'`)
+
+/*
+extractRegex matches the start of EXTRACT(unit FROM date), up to and
+including FROM.
+*/
+var extractRegex = regexp.MustCompile(`(?i)^extract\s*\(\s*([a-z_]+)\s+from\s`)
+
+/*
+typedLiteralTypes maps the type of a typed literal to the CAST type it is
+rewritten to.
+*/
+var typedLiteralTypes = map[string]string{
+	"date":      "date",
+	"timestamp": "datetime",
+}
+
+/*
+rewriteSQL rewrites the syntax the parser does not know into equivalent SQL
+it does, before the statement is parsed. ILIKE becomes a LIKE with a
+case-insensitive collation, DATE '2020-01-01' and TIMESTAMP '...' literals
+become a CAST of the string, EXTRACT(YEAR FROM d) becomes the function
+call EXTRACT('year', d), and field paths the parser cannot read, as in
+address.geo.location.lat or items.0.name, are folded into a qualified
+column. Quoted strings and identifiers are left as they are, except that \_
+and \% keep their backslash, as they do in MySQL, so LIKE can escape the
+wildcard with it.
+
+Parameters:
+- sql: The SQL to rewrite
+
+Returns:
+- The rewritten SQL
+*/
+func rewriteSQL(sql string) string {
+	var out strings.Builder
+	var quote byte
+	closing := ""
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		// Within a word, or at a later segment of an identifier chain
+		within := i > 0 && (isWordByte(sql[i-1]) || sql[i-1] == '.')
+
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(sql) {
+				out.WriteByte(c)
+				i++
+				c = sql[i]
+				if c == '_' || c == '%' {
+					// MySQL keeps the backslash of \_ and \% for LIKE
+					out.WriteByte('\\')
+				}
+			} else if c == quote && i+1 < len(sql) && sql[i+1] == quote {
+				out.WriteByte(c)
+				i++
+			} else if c == quote {
+				quote = 0
+				out.WriteByte(c)
+				out.WriteString(closing)
+				closing = ""
+				continue
+			}
+		case c == '\'' || c == '"' || c == '`' && within:
+			quote = c
+		case within:
+		case c == '`' || isWordByte(c):
+			if segments, n := identifierChain(sql[i:]); foldChain(segments) {
+				out.WriteString(foldedChain(sql[i:], segments))
+				i += n - 1
+				continue
+			}
+
+			if c == '`' {
+				quote = c
+				break
+			}
+
+			if match := ilikeRegex.FindStringSubmatch(sql[i:]); match != nil {
+				out.WriteString("collate " + ilikeCollation + " ")
+				if match[1] != "" {
+					out.WriteString("not ")
+				}
+				out.WriteString("like")
+				i += len(match[0]) - 1
+				continue
+			}
+
+			if match := typedLiteralRegex.FindStringSubmatch(sql[i:]); match != nil {
+				out.WriteString("cast(")
+				closing = " as " + typedLiteralTypes[strings.ToLower(match[1])] + ")"
+				// Continue at the opening quote of the literal
+				i += len(match[0]) - 2
+				continue
+			}
+
+			if match := extractRegex.FindStringSubmatch(sql[i:]); match != nil {
+				out.WriteString("extract('" + strings.ToLower(match[1]) + "', ")
+				i += len(match[0]) - 1
+				continue
+			}
+		}
+
+		out.WriteByte(c)
+	}
+
+	return out.String()
+}
+
+/*
+isWordByte reports whether a byte can be part of an identifier or keyword.
+*/
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+/*
+identifierChain reads the identifiers at the start of the SQL that are
+joined by dots, as in address.geo.lat or u.`first.name`.
+
+Parameters:
+- sql: The SQL, starting at the first identifier
+
+Returns:
+- The identifiers, with the quotes of quoted identifiers removed
+- The length of the chain in the SQL
+*/
+func identifierChain(sql string) ([]string, int) {
+	var segments []string
+	length := 0
+
+	for {
+		segment, n := identifier(sql[length:])
+		if n == 0 {
+			return segments, length
+		}
+
+		segments = append(segments, segment)
+		length += n
+
+		if length >= len(sql) || sql[length] != '.' {
+			return segments, length
+		}
+		if _, next := identifier(sql[length+1:]); next == 0 {
+			return segments, length
+		}
+		length++
+	}
+}
+
+/*
+identifier reads the unquoted or backtick-quoted identifier at the start of
+the SQL.
+
+Parameters:
+- sql: The SQL, starting at the identifier
+
+Returns:
+- The identifier, with its quotes removed
+- The length of the identifier in the SQL, or 0 when there is none
+*/
+func identifier(sql string) (string, int) {
+	if sql == "" || sql[0] != '`' {
+		n := 0
+		for n < len(sql) && isWordByte(sql[n]) {
+			n++
+		}
+		return sql[:n], n
+	}
+
+	var name strings.Builder
+	for i := 1; i < len(sql); i++ {
+		if sql[i] != '`' {
+			name.WriteByte(sql[i])
+			continue
+		}
+		if i+1 < len(sql) && sql[i+1] == '`' {
+			name.WriteByte('`')
+			i++
+			continue
+		}
+		return name.String(), i + 1
+	}
+
+	// An unterminated quote is left for the parser to report
+	return "", 0
+}
+
+/*
+foldChain reports whether an identifier chain is a field path the parser
+cannot read: one with more than three segments, or with an array index
+after the first segment.
+*/
+func foldChain(segments []string) bool {
+	if len(segments) < 2 || startsWithDigit(segments[0]) {
+		return false
+	}
+	if len(segments) > 3 {
+		return true
+	}
+	for _, segment := range segments[1:] {
+		if startsWithDigit(segment) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+foldedChain folds an identifier chain into its first identifier, which may
+be a table, qualifying a single quoted identifier that holds the other
+segments joined by pathSeparator.
+
+Parameters:
+- sql: The SQL, starting at the chain
+- segments: The identifiers of the chain
+
+Returns:
+- The folded column
+*/
+func foldedChain(sql string, segments []string) string {
+	_, n := identifier(sql)
+	path := strings.Join(segments[1:], pathSeparator)
+	return sql[:n] + ".`" + strings.ReplaceAll(path, "`", "``") + "`"
+}
+
+/*
+startsWithDigit reports whether a segment starts with a digit.
+*/
+func startsWithDigit(segment string) bool {
+	return segment != "" && segment[0] >= '0' && segment[0] <= '9'
+}
@@ -0,0 +1,327 @@
+package squeel
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+valueType is the type of a value that a scalar function takes or returns,
+as far as it is known when the statement is built.
+*/
+type valueType int
+
+const (
+	anyType      valueType = iota // A value of any type, or one that is not known until the query runs
+	stringType                    // A string
+	numberType                    // A number
+	arrayType                     // An array
+	dateType                      // A date
+	unitType                      // A unit of time, given as a word or a string, as in DAY or 'day'
+	intervalType                  // An INTERVAL, as in INTERVAL 1 DAY
+)
+
+/*
+String describes the type for error messages.
+
+Returns:
+- The description of the type
+*/
+func (t valueType) String() string {
+	switch t {
+	case stringType:
+		return "a string"
+	case numberType:
+		return "a number"
+	case arrayType:
+		return "an array"
+	case dateType:
+		return "a date"
+	case unitType:
+		return "a unit such as DAY"
+	case intervalType:
+		return "an INTERVAL"
+	}
+	return "any value"
+}
+
+/*
+scalarFunction describes a SQL function that computes a value from its
+arguments with aggregation operators, as UPPER(name) does with $toUpper.
+*/
+type scalarFunction struct {
+	params   []valueType                   // The types of the parameters
+	optional int                           // How many trailing parameters may be left out
+	variadic bool                          // Whether the last parameter may be repeated
+	result   valueType                     // The type of the value the function returns
+	check    func(args bson.A) string      // Validates the compiled arguments, returning the reason they are invalid
+	compile  func(args bson.A) interface{} // Builds the aggregation expression from the compiled arguments
+}
+
+/*
+ArgumentError is returned by Build in strict mode when a function is called
+with the wrong number of arguments, or with a literal argument of the wrong
+type.
+*/
+type ArgumentError struct {
+	Function string // The name of the function, in upper case
+	Reason   string // What is wrong with the arguments
+	SQL      string // The SQL text of the function call
+}
+
+/*
+Error describes the invalid call.
+
+Returns:
+- The error message
+*/
+func (err *ArgumentError) Error() string {
+	return fmt.Sprintf("invalid arguments to %s in %s: %s", err.Function, err.SQL, err.Reason)
+}
+
+/*
+lookupFunction returns the scalar function a SQL function name refers to.
+
+Parameters:
+- name: The lowercase name of the function
+
+Returns:
+- The scalar function
+- Whether the name refers to one
+*/
+func lookupFunction(name string) (scalarFunction, bool) {
+	if function, ok := stringFunctions[name]; ok {
+		return function, true
+	}
+	function, ok := dateFunctions[name]
+	return function, ok
+}
+
+/*
+functionExpression compiles a call to a scalar function into an aggregation
+expression, checking the number of its arguments and the types of those
+whose type is known.
+
+Parameters:
+- expr: The function call
+
+Returns:
+- The aggregation expression
+- Whether the call could be compiled
+*/
+func (statement *Statement) functionExpression(expr *sqlparser.FuncExpr) (interface{}, bool) {
+	args := make([]sqlparser.Expr, 0, len(expr.Exprs))
+	for _, arg := range expr.Exprs {
+		aliased, ok := arg.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, false
+		}
+		args = append(args, aliased.Expr)
+	}
+
+	return statement.callFunction(expr, expr.Name.Lowered(), args)
+}
+
+/*
+callFunction compiles a call to a scalar function with the given arguments.
+An invalid call is recorded as an ArgumentError.
+
+Parameters:
+- node: The node of the call, for error messages
+- name: The lowercase name of the function
+- args: The arguments of the call
+
+Returns:
+- The aggregation expression
+- Whether the call could be compiled
+*/
+func (statement *Statement) callFunction(node sqlparser.Expr, name string, args []sqlparser.Expr) (interface{}, bool) {
+	function, ok := lookupFunction(name)
+	if !ok {
+		return nil, false
+	}
+
+	if reason := function.checkArity(len(args)); reason != "" {
+		statement.invalidArguments(node, name, reason)
+		return nil, false
+	}
+
+	compiled := make(bson.A, 0, len(args))
+	for i, arg := range args {
+		value, reason, ok := statement.argument(function.params[min(i, len(function.params)-1)], arg)
+		if reason != "" {
+			statement.invalidArguments(node, name, fmt.Sprintf("argument %d %s", i+1, reason))
+			return nil, false
+		}
+		if !ok {
+			return nil, false
+		}
+		compiled = append(compiled, value)
+	}
+
+	if function.check != nil {
+		if reason := function.check(compiled); reason != "" {
+			statement.invalidArguments(node, name, reason)
+			return nil, false
+		}
+	}
+
+	return function.compile(compiled), true
+}
+
+/*
+argument compiles an argument of a scalar function for a parameter of the
+given type. A unit is a bare word or a string, as in TIMESTAMPDIFF(DAY, ...)
+and DATE_TRUNC('month', ...), an INTERVAL is compiled into a dateInterval,
+and a string literal passed as a date is parsed as one.
+
+Parameters:
+- param: The type of the parameter
+- arg: The argument
+
+Returns:
+- The compiled argument
+- The reason the argument does not fit the parameter, or an empty string
+- Whether the argument could be compiled
+*/
+func (statement *Statement) argument(param valueType, arg sqlparser.Expr) (interface{}, string, bool) {
+	actual := argumentType(arg)
+
+	switch param {
+	case unitType:
+		switch arg := arg.(type) {
+		case *sqlparser.ColName:
+			if arg.Qualifier.IsEmpty() {
+				return arg.Name.Lowered(), "", true
+			}
+		case *sqlparser.SQLVal:
+			if arg.Type == sqlparser.StrVal {
+				return strings.ToLower(string(arg.Val)), "", true
+			}
+		}
+		return nil, "must be " + param.String(), false
+	case intervalType:
+		if interval, ok := arg.(*sqlparser.IntervalExpr); ok {
+			return statement.interval(interval)
+		}
+	case dateType:
+		if val, ok := arg.(*sqlparser.SQLVal); ok && val.Type == sqlparser.StrVal {
+			t, err := parseTimeWithFormats(string(val.Val))
+			if err != nil {
+				return nil, "must be a date, not " + sqlparser.String(val), false
+			}
+			return t, "", true
+		}
+	}
+
+	if param != anyType && actual != anyType && actual != param || actual == intervalType {
+		return nil, fmt.Sprintf("must be %s, not %s", param, actual), false
+	}
+
+	value, ok := statement.expression(arg)
+	return value, "", ok
+}
+
+/*
+checkArity checks the number of arguments a function is called with.
+
+Parameters:
+- n: The number of arguments
+
+Returns:
+- The reason the number is wrong, or an empty string
+*/
+func (function scalarFunction) checkArity(n int) string {
+	required := len(function.params) - function.optional
+
+	switch {
+	case function.variadic && n < required:
+		return fmt.Sprintf("expected at least %s, got %d", arguments(required), n)
+	case function.variadic:
+		return ""
+	case n < required || n > len(function.params):
+		if function.optional == 0 {
+			return fmt.Sprintf("expected %s, got %d", arguments(required), n)
+		}
+		return fmt.Sprintf("expected %d to %s, got %d", required, arguments(len(function.params)), n)
+	}
+	return ""
+}
+
+/*
+arguments describes a number of arguments.
+*/
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+/*
+argumentType returns the type of an argument when it is known before the
+query runs: that of a literal, of arithmetic, of an INTERVAL, or of the
+result of a scalar function. NULL and columns may hold any type.
+
+Parameters:
+- expr: The argument
+
+Returns:
+- The type of the argument
+*/
+func argumentType(expr sqlparser.Expr) valueType {
+	switch expr := expr.(type) {
+	case *sqlparser.SQLVal:
+		switch expr.Type {
+		case sqlparser.StrVal:
+			return stringType
+		case sqlparser.IntVal, sqlparser.FloatVal:
+			return numberType
+		}
+	case *sqlparser.ParenExpr:
+		return argumentType(expr.Expr)
+	case *sqlparser.BinaryExpr:
+		if _, ok := expr.Right.(*sqlparser.IntervalExpr); ok {
+			return dateType
+		}
+		return numberType
+	case *sqlparser.UnaryExpr:
+		return numberType
+	case *sqlparser.ConvertExpr:
+		if _, ok := dateLiteral(expr); ok {
+			return dateType
+		}
+	case *sqlparser.IntervalExpr:
+		return intervalType
+	case *sqlparser.SubstrExpr:
+		return stringType
+	case *sqlparser.FuncExpr:
+		if function, ok := lookupFunction(expr.Name.Lowered()); ok {
+			return function.result
+		}
+	}
+	return anyType
+}
+
+/*
+invalidArguments records a function call with invalid arguments. The call is
+always logged; in strict mode the first invalid call also becomes the error
+returned by Build.
+
+Parameters:
+- node: The function call
+- name: The lowercase name of the function
+- reason: What is wrong with the arguments
+*/
+func (statement *Statement) invalidArguments(node sqlparser.Expr, name, reason string) {
+	err := &ArgumentError{Function: strings.ToUpper(name), Reason: reason, SQL: sqlparser.String(node)}
+
+	logDebug("%s", err)
+
+	if statement.strict && statement.unsupportedErr == nil {
+		statement.unsupportedErr = err
+	}
+}
@@ -0,0 +1,198 @@
+package squeel
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+scanTag is the struct tag that maps a struct field onto a result column. The
+tag value is the column name as it appears in the SELECT list, or - to skip
+the field. Untagged fields match a column with the same name, ignoring case.
+*/
+const scanTag = "squeel"
+
+/*
+Scan maps a single result document onto a value of type T, which must be a
+struct or a pointer to a struct.
+
+Keys of an _id document, as produced by a $group stage, are lifted to the top
+level first, so GROUP BY columns can be scanned like any other column. Binary
+UUIDs are decoded to their canonical string form when scanned into string
+fields, reversing the conversion done by CSUUID.
+
+Parameters:
+- document: The result document to scan
+
+Returns:
+- The populated value
+- Any error that occurred while converting a column
+*/
+func Scan[T any](document bson.D) (T, error) {
+	var out T
+
+	target := reflect.ValueOf(&out).Elem()
+	if target.Kind() == reflect.Pointer {
+		target.Set(reflect.New(target.Type().Elem()))
+		target = target.Elem()
+	}
+
+	if target.Kind() != reflect.Struct {
+		return out, fmt.Errorf("scan target must be a struct, got %s", target.Type())
+	}
+
+	document = flattenGroupID(document)
+
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		column := field.Tag.Get(scanTag)
+		if column == "-" {
+			continue
+		}
+
+		value, ok := scanColumn(document, column, field.Name)
+		if !ok {
+			continue
+		}
+
+		if err := assignColumn(target.Field(i), value); err != nil {
+			return out, fmt.Errorf("column %s: %w", field.Name, err)
+		}
+	}
+
+	return out, nil
+}
+
+/*
+All reads every remaining document from a cursor and scans it into a value
+of type T. The cursor is closed when All returns.
+
+Parameters:
+- ctx: The context for reading the cursor
+- cursor: The cursor to read, such as the cursor of a Result
+
+Returns:
+- The scanned values, in cursor order
+- Any error that occurred while reading or scanning
+*/
+func All[T any](ctx context.Context, cursor *mongo.Cursor) ([]T, error) {
+	defer cursor.Close(ctx)
+
+	out := make([]T, 0)
+	for cursor.Next(ctx) {
+		var document bson.D
+		if err := cursor.Decode(&document); err != nil {
+			return nil, err
+		}
+
+		value, err := Scan[T](document)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+	}
+
+	return out, cursor.Err()
+}
+
+/*
+flattenGroupID lifts the keys of an _id document to the top level of the
+document, without overwriting keys that are already present.
+*/
+func flattenGroupID(document bson.D) bson.D {
+	id, ok := documentField(document, "_id")
+	if !ok {
+		return document
+	}
+
+	keys, ok := id.(bson.D)
+	if !ok {
+		return document
+	}
+
+	out := append(bson.D(nil), document...)
+	for _, key := range keys {
+		if _, exists := documentField(out, key.Key); !exists {
+			out = append(out, key)
+		}
+	}
+
+	return out
+}
+
+/*
+scanColumn finds the value for a struct field. A tagged field is looked up by
+its tag, which may be a dotted path into embedded documents; an untagged field
+is matched by name, ignoring case.
+*/
+func scanColumn(document bson.D, column, name string) (interface{}, bool) {
+	if column != "" {
+		return fieldValue(document, column)
+	}
+
+	for _, element := range document {
+		if strings.EqualFold(element.Key, name) {
+			return element.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+/*
+assignColumn converts a document value into a struct field. Binary UUIDs
+going into strings are decoded first; everything else is decoded by the
+BSON registry, so nested structs, slices and times work as they do for the
+driver.
+*/
+func assignColumn(field reflect.Value, value interface{}) error {
+	value = decodeUUIDs(value, field.Type())
+
+	raw, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return err
+	}
+
+	return bson.Raw(raw).Lookup("v").Unmarshal(field.Addr().Interface())
+}
+
+/*
+decodeUUIDs replaces Binary UUIDs with their canonical strings wherever the
+destination type expects a string, including inside slices.
+*/
+func decodeUUIDs(value interface{}, target reflect.Type) interface{} {
+	for target.Kind() == reflect.Pointer {
+		target = target.Elem()
+	}
+
+	switch value := value.(type) {
+	case primitive.Binary:
+		if target.Kind() != reflect.String {
+			return value
+		}
+		if uuid, err := UUIDFromCSUUID(value); err == nil {
+			return uuid
+		}
+	case bson.A:
+		if target.Kind() != reflect.Slice && target.Kind() != reflect.Array {
+			return value
+		}
+		out := make(bson.A, len(value))
+		for i, element := range value {
+			out[i] = decodeUUIDs(element, target.Elem())
+		}
+		return out
+	}
+
+	return value
+}
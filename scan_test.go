@@ -0,0 +1,99 @@
+package squeel
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type scannedUser struct {
+	ID       string    `squeel:"_id"`
+	Name     string    `squeel:"full_name"`
+	Accounts []string  `squeel:"Accounts"`
+	City     string    `squeel:"address.city"`
+	Created  time.Time `squeel:"created_at"`
+	Age      int
+	Ignored  string `squeel:"-"`
+}
+
+type scannedGroup struct {
+	Department string  `squeel:"department"`
+	Count      int64   `squeel:"emp_count"`
+	Average    float64 `squeel:"avg_salary"`
+}
+
+func TestScan(t *testing.T) {
+	Convey("Given a CSUUID binary value", t, func() {
+		Convey("It should convert back to the canonical UUID", func() {
+			uuid, err := UUIDFromCSUUID(uuidBin)
+			So(err, ShouldBeNil)
+			So(uuid, ShouldEqual, strings.ToLower(uuidIn))
+		})
+
+		Convey("It should convert base64 back to hex", func() {
+			raw, err := Base64ToHex(string(HexToBase64("695ff995")))
+			So(err, ShouldBeNil)
+			So(raw, ShouldEqual, "695ff995")
+
+			_, err = Base64ToHex("not base64!")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given result documents", t, func() {
+		created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+		Convey("It should scan tagged, nested and untagged columns", func() {
+			user, err := Scan[scannedUser](bson.D{
+				{Key: "_id", Value: uuidBin},
+				{Key: "full_name", Value: "Ann"},
+				{Key: "Accounts", Value: bson.A{uuidBin}},
+				{Key: "address", Value: bson.D{{Key: "city", Value: "Utrecht"}}},
+				{Key: "created_at", Value: created},
+				{Key: "AGE", Value: int32(31)},
+				{Key: "Ignored", Value: "x"},
+			})
+			So(err, ShouldBeNil)
+			So(user, ShouldResemble, scannedUser{
+				ID:       strings.ToLower(uuidIn),
+				Name:     "Ann",
+				Accounts: []string{strings.ToLower(uuidIn)},
+				City:     "Utrecht",
+				Created:  created,
+				Age:      31,
+			})
+		})
+
+		Convey("It should flatten $group _id keys", func() {
+			group, err := Scan[*scannedGroup](bson.D{
+				{Key: "_id", Value: bson.D{{Key: "department", Value: "sales"}}},
+				{Key: "emp_count", Value: int32(3)},
+				{Key: "avg_salary", Value: 1250.5},
+			})
+			So(err, ShouldBeNil)
+			So(group, ShouldResemble, &scannedGroup{Department: "sales", Count: 3, Average: 1250.5})
+		})
+
+		Convey("It should scan every document of a cursor", func() {
+			cursor, err := mongo.NewCursorFromDocuments([]interface{}{
+				bson.D{{Key: "_id", Value: bson.D{{Key: "department", Value: "a"}}}, {Key: "emp_count", Value: 1}},
+				bson.D{{Key: "_id", Value: bson.D{{Key: "department", Value: "b"}}}, {Key: "emp_count", Value: 2}},
+			}, nil, nil)
+			So(err, ShouldBeNil)
+
+			groups, err := All[scannedGroup](context.Background(), cursor)
+			So(err, ShouldBeNil)
+			So(groups, ShouldResemble, []scannedGroup{{Department: "a", Count: 1}, {Department: "b", Count: 2}})
+		})
+
+		Convey("It should reject non-struct targets", func() {
+			_, err := Scan[int](bson.D{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
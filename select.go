@@ -1,6 +1,8 @@
 package squeel
 
 import (
+	"strings"
+
 	"github.com/xwb1989/sqlparser"
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -72,7 +74,7 @@ func (statement *Statement) handleSelectExpr(state *selectState, expr sqlparser.
 
 	aliased, ok := expr.(*sqlparser.AliasedExpr)
 	if !ok {
-		logDebug("parseSelect - Unhandled SELECT expression type: %T", expr)
+		statement.unsupported(expr, "")
 		return true
 	}
 
@@ -93,30 +95,122 @@ Returns:
 func (statement *Statement) handleAliasedSelectExpr(state *selectState, expr *sqlparser.AliasedExpr) bool {
 	switch exprType := expr.Expr.(type) {
 	case *sqlparser.ColName:
-		state.query.Projection = append(state.query.Projection, bson.E{
-			Key:   exprType.Name.CompliantName(),
-			Value: 1,
-		})
+		projection, ok := statement.columnProjection(exprType, expr.As.String())
+		if !ok {
+			statement.unsupported(exprType, expr.As.String())
+			break
+		}
+		state.query.Projection = append(state.query.Projection, projection)
 	case *sqlparser.FuncExpr:
 		statement.handleFuncExpr(state, expr, exprType)
 	case *sqlparser.Subquery:
 		statement.handleSubquery(state, expr, exprType)
+	case *sqlparser.BinaryExpr, *sqlparser.UnaryExpr, *sqlparser.CaseExpr, *sqlparser.SubstrExpr:
+		statement.handleComputedColumn(state, expr)
 	case *sqlparser.ParenExpr:
 		return statement.handleAliasedSelectExpr(state, &sqlparser.AliasedExpr{
 			Expr: exprType.Expr,
 			As:   expr.As,
 		})
-	case *sqlparser.SQLVal:
-		state.query.Projection = append(state.query.Projection, bson.E{
-			Key:   expr.As.String(),
-			Value: string(exprType.Val),
-		})
+	case *sqlparser.SQLVal, *sqlparser.NullVal, sqlparser.BoolVal:
+		statement.handleLiteralColumn(state, expr)
 	default:
-		logDebug("parseSelect - Unhandled expression type: %T", exprType)
+		statement.unsupported(exprType, "")
 	}
 	return true
 }
 
+/*
+columnProjection builds the projection of a column in the SELECT list. A
+column is included under its own path, and an aliased column is renamed by
+projecting its path under the alias, as in {name: "$first_name"}. A column
+without a dotted path is read with $getField, under its alias or its last
+segment.
+
+Parameters:
+- col: The column
+- alias: The alias of the column, or an empty string
+
+Returns:
+- The projection of the column
+- Whether the column can be projected under a valid field name
+*/
+func (statement *Statement) columnProjection(col *sqlparser.ColName, alias string) (bson.E, bool) {
+	key := statement.projectedKey(col, alias)
+	if !statement.plainColumn(col) {
+		return bson.E{Key: key, Value: statement.fieldExpression(col)}, plainSegment(key)
+	}
+
+	if path := statement.fieldPath(col); key != path {
+		return bson.E{Key: key, Value: "$" + path}, true
+	}
+	return bson.E{Key: key, Value: 1}, true
+}
+
+/*
+projectedKey returns the key a column of the SELECT list is projected under:
+its alias, its path, or for a column without a dotted path its last
+segment.
+
+Parameters:
+- col: The column
+- alias: The alias of the column, or an empty string
+
+Returns:
+- The projected key
+*/
+func (statement *Statement) projectedKey(col *sqlparser.ColName, alias string) string {
+	switch {
+	case alias != "":
+		return alias
+	case statement.plainColumn(col):
+		return statement.fieldPath(col)
+	}
+
+	segments := columnSegments(col)
+	return segments[len(segments)-1]
+}
+
+/*
+handleLiteralColumn processes a literal in the SELECT list, as in SELECT 1 AS
+one, projecting its value with $literal so it is not read as an inclusion
+flag or field path.
+
+Parameters:
+- state: The current select processing state
+- aliased: The aliased expression containing the literal
+*/
+func (statement *Statement) handleLiteralColumn(state *selectState, aliased *sqlparser.AliasedExpr) {
+	value, ok := statement.literal(aliased.Expr)
+	name := literalColumnName(aliased)
+	if !ok || name == "" || strings.Contains(name, ".") || strings.HasPrefix(name, "$") {
+		statement.unsupported(aliased.Expr, name)
+		return
+	}
+
+	state.query.Projection = append(state.query.Projection, bson.E{Key: name, Value: bson.M{"$literal": value}})
+}
+
+/*
+literalColumnName returns the name of a literal column: its alias, or as in
+MySQL the text of the literal, which for a string is the string itself.
+
+Parameters:
+- aliased: The aliased expression containing the literal
+
+Returns:
+- The name of the column
+*/
+func literalColumnName(aliased *sqlparser.AliasedExpr) string {
+	if !aliased.As.IsEmpty() {
+		return aliased.As.String()
+	}
+	if val, ok := aliased.Expr.(*sqlparser.SQLVal); ok && val.Type == sqlparser.StrVal {
+		return string(val.Val)
+	}
+	return sqlparser.String(aliased.Expr)
+}
+
 /*
 handleFuncExpr processes function expressions in SELECT clauses, converting
 them into appropriate MongoDB aggregation operations.
@@ -127,6 +221,11 @@ Parameters:
 - expr: The function expression to process
 */
 func (statement *Statement) handleFuncExpr(state *selectState, aliased *sqlparser.AliasedExpr, expr *sqlparser.FuncExpr) {
+	if isComputed(expr) {
+		statement.handleComputedColumn(state, aliased)
+		return
+	}
+
 	funcName := expr.Name.Lowered()
 	state.query.Operation = "aggregate"
 	state.hasComplexAggr = true
@@ -134,12 +233,10 @@ func (statement *Statement) handleFuncExpr(state *selectState, aliased *sqlparse
 	switch funcName {
 	case "distinct":
 		statement.handleDistinct(state, expr)
-	case "count":
-		statement.handleCount(state, aliased, expr)
-	case "sum", "avg", "min", "max":
-		statement.handleSimpleAggregate(state, aliased, expr, funcName)
+	case "count", "sum", "avg", "min", "max":
+		statement.handleAggregate(state, aliased, expr, funcName)
 	default:
-		logDebug("parseSelect - Unhandled function: %s", expr.Name.String())
+		statement.unsupported(expr, expr.Name.String())
 	}
 }
 
@@ -155,10 +252,9 @@ func (statement *Statement) handleDistinct(state *selectState, expr *sqlparser.F
 	state.query.Operation = "distinct"
 	if len(expr.Exprs) > 0 {
 		if colExpr := statement.getColumnFromAliasedExpr(expr.Exprs[0]); colExpr != nil {
-			state.query.Projection = append(state.query.Projection, bson.E{
-				Key:   colExpr.Name.CompliantName(),
-				Value: 1,
-			})
+			if field, ok := statement.filterField(colExpr); ok {
+				state.query.Projection = append(state.query.Projection, bson.E{Key: field, Value: 1})
+			}
 		}
 	}
 }
@@ -183,8 +279,9 @@ func (statement *Statement) getColumnFromAliasedExpr(expr sqlparser.SelectExpr)
 }
 
 /*
-handleSubquery processes subquery expressions in SELECT clauses, recursively
-building the subquery and incorporating it into the main query's pipeline.
+handleSubquery processes a scalar subquery in the SELECT list, looking it up
+with the columns of this statement it refers to, and adding its value to
+each row under the subquery's alias.
 
 Parameters:
 - state: The current select processing state
@@ -195,116 +292,110 @@ func (statement *Statement) handleSubquery(state *selectState, aliased *sqlparse
 	state.hasSubquery = true
 	state.hasComplexAggr = true
 
-	subQ := NewQuery()
-	subStmt := &Statement{raw: sqlparser.String(subquery.Select)}
-	if subQ, err := subStmt.Build(subQ); err == nil {
-		statement.appendSubqueryPipeline(state.query, subQ, aliased.As.String())
+	if aliased.As.IsEmpty() {
+		statement.unsupported(subquery, "")
+		return
 	}
-}
 
-/*
-appendSubqueryPipeline adds the necessary stages to incorporate a subquery
-into the main query's pipeline using $lookup and $addFields stages.
+	lookup, ok := statement.subqueryLookup(state.query, subquery)
+	if !ok {
+		return
+	}
 
-Parameters:
-- q: The main Query object
-- subQ: The subquery Query object
-- alias: The alias for the subquery results
-*/
-func (statement *Statement) appendSubqueryPipeline(q *Query, subQ *Query, alias string) {
-	q.Operation = "aggregate"
-	q.Pipeline = append(q.Pipeline,
-		bson.D{{Key: "$lookup", Value: bson.D{
-			{Key: "from", Value: subQ.Collection},
-			{Key: "localField", Value: "id"},
-			{Key: "foreignField", Value: "user_id"},
-			{Key: "as", Value: "subquery_result"},
-		}}},
-		bson.D{{Key: "$addFields", Value: bson.D{
-			{Key: alias, Value: bson.D{
-				{Key: "$size", Value: "$subquery_result"},
-			}},
-		}}},
-	)
+	statement.appendSubqueryPipeline(state.query, lookup, aliased.As.String())
 }
 
 /*
-finalizeSelectQuery performs final adjustments to the query based on the
-presence of subqueries and complex aggregations.
+appendSubqueryPipeline adds the $lookup of a scalar subquery to the SELECT
+slot of the pipeline, so it only runs for the rows WHERE keeps, followed by
+an $addFields stage that replaces the looked up documents under its alias
+with the value it selects. A COUNT(*) subquery counts the looked up
+documents; any other subquery must select a single field, whose value in
+the first looked up document is used.
 
 Parameters:
-- state: The current select processing state
-
-Returns:
-- The finalized Query object
+- q: The main Query object
+- lookup: The compiled subquery
+- alias: The alias for the subquery results
 */
-func (statement *Statement) finalizeSelectQuery(state *selectState) *Query {
-	if state.hasSubquery || state.hasComplexAggr {
-		state.query.Operation = "aggregate"
-	}
-
-	if len(state.query.Projection) == 0 && state.query.Operation != "aggregate" {
-		state.query.Projection = nil
+func (statement *Statement) appendSubqueryPipeline(q *Query, lookup *subqueryLookup, alias string) {
+	lookup.as = alias
+	value := bson.M{"$size": "$" + lookup.as}
+
+	if lookup.query.Operation == "count" {
+		lookup.stages = append(lookup.stages, bson.D{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}})
+	} else {
+		field, ok := selectedField(lookup.query)
+		if !ok {
+			statement.unsupported(lookup.node, alias)
+			return
+		}
+		lookup.stages = append(lookup.stages, bson.D{{Key: "$limit", Value: 1}})
+		value = bson.M{"$arrayElemAt": bson.A{"$" + lookup.as + "." + field, 0}}
 	}
 
-	return state.query
+	statement.addLookup(q, lookup, clauseSelect)
+	q.Projection = append(q.Projection, bson.E{Key: alias, Value: 1})
+	statement.pipeline.add(clauseSelect, bson.D{{Key: "$addFields", Value: bson.D{{Key: alias, Value: value}}}})
 }
 
 /*
-addAggregateStage adds a new aggregation stage to the query pipeline for
-processing aggregate functions.
+handleComputedColumn processes an arithmetic, CASE or scalar function
+expression in the SELECT list, as in price * qty AS total, projecting the
+value it computes. Without an alias the column is named after the
+expression, as in MySQL, which only works when that name is a valid field
+name.
 
 Parameters:
 - state: The current select processing state
-- aliased: The aliased expression containing the aggregate
-- expr: The function expression
-- operator: The MongoDB aggregation operator to use
-- value: The value or field reference for the aggregation
+- aliased: The aliased expression to process
 */
-func (statement *Statement) addAggregateStage(state *selectState, aliased *sqlparser.AliasedExpr, expr *sqlparser.FuncExpr, operator string, value interface{}) {
-	alias := aliased.As.String()
-	if alias == "" {
-		alias = expr.Name.Lowered()
-		if len(expr.Exprs) > 0 {
-			if colExpr := statement.getColumnFromAliasedExpr(expr.Exprs[0]); colExpr != nil {
-				alias = expr.Name.Lowered() + "_" + colExpr.Name.CompliantName()
-			}
-		}
+func (statement *Statement) handleComputedColumn(state *selectState, aliased *sqlparser.AliasedExpr) {
+	value, ok := statement.expression(aliased.Expr)
+	if !ok {
+		statement.unsupported(aliased.Expr, "")
+		return
 	}
 
-	groupStage := bson.D{
-		{Key: "_id", Value: nil},
-		{Key: alias, Value: bson.M{operator: value}},
+	name := aliased.As.String()
+	if name == "" {
+		name = sqlparser.String(aliased.Expr)
+	}
+	if strings.Contains(name, ".") || strings.HasPrefix(name, "$") {
+		statement.unsupported(aliased.Expr, name)
+		return
 	}
 
-	state.query.Pipeline = append(state.query.Pipeline, bson.D{{Key: mongoGroupStage, Value: groupStage}})
+	state.hasComplexAggr = true
+	state.query.Projection = append(state.query.Projection, bson.E{Key: name, Value: value})
 }
 
 /*
-handleCount processes COUNT function expressions, handling both regular and
-DISTINCT COUNT operations.
+finalizeSelectQuery performs final adjustments to the query based on the
+presence of subqueries and complex aggregations.
 
 Parameters:
 - state: The current select processing state
-- aliased: The aliased expression containing the COUNT
-- expr: The COUNT function expression
+
+Returns:
+- The finalized Query object
 */
-func (statement *Statement) handleCount(state *selectState, aliased *sqlparser.AliasedExpr, expr *sqlparser.FuncExpr) {
-	alias := statement.getAggregateAlias(aliased, expr, "count")
+func (statement *Statement) finalizeSelectQuery(state *selectState) *Query {
+	if state.hasSubquery || state.hasComplexAggr {
+		state.query.Operation = "aggregate"
+	}
 
-	if expr.Distinct && len(expr.Exprs) > 0 {
-		if colExpr := statement.getColumnFromAliasedExpr(expr.Exprs[0]); colExpr != nil {
-			statement.addDistinctCountStage(state, alias, colExpr)
-			return
-		}
+	if len(state.query.Projection) == 0 && state.query.Operation != "aggregate" {
+		state.query.Projection = nil
 	}
 
-	statement.addAggregateStage(state, aliased, expr, "$sum", 1)
+	return state.query
 }
 
 /*
-handleSimpleAggregate processes simple aggregate functions (SUM, AVG, MIN, MAX),
-adding appropriate aggregation stages to the pipeline.
+handleAggregate processes aggregate functions (COUNT, SUM, AVG, MIN, MAX) in
+the SELECT list, adding an accumulator to the statement's single $group stage
+and selecting its result under the aggregate's alias.
 
 Parameters:
 - state: The current select processing state
@@ -312,35 +403,14 @@ Parameters:
 - expr: The aggregate function expression
 - funcName: The name of the aggregate function
 */
-func (statement *Statement) handleSimpleAggregate(state *selectState, aliased *sqlparser.AliasedExpr, expr *sqlparser.FuncExpr, funcName string) {
-	if len(expr.Exprs) > 0 {
-		if colExpr := statement.getColumnFromAliasedExpr(expr.Exprs[0]); colExpr != nil {
-			field := colExpr.Name.CompliantName()
-			statement.addAggregateStage(state, aliased, expr, "$"+funcName, "$"+field)
-		}
+func (statement *Statement) handleAggregate(state *selectState, aliased *sqlparser.AliasedExpr, expr *sqlparser.FuncExpr, funcName string) {
+	alias, ok := statement.aggregate(state.query, expr, statement.getAggregateAlias(aliased, expr, funcName))
+	if !ok {
+		statement.unsupported(expr, funcName)
+		return
 	}
-}
-
-/*
-addDistinctCountStage adds the necessary stages to perform a COUNT DISTINCT
-operation using MongoDB's aggregation pipeline.
 
-Parameters:
-- state: The current select processing state
-- alias: The alias for the count result
-- colExpr: The column to count distinct values from
-*/
-func (statement *Statement) addDistinctCountStage(state *selectState, alias string, colExpr *sqlparser.ColName) {
-	field := colExpr.Name.CompliantName()
-	state.query.Pipeline = append(state.query.Pipeline,
-		bson.D{{Key: "$group", Value: bson.D{
-			{Key: "_id", Value: "$" + field},
-		}}},
-		bson.D{{Key: "$group", Value: bson.D{
-			{Key: "_id", Value: nil},
-			{Key: alias, Value: bson.M{"$sum": 1}},
-		}}},
-	)
+	state.query.Projection = append(state.query.Projection, bson.E{Key: alias, Value: 1})
 }
 
 /*
@@ -0,0 +1,60 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSelectAliases(t *testing.T) {
+	Convey("Given SQL that renames columns or selects literals", t, func() {
+		evaluator := newEvaluator(evaluatorFixtures)
+
+		Convey("It should rename aliased columns in a find projection", func() {
+			q := build("SELECT name AS who, age, _id AS _id FROM users WHERE _id = 1")
+			So(q.Operation, ShouldEqual, "find")
+			So(q.Projection, ShouldResemble, bson.D{
+				{Key: "who", Value: "$name"},
+				{Key: "age", Value: 1},
+				{Key: "_id", Value: 1},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1), "who": "ann", "age": int32(31)}})
+		})
+
+		Convey("It should project literals with $literal", func() {
+			q := build("SELECT 1 AS one, 'x', NULL AS nothing, true AS yes FROM users WHERE _id = 2")
+			So(q.Projection, ShouldResemble, bson.D{
+				{Key: "one", Value: bson.M{"$literal": int64(1)}},
+				{Key: "x", Value: bson.M{"$literal": "x"}},
+				{Key: "nothing", Value: bson.M{"$literal": nil}},
+				{Key: "yes", Value: bson.M{"$literal": true}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2), "one": int64(1), "x": "x", "nothing": nil, "yes": true}})
+		})
+
+		Convey("It should rename columns in the $project stage of a pipeline", func() {
+			q := build("SELECT name AS who FROM users ORDER BY age")
+			So(q.Pipeline[0], ShouldResemble, bson.D{{Key: "$project", Value: bson.D{
+				{Key: "who", Value: "$name"},
+				{Key: "age", Value: 1},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(2), "who": "bob"},
+				{"_id": int32(1), "who": "ann"},
+				{"_id": int32(3), "who": "cid"},
+			})
+		})
+
+		Convey("It should rename grouped columns", func() {
+			q := build("SELECT name AS who, COUNT(*) AS n FROM users WHERE age > 18 GROUP BY name ORDER BY who")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"who": "ann", "n": int32(1)}, {"who": "cid", "n": int32(1)}})
+		})
+
+		Convey("It should select distinct renamed columns with a pipeline", func() {
+			q := build("SELECT DISTINCT name AS who FROM users WHERE _id < 3")
+			So(q.Operation, ShouldEqual, "aggregate")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"who": "ann"}, {"who": "bob"}})
+		})
+	})
+}
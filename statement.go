@@ -2,7 +2,6 @@ package squeel
 
 import (
 	"fmt"
-	"reflect"
 
 	errnie "github.com/theapemachine/errnie/v3"
 	"github.com/xwb1989/sqlparser"
@@ -14,11 +13,26 @@ a MongoDB query. It maintains both the raw SQL string and the parsed AST
 representation of the statement.
 */
 type Statement struct {
-	raw  string              // The original SQL query string
-	stmt sqlparser.Statement // The parsed SQL statement AST
-	err  error               // Any error that occurred during parsing
+	raw             string              // The original SQL query string
+	stmt            sqlparser.Statement // The parsed SQL statement AST
+	err             error               // Any error that occurred during parsing
+	pipeline        *pipeline           // Aggregation stages collected per SQL clause
+	tables          *tables             // The tables of the FROM clause, keyed by name
+	enclosing       map[string]bool     // The tables of the statements this one is a subquery of
+	scope           *joinCondition      // The $lookup whose pipeline conditions are being compiled, if any
+	options         []StatementOption   // The options the statement was created with
+	strict          bool                // Whether unsupported SQL fails the build
+	nulls           NullSemantics       // How NULL and missing fields are compared
+	likeInsensitive bool                // Whether LIKE ignores case
+	dateFields      map[string][]string // The date fields of every collection
+	unsupportedErr  error               // The first node that could not be translated in strict mode
 }
 
+/*
+StatementOption configures how a Statement translates SQL.
+*/
+type StatementOption func(*Statement)
+
 /*
 NewStatement creates a new Statement instance from a raw SQL query string.
 It initializes the statement with the raw SQL but does not parse it until
@@ -26,18 +40,47 @@ Build is called.
 
 Parameters:
 - raw: The SQL query string to be parsed
+- options: Options that configure the translation, such as WithStrict
 
 Returns:
 - A new Statement instance ready for building
 */
-func NewStatement(raw string) *Statement {
-	return &Statement{raw: raw}
+func NewStatement(raw string, options ...StatementOption) *Statement {
+	statement := &Statement{raw: raw, options: options, strict: true}
+	for _, option := range options {
+		option(statement)
+	}
+	return statement
+}
+
+/*
+subStatement creates a Statement for a subquery, configured with the same
+options as the statement it is part of, and aware of the tables of every
+statement it is nested in.
+
+Parameters:
+- node: The SELECT of the subquery
+
+Returns:
+- A new Statement instance for the subquery
+*/
+func (statement *Statement) subStatement(node sqlparser.SelectStatement) *Statement {
+	sub := NewStatement(sqlparser.String(node), statement.options...)
+	sub.enclosing = map[string]bool{}
+	for name := range statement.enclosing {
+		sub.enclosing[name] = true
+	}
+	for name := range statement.tables.collections {
+		sub.enclosing[name] = true
+	}
+	return sub
 }
 
 /*
 Build processes the SQL statement and constructs a MongoDB query configuration.
 It first validates the statement, then parses the SQL and walks through the
-AST to build the appropriate MongoDB query components.
+AST to build the appropriate MongoDB query components. In strict mode, SQL
+that cannot be translated makes Build return an *UnsupportedError.
 
 Parameters:
 - q: The Query object to populate with MongoDB query configuration
@@ -51,11 +94,20 @@ func (statement *Statement) Build(q *Query) (*Query, error) {
 		return q, err
 	}
 
+	statement.pipeline = newPipeline()
+	statement.tables = newTables()
+	statement.unsupportedErr = nil
+
 	if err := statement.parseSQL(q); err != nil {
 		return q, err
 	}
 
-	return statement.finalizeQuery(q)
+	q, err := statement.finalizeQuery(q)
+	if err != nil {
+		return q, err
+	}
+
+	return q, statement.unsupportedErr
 }
 
 /*
@@ -88,7 +140,7 @@ Returns:
 */
 func (statement *Statement) parseSQL(q *Query) error {
 	var err error
-	statement.stmt, err = sqlparser.Parse(statement.raw)
+	statement.stmt, err = sqlparser.Parse(rewriteSQL(statement.raw))
 	if err != nil {
 		return errnie.Error(err)
 	}
@@ -99,7 +151,9 @@ func (statement *Statement) parseSQL(q *Query) error {
 /*
 walkNode returns a function that processes each node in the SQL AST.
 It handles different types of SQL nodes (SELECT, WHERE, etc.) and updates
-the Query object accordingly. Unhandled node types are tracked but not processed.
+the Query object accordingly. Expressions are translated by the clause they
+belong to, so the only node types left unhandled are statements other than
+SELECT, such as UNION or INSERT, which are reported as unsupported.
 
 Parameters:
 - q: The Query object to modify during AST traversal
@@ -109,31 +163,26 @@ Returns:
     and any error that occurred
 */
 func (statement *Statement) walkNode(q *Query) func(node sqlparser.SQLNode) (bool, error) {
-	unhandledTypes := make(map[string]bool)
-
 	return func(node sqlparser.SQLNode) (bool, error) {
 		switch node := node.(type) {
 		case sqlparser.TableName:
 			q = statement.parseTable(q, node)
 		case *sqlparser.Select:
 			q = statement.handleSelectNode(q, node)
-		case sqlparser.SelectExprs:
-			q = statement.parseSelect(q, node)
 		case *sqlparser.Where:
 			q = statement.parseWhere(q, node)
 		case *sqlparser.Limit:
 			q = statement.parseLimit(q, node)
 		case *sqlparser.FuncExpr:
 			q = statement.parseFunc(q, node)
-		case *sqlparser.JoinTableExpr:
-			q = statement.parseJoin(q, node)
-		case *sqlparser.AliasedExpr:
-			statement.handleAliasedExpr(q, node)
-		case sqlparser.TableExprs:
-			// No-op
-		default:
-			nodeType := reflect.TypeOf(node).String()
-			unhandledTypes[nodeType] = true
+		case *sqlparser.Subquery:
+			// Subqueries are built as statements of their own by the clause they are part of
+			return false, nil
+		case sqlparser.TableExprs, sqlparser.SelectExprs, *sqlparser.JoinTableExpr:
+			// No-op, the FROM clause and SELECT list are processed with their SELECT node
+		case sqlparser.Statement:
+			statement.unsupported(node, "")
+			return false, nil
 		}
 		return true, nil
 	}
@@ -142,7 +191,8 @@ func (statement *Statement) walkNode(q *Query) func(node sqlparser.SQLNode) (boo
 /*
 handleSelectNode processes a SELECT statement node, configuring the Query
 object with the appropriate MongoDB operation type and processing various
-clauses like GROUP BY, HAVING, and ORDER BY.
+clauses like GROUP BY, the SELECT list and ORDER BY. Only the statement's
+own SELECT is processed; subqueries are built as statements of their own.
 
 Parameters:
 - q: The Query object to modify
@@ -152,6 +202,10 @@ Returns:
 - The modified Query object
 */
 func (statement *Statement) handleSelectNode(q *Query, node *sqlparser.Select) *Query {
+	if node != statement.stmt {
+		return q
+	}
+
 	if q.Collection == "" {
 		statement.setupQueryFromClause(q, node.From)
 	}
@@ -160,32 +214,17 @@ func (statement *Statement) handleSelectNode(q *Query, node *sqlparser.Select) *
 	} else if q.Operation == "" {
 		q.Operation = "find"
 	}
-	q = statement.parseGroupBy(q, node.GroupBy, node.Having)
+	q = statement.parseGroupBy(q, node.GroupBy, node.SelectExprs)
+	q = statement.parseSelect(q, node.SelectExprs)
 	return statement.parseOrderBy(q, node.OrderBy)
 }
 
-/*
-handleAliasedExpr processes an aliased expression, particularly handling
-subqueries by recursively building them into the aggregation pipeline.
-
-Parameters:
-- q: The Query object to modify
-- node: The aliased expression node to process
-*/
-func (statement *Statement) handleAliasedExpr(q *Query, node *sqlparser.AliasedExpr) {
-	if subquery, ok := node.Expr.(*sqlparser.Subquery); ok {
-		subQ := NewQuery()
-		subStmt := &Statement{raw: sqlparser.String(subquery.Select)}
-		if _, err := subStmt.Build(subQ); err == nil {
-			q.Pipeline = append(q.Pipeline, subQ.Pipeline...)
-		}
-	}
-}
-
 /*
 finalizeQuery performs final adjustments to the Query object based on the
 SQL statement type and its components. It determines whether the query needs
-to use MongoDB's aggregation framework based on various factors.
+to use MongoDB's aggregation framework based on various factors, and if so
+assembles the pipeline in SQL clause order. SELECT DISTINCT with LIMIT or
+OFFSET is aggregated, as the distinct operation cannot page its values.
 
 Parameters:
 - q: The Query object to finalize
@@ -199,13 +238,19 @@ func (statement *Statement) finalizeQuery(q *Query) (*Query, error) {
 		needsAggregate := len(selectNode.GroupBy) > 0 ||
 			selectNode.Having != nil ||
 			len(selectNode.OrderBy) > 0 ||
-			len(selectNode.From) > 1
+			len(selectNode.From) > 1 ||
+			(selectNode.Distinct != "" && (len(q.Projection) != 1 || q.Projection[0].Value != 1 || selectNode.Limit != nil))
 
 		if needsAggregate && q.Operation != "count" {
 			q.Operation = "aggregate"
 		} else if q.Operation == "" {
 			q.Operation = "find"
 		}
+
+		if q.Operation == "aggregate" {
+			statement.addHavingClause(q, selectNode.Having)
+			q.Pipeline = append(q.Pipeline, statement.assemblePipeline(q, selectNode)...)
+		}
 	}
 	return q, nil
 }
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"strings" // Import the strings package
 	"testing"
+	"time"
 	"unicode"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var err error
@@ -127,102 +129,94 @@ var stmts = []map[string]interface{}{{
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "users",
-	"pipeline": []bson.M{
-		{mongoLookup: bson.M{
-			"from":         "profiles",
-			"localField":   "id",
-			"foreignField": "user_id",
-			"as":           "user_profile",
-		}},
-		{mongoMatch: bson.M{
-			"age": bson.M{"$gt": 25},
-		}},
-		{mongoProject: bson.M{
-			"name": 1,
-			"city": "$user_profile.city",
-		}},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoLookup, Value: bson.D{
+			{Key: "from", Value: "profiles"},
+			{Key: "localField", Value: "id"},
+			{Key: "foreignField", Value: "user_id"},
+			{Key: "as", Value: "p"},
+		}}},
+		{{Key: "$unwind", Value: "$p"}},
+		{{Key: mongoMatch, Value: bson.D{{Key: "age", Value: bson.M{"$gt": int64(25)}}}}},
+		{{Key: mongoProject, Value: bson.D{{Key: "name", Value: 1}, {Key: "p.city", Value: 1}}}},
 	},
 }, {
 	"sql":        "SELECT u.name, COUNT(o.id) AS order_count FROM users u LEFT JOIN orders o ON u.id = o.user_id WHERE u.age > 25 GROUP BY u.id HAVING COUNT(o.id) > 5 ORDER BY order_count DESC LIMIT 10",
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "users",
-	"pipeline": []bson.M{
-		{mongoLookup: bson.M{
-			"from":         "orders",
-			"localField":   "id",
-			"foreignField": "user_id",
-			"as":           "orders",
-		}},
-		{mongoMatch: bson.M{"age": bson.M{"$gt": 25}}},
-		{mongoGroup: bson.M{
-			"_id":         "$id",
-			"name":        bson.M{mongoFirst: "$name"},
-			"order_count": bson.M{"$sum": bson.M{"$size": "$orders"}},
-		}},
-		{mongoMatch: bson.M{"order_count": bson.M{"$gt": 5}}},
-		{"$sort": bson.M{"order_count": -1}},
-		{"$limit": 10},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoLookup, Value: bson.D{
+			{Key: "from", Value: "orders"},
+			{Key: "localField", Value: "id"},
+			{Key: "foreignField", Value: "user_id"},
+			{Key: "as", Value: "o"},
+		}}},
+		{{Key: "$unwind", Value: bson.D{{Key: "path", Value: "$o"}, {Key: "preserveNullAndEmptyArrays", Value: true}}}},
+		{{Key: mongoMatch, Value: bson.D{{Key: "age", Value: bson.M{"$gt": int64(25)}}}}},
+		{{Key: mongoGroup, Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "id", Value: "$id"}}},
+			{Key: "order_count", Value: bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$o.id", nil}}, 1, 0}}}},
+			{Key: "name", Value: bson.M{mongoFirst: "$name"}},
+		}}},
+		{{Key: mongoMatch, Value: bson.M{"order_count": bson.M{"$gt": int64(5)}}}},
+		{{Key: mongoProject, Value: bson.D{{Key: "name", Value: 1}, {Key: "order_count", Value: 1}, {Key: "_id", Value: 0}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "order_count", Value: -1}}}},
+		{{Key: "$limit", Value: int64(10)}},
 	},
 }, {
 	"sql":        "SELECT p.name, c.name AS category_name FROM products p INNER JOIN categories c ON p.category_id = c.id WHERE p.price > 100 AND c.name IN ('Electronics', 'Books') ORDER BY p.price DESC",
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "products",
-	"pipeline": []bson.M{
-		{mongoLookup: bson.M{
-			"from":         "categories",
-			"localField":   "category_id",
-			"foreignField": "id",
-			"as":           "category",
-		}},
-		{"$unwind": "$category"},
-		{mongoMatch: bson.M{
-			"price":         bson.M{"$gt": 100},
-			"category.name": bson.M{"$in": []string{"Electronics", "Books"}},
-		}},
-		{mongoProject: bson.M{
-			"name":          1,
-			"category_name": "$category.name",
-			"price":         1,
-		}},
-		{"$sort": bson.M{"price": -1}},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoLookup, Value: bson.D{
+			{Key: "from", Value: "categories"},
+			{Key: "localField", Value: "category_id"},
+			{Key: "foreignField", Value: "id"},
+			{Key: "as", Value: "c"},
+		}}},
+		{{Key: "$unwind", Value: "$c"}},
+		{{Key: mongoMatch, Value: bson.D{
+			{Key: "price", Value: bson.M{"$gt": int64(100)}},
+			{Key: "c.name", Value: bson.M{"$in": []interface{}{"Electronics", "Books"}}},
+		}}},
+		{{Key: mongoProject, Value: bson.D{{Key: "name", Value: 1}, {Key: "category_name", Value: "$c.name"}, {Key: "price", Value: 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "price", Value: -1}}}},
+		{{Key: "$unset", Value: []string{"price"}}},
 	},
 }, {
-	"sql":        "SELECT department, AVG(salary) AS avg_salary FROM employees WHERE hire_date >= '2020-01-01' GROUP BY department HAVING AVG(salary) > 50000",
+	"sql":        "SELECT department, AVG(salary) AS avg_salary FROM employees WHERE hire_date >= DATE '2020-01-01' GROUP BY department HAVING AVG(salary) > 50000",
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "employees",
-	"pipeline": []bson.M{
-		{mongoMatch: bson.M{"hire_date": bson.M{"$gte": "2020-01-01"}}},
-		{mongoGroup: bson.M{
-			"_id":        refDepartment,
-			"avg_salary": bson.M{"$avg": refSalary},
-		}},
-		{mongoMatch: bson.M{"avg_salary": bson.M{"$gt": 50000}}},
-		{mongoProject: bson.M{
-			"department": refDepartment,
-			"avg_salary": 1,
-			"_id":        0,
-		}},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoMatch, Value: bson.D{{Key: "hire_date", Value: bson.M{"$gte": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}}}}},
+		{{Key: mongoGroup, Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "department", Value: refDepartment}}},
+			{Key: "avg_salary", Value: bson.M{"$avg": refSalary}},
+		}}},
+		{{Key: mongoMatch, Value: bson.M{"avg_salary": bson.M{"$gt": int64(50000)}}}},
+		{{Key: mongoProject, Value: bson.D{{Key: "department", Value: "$_id.department"}, {Key: "avg_salary", Value: 1}, {Key: "_id", Value: 0}}}},
 	},
 }, {
 	"sql":        "SELECT u.name, (SELECT COUNT(*) FROM orders o WHERE o.user_id = u.id) AS order_count FROM users u WHERE u.status = 'active'",
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "users",
-	"pipeline": []bson.M{
-		{mongoMatch: bson.M{"status": "active"}},
-		{mongoLookup: bson.M{
-			"from":         "orders",
-			"localField":   "id",
-			"foreignField": "user_id",
-			"as":           "orders",
-		}},
-		{mongoProject: bson.M{
-			"name":        1,
-			"order_count": bson.M{"$size": "$orders"},
-		}},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoMatch, Value: bson.D{{Key: "status", Value: "active"}}}},
+		{{Key: mongoLookup, Value: bson.D{
+			{Key: "from", Value: "orders"},
+			{Key: "let", Value: bson.D{{Key: "local_id", Value: "$id"}}},
+			{Key: "pipeline", Value: bson.A{
+				bson.D{{Key: mongoMatch, Value: bson.D{{Key: "$expr", Value: bson.M{"$eq": bson.A{"$user_id", "$$local_id"}}}}}},
+				bson.D{{Key: mongoProject, Value: bson.D{{Key: "_id", Value: 1}}}},
+			}},
+			{Key: "as", Value: "order_count"},
+		}}},
+		{{Key: "$addFields", Value: bson.D{{Key: "order_count", Value: bson.M{"$size": "$order_count"}}}}},
+		{{Key: mongoProject, Value: bson.D{{Key: "name", Value: 1}, {Key: "order_count", Value: 1}}}},
 	},
 }, {
 	"sql":        "SELECT * FROM products WHERE name LIKE '%phone%' AND (category = 'Electronics' OR category = 'Accessories') AND price BETWEEN 100 AND 500",
@@ -230,12 +224,12 @@ var stmts = []map[string]interface{}{{
 	"operation":  "find",
 	"collection": "products",
 	"filter": bson.D{
-		{Key: "name", Value: bson.M{"$regex": ".*phone.*", "$options": "i"}},
-		{Key: "$or", Value: []bson.M{
-			{"category": "Electronics"},
-			{"category": "Accessories"},
+		{Key: "name", Value: bson.M{"$regex": "phone"}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "category", Value: "Electronics"}},
+			bson.D{{Key: "category", Value: "Accessories"}},
 		}},
-		{Key: "price", Value: bson.M{"$gte": 100, "$lte": 500}},
+		{Key: "price", Value: bson.D{{Key: "$gte", Value: int64(100)}, {Key: "$lte", Value: int64(500)}}},
 	},
 }, {
 	"sql":        "SELECT * FROM questions WHERE theme.nl = 'Some Theme'",
@@ -248,99 +242,163 @@ var stmts = []map[string]interface{}{{
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "employees",
-	"pipeline": []bson.M{
-		{mongoGroup: bson.M{
-			"_id":        refDepartment,
-			"emp_count":  bson.M{"$sum": 1},
-			"department": bson.M{mongoFirst: refDepartment},
-		}},
-		{"$sort": bson.M{"emp_count": -1}},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoGroup, Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "department", Value: refDepartment}}},
+			{Key: "emp_count", Value: bson.M{"$sum": 1}},
+		}}},
+		{{Key: mongoProject, Value: bson.D{{Key: "department", Value: "$_id.department"}, {Key: "emp_count", Value: 1}, {Key: "_id", Value: 0}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "emp_count", Value: -1}}}},
 	},
 }, {
 	"sql":        "SELECT category, AVG(price) as avg_price FROM products GROUP BY category HAVING avg_price > 100",
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "products",
-	"pipeline": []bson.M{
-		{mongoGroup: bson.M{
-			"_id":       refCategory,
-			"avg_price": bson.M{"$avg": refPrice},
-			"category":  bson.M{mongoFirst: refCategory},
-		}},
-		{mongoMatch: bson.M{"avg_price": bson.M{"$gt": 100}}},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoGroup, Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "category", Value: refCategory}}},
+			{Key: "avg_price", Value: bson.M{"$avg": refPrice}},
+		}}},
+		{{Key: mongoMatch, Value: bson.M{"avg_price": bson.M{"$gt": int64(100)}}}},
+		{{Key: mongoProject, Value: bson.D{{Key: "category", Value: "$_id.category"}, {Key: "avg_price", Value: 1}, {Key: "_id", Value: 0}}}},
 	},
 }, {
 	"sql":        "SELECT category, MIN(price) as min_price, MAX(price) as max_price, AVG(price) as avg_price FROM products GROUP BY category",
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "products",
-	"pipeline": []bson.M{
-		{mongoGroup: bson.M{
-			"_id":       refCategory,
-			"category":  bson.M{mongoFirst: refCategory},
-			"min_price": bson.M{"$min": refPrice},
-			"max_price": bson.M{"$max": refPrice},
-			"avg_price": bson.M{"$avg": refPrice},
-		}},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoGroup, Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "category", Value: refCategory}}},
+			{Key: "min_price", Value: bson.M{"$min": refPrice}},
+			{Key: "max_price", Value: bson.M{"$max": refPrice}},
+			{Key: "avg_price", Value: bson.M{"$avg": refPrice}},
+		}}},
+		{{Key: mongoProject, Value: bson.D{
+			{Key: "category", Value: "$_id.category"},
+			{Key: "min_price", Value: 1},
+			{Key: "max_price", Value: 1},
+			{Key: "avg_price", Value: 1},
+			{Key: "_id", Value: 0},
+		}}},
 	},
 }, {
 	"sql":        "SELECT department, SUM(salary) as total_salary, COUNT(DISTINCT employee_id) as emp_count FROM payroll GROUP BY department HAVING total_salary > 1000000",
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "payroll",
-	"pipeline": []bson.M{
-		{mongoGroup: bson.M{
-			"_id":          refDepartment,
-			"department":   bson.M{mongoFirst: refDepartment},
-			"total_salary": bson.M{"$sum": refSalary},
-			"emp_count":    bson.M{"$addToSet": "$employee_id"},
-		}},
-		{mongoProject: bson.M{
-			"department":   1,
-			"total_salary": 1,
-			"emp_count":    bson.M{"$size": "$emp_count"},
-		}},
-		{mongoMatch: bson.M{"total_salary": bson.M{"$gt": 1000000}}},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoGroup, Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "department", Value: refDepartment}}},
+			{Key: "total_salary", Value: bson.M{"$sum": refSalary}},
+			{Key: "emp_count", Value: bson.M{"$addToSet": "$employee_id"}},
+		}}},
+		{{Key: "$set", Value: bson.D{{Key: "emp_count", Value: bson.M{"$size": bson.M{"$setDifference": bson.A{"$emp_count", bson.A{nil}}}}}}}},
+		{{Key: mongoMatch, Value: bson.M{"total_salary": bson.M{"$gt": int64(1000000)}}}},
+		{{Key: mongoProject, Value: bson.D{
+			{Key: "department", Value: "$_id.department"},
+			{Key: "total_salary", Value: 1},
+			{Key: "emp_count", Value: 1},
+			{Key: "_id", Value: 0},
+		}}},
 	},
 }, {
 	"sql":        "SELECT COUNT(*) as total FROM users",
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "users",
-	"pipeline": []bson.M{
-		{mongoGroup: bson.M{
-			"_id":   nil,
-			"total": bson.M{"$sum": 1},
-		}},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoGroup, Value: bson.D{{Key: "_id", Value: nil}, {Key: "total", Value: bson.M{"$sum": 1}}}}},
+		{{Key: mongoProject, Value: bson.D{{Key: "total", Value: 1}, {Key: "_id", Value: 0}}}},
 	},
 }, {
 	"sql":        "SELECT COUNT(DISTINCT user_id) as unique_users FROM events",
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "events",
-	"pipeline": []bson.M{
-		{mongoGroup: bson.M{
-			"_id":  nil,
-			"temp": bson.M{"$addToSet": "$user_id"},
-		}},
-		{mongoProject: bson.M{
-			"unique_users": bson.M{"$size": "$temp"},
-		}},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoGroup, Value: bson.D{{Key: "_id", Value: nil}, {Key: "unique_users", Value: bson.M{"$addToSet": "$user_id"}}}}},
+		{{Key: "$set", Value: bson.D{{Key: "unique_users", Value: bson.M{"$size": bson.M{"$setDifference": bson.A{"$unique_users", bson.A{nil}}}}}}}},
+		{{Key: mongoProject, Value: bson.D{{Key: "unique_users", Value: 1}, {Key: "_id", Value: 0}}}},
 	},
 }, {
 	"sql":        "SELECT department, MIN(salary) as min_sal, MAX(salary) as max_sal, AVG(salary) as avg_sal FROM employees GROUP BY department",
 	"error":      nil,
 	"operation":  "aggregate",
 	"collection": "employees",
-	"pipeline": []bson.M{
-		{mongoGroup: bson.M{
-			"_id":        refDepartment,
-			"department": bson.M{mongoFirst: refDepartment},
-			"min_sal":    bson.M{"$min": refSalary},
-			"max_sal":    bson.M{"$max": refSalary},
-			"avg_sal":    bson.M{"$avg": refSalary},
+	"pipeline": mongo.Pipeline{
+		{{Key: mongoGroup, Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "department", Value: refDepartment}}},
+			{Key: "min_sal", Value: bson.M{"$min": refSalary}},
+			{Key: "max_sal", Value: bson.M{"$max": refSalary}},
+			{Key: "avg_sal", Value: bson.M{"$avg": refSalary}},
+		}}},
+		{{Key: mongoProject, Value: bson.D{
+			{Key: "department", Value: "$_id.department"},
+			{Key: "min_sal", Value: 1},
+			{Key: "max_sal", Value: 1},
+			{Key: "avg_sal", Value: 1},
+			{Key: "_id", Value: 0},
+		}}},
+	},
+}, {
+	"sql":        "SELECT * FROM users WHERE status NOT IN ('deleted', 'banned')",
+	"error":      nil,
+	"operation":  "find",
+	"collection": "users",
+	"filter": bson.D{
+		{Key: "status", Value: bson.M{"$nin": []interface{}{"deleted", "banned"}}},
+	},
+}, {
+	"sql":        "SELECT * FROM products WHERE name NOT LIKE '%phone%'",
+	"error":      nil,
+	"operation":  "find",
+	"collection": "products",
+	"filter": bson.D{
+		{Key: "name", Value: bson.M{"$not": primitive.Regex{Pattern: "phone"}}},
+	},
+}, {
+	"sql":        "SELECT * FROM accounts WHERE Deleted IS NULL AND verified IS TRUE",
+	"error":      nil,
+	"operation":  "find",
+	"collection": "accounts",
+	"filter": bson.D{
+		{Key: "Deleted", Value: nil},
+		{Key: "verified", Value: true},
+	},
+}, {
+	"sql":        "SELECT * FROM accounts WHERE email IS NOT NULL AND blocked IS NOT TRUE",
+	"error":      nil,
+	"operation":  "find",
+	"collection": "accounts",
+	"filter": bson.D{
+		{Key: "email", Value: bson.M{"$ne": nil}},
+		{Key: "blocked", Value: bson.M{"$ne": true}},
+	},
+}, {
+	"sql":        "SELECT * FROM products WHERE price NOT BETWEEN 100 AND 500",
+	"error":      nil,
+	"operation":  "find",
+	"collection": "products",
+	"filter": bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "price", Value: bson.M{"$lt": int64(100)}}},
+			bson.D{{Key: "price", Value: bson.M{"$gt": int64(500)}}},
 		}},
 	},
+}, {
+	"sql":        "SELECT * FROM users WHERE NOT (status = 'active' AND role = 'admin') AND name != 'root'",
+	"error":      nil,
+	"operation":  "find",
+	"collection": "users",
+	"filter": bson.D{
+		{Key: "$nor", Value: bson.A{bson.D{
+			{Key: "status", Value: "active"},
+			{Key: "role", Value: "admin"},
+		}}},
+		{Key: "name", Value: bson.M{"$ne": "root"}},
+	},
 }, // Add this comma
 } // Close the outer slice
 
@@ -399,6 +457,7 @@ func (tc *testCase) testValidSQL() {
 			tc.assertCollection()
 			tc.assertFilter()
 			tc.assertProjection()
+			tc.assertPipeline()
 			tc.assertLimitAndOffset()
 		}
 	})
@@ -462,6 +521,14 @@ func (tc *testCase) assertProjection() {
 	}
 }
 
+func (tc *testCase) assertPipeline() {
+	if pipeline, ok := tc.stmt["pipeline"].(mongo.Pipeline); ok {
+		Convey(fmt.Sprintf("[%d] should run the pipeline %v", tc.idx, pipeline), func() {
+			So(tc.q.Pipeline, ShouldResemble, pipeline)
+		})
+	}
+}
+
 func (tc *testCase) assertLimitAndOffset() {
 	tc.assertLimit()
 	tc.assertOffset()
@@ -0,0 +1,72 @@
+package squeel
+
+import (
+	"fmt"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+/*
+UnsupportedError is returned by Build in strict mode when a statement contains
+SQL that cannot be translated. Without strict mode the unsupported part would
+be left out of the query, which for a WHERE clause means matching every
+document of the collection.
+*/
+type UnsupportedError struct {
+	Node string // The type of the unsupported AST node, such as *sqlparser.FuncExpr
+	Name string // The unsupported operator or function, if any
+	SQL  string // The SQL text of the unsupported node
+}
+
+/*
+Error describes the unsupported node, naming its operator or function when
+it has one.
+
+Returns:
+- The error message
+*/
+func (err *UnsupportedError) Error() string {
+	if err.Name != "" {
+		return fmt.Sprintf("unsupported %s %q in %s", err.Node, err.Name, err.SQL)
+	}
+	return fmt.Sprintf("unsupported %s: %s", err.Node, err.SQL)
+}
+
+/*
+WithStrict sets whether Build rejects SQL it cannot translate. Strict mode is
+on by default. When it is off, unsupported parts of a statement are logged
+and left out of the query.
+
+Parameters:
+- strict: Whether unsupported SQL is an error
+
+Returns:
+- The option to pass to NewStatement
+*/
+func WithStrict(strict bool) StatementOption {
+	return func(statement *Statement) {
+		statement.strict = strict
+	}
+}
+
+/*
+unsupported records a node that cannot be translated. The node is always
+logged; in strict mode the first unsupported node also becomes the error
+returned by Build.
+
+Parameters:
+- node: The unsupported node
+- name: The unsupported operator or function, or empty if there is none
+*/
+func (statement *Statement) unsupported(node sqlparser.SQLNode, name string) {
+	err := &UnsupportedError{Node: fmt.Sprintf("%T", node), Name: name}
+	if node != nil {
+		err.SQL = sqlparser.String(node)
+	}
+
+	logDebug("%s", err)
+
+	if statement.strict && statement.unsupportedErr == nil {
+		statement.unsupportedErr = err
+	}
+}
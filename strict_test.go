@@ -0,0 +1,62 @@
+package squeel
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStrict(t *testing.T) {
+	Convey("Given SQL that cannot be translated", t, func() {
+		Convey("It should name the unsupported function", func() {
+			_, err := NewStatement("SELECT * FROM users WHERE UNKNOWN_FN(x)").Build(NewQuery())
+
+			var unsupported *UnsupportedError
+			So(errors.As(err, &unsupported), ShouldBeTrue)
+			So(unsupported, ShouldResemble, &UnsupportedError{
+				Node: "*sqlparser.FuncExpr",
+				Name: "UNKNOWN_FN",
+				SQL:  "UNKNOWN_FN(x)",
+			})
+		})
+
+		Convey("It should name the unsupported operator", func() {
+			_, err := NewStatement("SELECT * FROM users WHERE a <=> b").Build(NewQuery())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `unsupported *sqlparser.ComparisonExpr "<=>" in a <=> b`)
+		})
+
+		Convey("It should reject unsupported clauses and statements", func() {
+			for _, sql := range []string{
+				"SELECT name FROM users UNION SELECT name FROM admins",
+				"SELECT * FROM users, orders",
+				"SELECT * FROM users WHERE age BETWEEN low AND 2",
+				"SELECT * FROM users WHERE COUNT(*) > 1",
+				"SELECT SOUNDEX(name) FROM users",
+				"SELECT name FROM users ORDER BY SOUNDEX(name)",
+				"SELECT name FROM users GROUP BY name HAVING name LIKE 'a%'",
+				"SELECT * FROM users u JOIN orders o ON UNKNOWN_FN(o.x)",
+				"SELECT name, (SELECT COUNT(*) FROM orders WHERE UNKNOWN_FN(x)) AS n FROM users",
+			} {
+				_, err := NewStatement(sql).Build(NewQuery())
+				So(err, ShouldHaveSameTypeAs, &UnsupportedError{})
+			}
+		})
+
+		Convey("It should leave unsupported SQL out when strict mode is off", func() {
+			q, err := NewStatement("SELECT * FROM users WHERE UNKNOWN_FN(x) AND name = 'ann'", WithStrict(false)).Build(NewQuery())
+			So(err, ShouldBeNil)
+			So(q.Filter, ShouldResemble, bson.D{{Key: "name", Value: "ann"}})
+		})
+	})
+
+	Convey("Given SQL that can be translated", t, func() {
+		Convey("It should match IN lists", func() {
+			q, err := NewStatement("SELECT * FROM users WHERE name IN ('ann', 'bob')").Build(NewQuery())
+			So(err, ShouldBeNil)
+			So(q.Filter, ShouldResemble, bson.D{{Key: "name", Value: bson.M{"$in": []interface{}{"ann", "bob"}}}})
+		})
+	})
+}
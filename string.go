@@ -0,0 +1,169 @@
+package squeel
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+stringFunctions maps the MySQL string functions onto the aggregation
+operators that compute them. Positions count from 1, as in MySQL, and
+LOCATE and INSTR return 0 when the substring is not found.
+*/
+var stringFunctions = map[string]scalarFunction{
+	"upper":            unaryString("$toUpper"),
+	"ucase":            unaryString("$toUpper"),
+	"lower":            unaryString("$toLower"),
+	"lcase":            unaryString("$toLower"),
+	"trim":             trimFunction("$trim"),
+	"ltrim":            trimFunction("$ltrim"),
+	"rtrim":            trimFunction("$rtrim"),
+	"length":           lengthFunction("$strLenBytes"),
+	"char_length":      lengthFunction("$strLenCP"),
+	"character_length": lengthFunction("$strLenCP"),
+	"concat": {
+		params:   []valueType{stringType},
+		variadic: true,
+		result:   stringType,
+		compile: func(args bson.A) interface{} {
+			return bson.M{"$concat": args}
+		},
+	},
+	"substring": substringFunction,
+	"substr":    substringFunction,
+	"mid":       substringFunction,
+	"replace": {
+		params: []valueType{stringType, stringType, stringType},
+		result: stringType,
+		compile: func(args bson.A) interface{} {
+			return bson.M{"$replaceAll": bson.D{
+				{Key: "input", Value: args[0]},
+				{Key: "find", Value: args[1]},
+				{Key: "replacement", Value: args[2]},
+			}}
+		},
+	},
+	"split": {
+		params: []valueType{stringType, stringType},
+		result: arrayType,
+		compile: func(args bson.A) interface{} {
+			return bson.M{"$split": args}
+		},
+	},
+	"locate": {
+		params:   []valueType{stringType, stringType, numberType},
+		optional: 1,
+		result:   numberType,
+		check:    checkPositions(2),
+		compile: func(args bson.A) interface{} {
+			search := bson.A{args[1], args[0]}
+			if len(args) == 3 {
+				search = append(search, zeroBased(args[2]))
+			}
+			return bson.M{"$add": bson.A{bson.M{"$indexOfCP": search}, int64(1)}}
+		},
+	},
+	"instr": {
+		params: []valueType{stringType, stringType},
+		result: numberType,
+		compile: func(args bson.A) interface{} {
+			return bson.M{"$add": bson.A{bson.M{"$indexOfCP": args}, int64(1)}}
+		},
+	},
+}
+
+/*
+substringFunction compiles SUBSTRING(str, pos[, len]) into $substrCP. Without
+a length the rest of the string is taken.
+*/
+var substringFunction = scalarFunction{
+	params:   []valueType{stringType, numberType, numberType},
+	optional: 1,
+	result:   stringType,
+	check:    checkPositions(1),
+	compile: func(args bson.A) interface{} {
+		var length interface{} = bson.M{"$strLenCP": args[0]}
+		if len(args) == 3 {
+			length = args[2]
+		}
+		return bson.M{"$substrCP": bson.A{args[0], zeroBased(args[1]), length}}
+	},
+}
+
+/*
+unaryString returns a string function of a single string, such as UPPER,
+that compiles into the given operator.
+*/
+func unaryString(operator string) scalarFunction {
+	return scalarFunction{
+		params: []valueType{stringType},
+		result: stringType,
+		compile: func(args bson.A) interface{} {
+			return bson.M{operator: args[0]}
+		},
+	}
+}
+
+/*
+trimFunction returns TRIM, LTRIM or RTRIM. MySQL only removes spaces, so the
+characters to remove are given, rather than every whitespace character.
+*/
+func trimFunction(operator string) scalarFunction {
+	return scalarFunction{
+		params: []valueType{stringType},
+		result: stringType,
+		compile: func(args bson.A) interface{} {
+			return bson.M{operator: bson.D{{Key: "input", Value: args[0]}, {Key: "chars", Value: " "}}}
+		},
+	}
+}
+
+/*
+lengthFunction returns LENGTH, which counts bytes as in MySQL, or
+CHAR_LENGTH, which counts characters.
+*/
+func lengthFunction(operator string) scalarFunction {
+	return scalarFunction{
+		params: []valueType{stringType},
+		result: numberType,
+		compile: func(args bson.A) interface{} {
+			return bson.M{operator: args[0]}
+		},
+	}
+}
+
+/*
+checkPositions returns a check that the literal arguments from the given
+index on, a position and a length, are whole numbers and that a position
+counts from 1.
+*/
+func checkPositions(from int) func(args bson.A) string {
+	return func(args bson.A) string {
+		for i := from; i < len(args); i++ {
+			switch arg := args[i].(type) {
+			case float64:
+				return fmt.Sprintf("argument %d must be a whole number", i+1)
+			case int64:
+				if i == from && arg < 1 {
+					return "positions count from 1"
+				}
+				if arg < 0 {
+					return "a length cannot be negative"
+				}
+			}
+		}
+		return ""
+	}
+}
+
+/*
+zeroBased converts a position that counts from 1, as in SQL, into an index
+that counts from 0, as the aggregation operators take.
+*/
+func zeroBased(position interface{}) interface{} {
+	if position, ok := position.(int64); ok {
+		return position - 1
+	}
+	return bson.M{"$subtract": bson.A{position, int64(1)}}
+}
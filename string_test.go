@@ -0,0 +1,96 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStringFunctions(t *testing.T) {
+	Convey("Given SQL with string functions", t, func() {
+		evaluator := newEvaluator(map[string][]interface{}{
+			"people": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "first", Value: "Ann"}, {Key: "last", Value: "Smith"}, {Key: "email", Value: " ann@example.com "}, {Key: "tags", Value: "a,b"}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "first", Value: "bob"}, {Key: "last", Value: "Jones"}, {Key: "email", Value: "bob@test.org"}, {Key: "tags", Value: "c"}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "first", Value: "Cid"}, {Key: "last", Value: "Smith"}, {Key: "email", Value: "cid@example.com"}, {Key: "tags", Value: ""}},
+			},
+		})
+
+		Convey("It should project string functions", func() {
+			q := build("SELECT _id, UPPER(first) AS upper, CONCAT(LOWER(first), '.', last) AS handle, SUBSTRING(last, 2, 3) AS part, " +
+				"TRIM(email) AS email, CHAR_LENGTH(last) AS len, REPLACE(email, '@', ' at ') AS spoken, SPLIT(tags, ',') AS tags " +
+				"FROM people WHERE _id = 1")
+			So(q.Projection, ShouldResemble, bson.D{
+				{Key: "_id", Value: 1},
+				{Key: "upper", Value: bson.M{"$toUpper": "$first"}},
+				{Key: "handle", Value: bson.M{"$concat": bson.A{bson.M{"$toLower": "$first"}, ".", "$last"}}},
+				{Key: "part", Value: bson.M{"$substrCP": bson.A{"$last", int64(1), int64(3)}}},
+				{Key: "email", Value: bson.M{"$trim": bson.D{{Key: "input", Value: "$email"}, {Key: "chars", Value: " "}}}},
+				{Key: "len", Value: bson.M{"$strLenCP": "$last"}},
+				{Key: "spoken", Value: bson.M{"$replaceAll": bson.D{
+					{Key: "input", Value: "$email"},
+					{Key: "find", Value: "@"},
+					{Key: "replacement", Value: " at "},
+				}}},
+				{Key: "tags", Value: bson.M{"$split": bson.A{"$tags", ","}}},
+			})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{
+				"_id":    int32(1),
+				"upper":  "ANN",
+				"handle": "ann.Smith",
+				"part":   "mit",
+				"email":  "ann@example.com",
+				"len":    int32(5),
+				"spoken": " ann at example.com ",
+				"tags":   bson.A{"a", "b"},
+			}})
+		})
+
+		Convey("It should take the rest of the string without a length", func() {
+			q := build("SELECT _id, SUBSTR(email, 5) AS domain FROM people WHERE _id = 2")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2), "domain": "test.org"}})
+		})
+
+		Convey("It should compare string functions with $expr", func() {
+			q := build("SELECT _id FROM people WHERE LOWER(first) = 'bob' OR LOCATE('example', email) > 1")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "$expr", Value: bson.M{"$eq": bson.A{bson.M{"$toLower": "$first"}, "bob"}}}},
+				bson.D{{Key: "$expr", Value: bson.M{"$gt": bson.A{
+					bson.M{"$add": bson.A{bson.M{"$indexOfCP": bson.A{"$email", "example"}}, int64(1)}},
+					int64(1),
+				}}}},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}, {"_id": int32(2)}, {"_id": int32(3)}})
+
+			q = build("SELECT _id FROM people WHERE INSTR(email, '@test') = 0 AND UPPER(first) IN ('ANN', 'BOB')")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}})
+		})
+
+		Convey("It should group and sort on string functions", func() {
+			q := build("SELECT UPPER(last) AS family, COUNT(*) AS n FROM people GROUP BY family ORDER BY family")
+			So(q.Pipeline[0], ShouldResemble, bson.D{{Key: "$group", Value: bson.D{
+				{Key: "_id", Value: bson.D{{Key: "family", Value: bson.M{"$toUpper": "$last"}}}},
+				{Key: "n", Value: bson.M{"$sum": 1}},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"family": "JONES", "n": int32(1)}, {"family": "SMITH", "n": int32(2)}})
+
+			q = build("SELECT _id FROM people ORDER BY CHAR_LENGTH(TRIM(email)) DESC, _id")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}, {"_id": int32(3)}, {"_id": int32(2)}})
+		})
+
+		Convey("It should check the arguments when the statement is built", func() {
+			for sql, message := range map[string]string{
+				"SELECT UPPER(email, tags) AS x FROM people":             "invalid arguments to UPPER in UPPER(email, tags): expected 1 argument, got 2",
+				"SELECT SUBSTRING(email, 'x') AS x FROM people":          "invalid arguments to SUBSTRING in substr(email, 'x'): argument 2 must be a number, not a string",
+				"SELECT SUBSTRING(email, 0, 2) AS x FROM people":         "invalid arguments to SUBSTRING in substr(email, 0, 2): positions count from 1",
+				"SELECT _id FROM people WHERE CONCAT(email, 1) = 'x'":    "invalid arguments to CONCAT in CONCAT(email, 1): argument 2 must be a string, not a number",
+				"SELECT _id FROM people WHERE UPPER(CHAR_LENGTH(x)) = 1": "invalid arguments to UPPER in UPPER(CHAR_LENGTH(x)): argument 1 must be a string, not a number",
+			} {
+				_, err := NewStatement(sql).Build(NewQuery())
+				So(err, ShouldHaveSameTypeAs, &ArgumentError{})
+				So(err.Error(), ShouldEqual, message)
+			}
+		})
+	})
+}
@@ -0,0 +1,378 @@
+package squeel
+
+import (
+	"fmt"
+
+	"github.com/xwb1989/sqlparser"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+subqueryLookup is a subquery compiled into a pipeline-style $lookup. The
+conditions of its WHERE clause that refer to tables of the outer statement
+become a $match on let variables, and the rest of the subquery is built as a
+statement of its own, whose stages follow that $match.
+*/
+type subqueryLookup struct {
+	node      *sqlparser.Subquery
+	condition *joinCondition // Compiles the correlated conditions and binds their let variables
+	query     *Query         // The subquery built without its correlated conditions
+	stages    bson.A         // The stages of the lookup pipeline
+	as        string         // The temporary field the lookup stores its result in
+}
+
+/*
+parseExists processes EXISTS and NOT EXISTS, which become a $lookup of the
+subquery followed by a condition on the size of the looked up array.
+
+Parameters:
+- q: The Query object to modify
+- expr: The EXISTS expression
+- negate: Whether the expression is NOT EXISTS
+
+Returns:
+- The modified Query object with the condition applied
+*/
+func (statement *Statement) parseExists(q *Query, expr *sqlparser.ExistsExpr, negate bool) *Query {
+	lookup, ok := statement.subqueryLookup(q, expr.Subquery)
+	if !ok {
+		return q
+	}
+
+	lookup.stages = append(lookup.stages, bson.D{{Key: "$limit", Value: 1}})
+	return statement.semiJoin(q, lookup, negate)
+}
+
+/*
+parseInSubquery processes column IN (SELECT ...) and NOT IN, which become a
+$lookup of the subquery that also matches the selected column against the
+outer column, followed by a condition on the size of the looked up array.
+
+Parameters:
+- q: The Query object to modify
+- expr: The IN comparison, whose right side is the subquery
+
+Returns:
+- The modified Query object with the condition applied
+*/
+func (statement *Statement) parseInSubquery(q *Query, expr *sqlparser.ComparisonExpr) *Query {
+	col, isCol := expr.Left.(*sqlparser.ColName)
+	if !isCol || (expr.Operator != sqlparser.InStr && expr.Operator != sqlparser.NotInStr) {
+		statement.unsupported(expr, expr.Operator)
+		return q
+	}
+
+	lookup, ok := statement.subqueryLookup(q, expr.Right.(*sqlparser.Subquery))
+	if !ok {
+		return q
+	}
+
+	field, ok := selectedField(lookup.query)
+	if !ok {
+		statement.unsupported(lookup.node, expr.Operator)
+		return q
+	}
+
+	variable := lookup.condition.variable(col)
+	lookup.stages = append(lookup.stages,
+		bson.D{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.M{"$eq": bson.A{"$" + field, "$$" + variable}}}}}},
+		bson.D{{Key: "$limit", Value: 1}},
+	)
+
+	return statement.semiJoin(q, lookup, expr.Operator == sqlparser.NotInStr)
+}
+
+/*
+semiJoin adds the $lookup of a subquery to the pipeline and filters on whether
+it found any document. The looked up array is removed again after WHERE.
+
+Parameters:
+- q: The Query object to modify
+- lookup: The compiled subquery
+- negate: Whether rows without a match are kept, rather than rows with one
+
+Returns:
+- The modified Query object with the condition applied
+*/
+func (statement *Statement) semiJoin(q *Query, lookup *subqueryLookup, negate bool) *Query {
+	lookup.stages = append(lookup.stages, bson.D{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}})
+	statement.addLookup(q, lookup, clauseWhere)
+
+	condition := bson.M{"$not": bson.M{"$size": 0}}
+	if negate {
+		condition = bson.M{"$size": 0}
+	}
+
+	q.Filter = append(q.Filter, bson.E{Key: lookup.as, Value: condition})
+	return q
+}
+
+/*
+addLookup adds the $lookup stage of a subquery to the slot of the clause it
+is part of, and switches the query to an aggregation. The results of the
+lookups of WHERE are removed once WHERE has been applied.
+
+Parameters:
+- q: The Query object to modify
+- lookup: The compiled subquery
+- c: The clause the subquery is part of
+*/
+func (statement *Statement) addLookup(q *Query, lookup *subqueryLookup, c clause) {
+	stage := bson.D{{Key: "from", Value: lookup.query.Collection}}
+	if len(lookup.condition.let) > 0 {
+		stage = append(stage, bson.E{Key: "let", Value: lookup.condition.let})
+	}
+	stage = append(stage,
+		bson.E{Key: "pipeline", Value: lookup.stages},
+		bson.E{Key: "as", Value: lookup.as},
+	)
+
+	q.Operation = "aggregate"
+	statement.pipeline.add(c, bson.D{{Key: "$lookup", Value: stage}})
+	if c == clauseWhere {
+		statement.pipeline.temporary = append(statement.pipeline.temporary, lookup.as)
+	}
+}
+
+/*
+subqueryLookup compiles a subquery into the pipeline of a $lookup. The
+conditions of the subquery's WHERE that refer to a table of this statement
+are correlated: their columns of this statement become let variables, as in
+the ON clause of a join. The remaining conditions, and the rest of the
+subquery, are built as a statement of their own.
+
+Parameters:
+- q: The Query object being built
+- node: The subquery
+
+Returns:
+- The compiled subquery
+- Whether the subquery could be compiled
+*/
+func (statement *Statement) subqueryLookup(q *Query, node *sqlparser.Subquery) (*subqueryLookup, bool) {
+	sel, ok := node.Select.(*sqlparser.Select)
+	if !ok {
+		statement.unsupported(node.Select, "")
+		return nil, false
+	}
+
+	inner := map[string]bool{}
+	root := fromTables(sel.From, inner)
+
+	var correlated, uncorrelated []sqlparser.Expr
+	if sel.Where != nil {
+		for _, conjunct := range splitExpr(sel.Where.Expr, true) {
+			outer, ok := statement.correlated(conjunct, inner, root)
+			if !ok {
+				statement.unsupported(conjunct, "")
+				return nil, false
+			}
+			if outer {
+				correlated = append(correlated, conjunct)
+			} else {
+				uncorrelated = append(uncorrelated, conjunct)
+			}
+		}
+	}
+
+	rest := *sel
+	rest.Where = nil
+	if where := joinExprs(uncorrelated); where != nil {
+		rest.Where = sqlparser.NewWhere(sqlparser.WhereStr, where)
+	}
+
+	subQ, err := statement.subStatement(&rest).Build(NewQuery())
+	if err != nil {
+		if statement.unsupportedErr == nil {
+			statement.unsupportedErr = err
+		}
+		return nil, false
+	}
+
+	statement.pipeline.subqueries++
+	lookup := &subqueryLookup{
+		condition: &joinCondition{
+			statement:   statement,
+			joined:      root,
+			collection:  subQ.Collection,
+			fallback:    q.Collection,
+			let:         bson.D{},
+			unqualified: true,
+		},
+		node:   node,
+		query:  subQ,
+		stages: bson.A{},
+		as:     fmt.Sprintf("__subquery_%d", statement.pipeline.subqueries),
+	}
+
+	if where := joinExprs(correlated); where != nil {
+		match, ok := lookup.condition.compile(where)
+		if !ok {
+			statement.unsupported(where, "")
+			return nil, false
+		}
+		lookup.stages = append(lookup.stages, bson.D{{Key: "$match", Value: match}})
+	}
+
+	lookup.stages = append(lookup.stages, subqueryStages(subQ)...)
+	return lookup, true
+}
+
+/*
+correlated reports whether a condition of a subquery refers to a table of
+this statement. Unqualified columns belong to the subquery, as SQL resolves
+them in the innermost scope first. A correlated condition may only refer to
+the first table of the subquery, whose fields are the top-level fields of
+the looked up documents. A column of a table of the statement this one is a
+subquery of cannot be compiled, as it would otherwise be read as a path of
+the looked up documents.
+
+Parameters:
+- expr: The condition of the subquery
+- inner: The names of the tables of the subquery
+- root: The name of the first table of the subquery
+
+Returns:
+- Whether the condition is correlated
+- Whether the condition can be compiled
+*/
+func (statement *Statement) correlated(expr sqlparser.Expr, inner map[string]bool, root string) (bool, bool) {
+	outer, nested, enclosing := false, false, false
+
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.ColName:
+			qualifier := columnQualifier(node)
+			switch {
+			case qualifier != "" && !inner[qualifier] && statement.tables.has(qualifier):
+				outer = true
+			case qualifier != "" && qualifier != root && inner[qualifier]:
+				nested = true
+			case qualifier != "" && !inner[qualifier] && statement.enclosing[qualifier]:
+				enclosing = true
+			}
+		case *sqlparser.Subquery:
+			return false, nil
+		}
+		return true, nil
+	}, expr)
+
+	return outer, !enclosing && (!outer || !nested)
+}
+
+/*
+subqueryStages returns the stages that run a built subquery inside a lookup
+pipeline. An aggregation brings its own pipeline; any other operation is
+turned into the stages for its filter, sort, offset and limit.
+
+Parameters:
+- subQ: The built subquery
+
+Returns:
+- The stages of the subquery
+*/
+func subqueryStages(subQ *Query) bson.A {
+	stages := bson.A{}
+
+	if subQ.Operation == "aggregate" {
+		for _, stage := range subQ.Pipeline {
+			stages = append(stages, stage)
+		}
+		return stages
+	}
+
+	if len(subQ.Filter) > 0 {
+		stages = append(stages, bson.D{{Key: "$match", Value: subQ.Filter}})
+	}
+	if len(subQ.Sort) > 0 {
+		stages = append(stages, bson.D{{Key: "$sort", Value: subQ.Sort}})
+	}
+	if subQ.Offset != nil {
+		stages = append(stages, bson.D{{Key: "$skip", Value: *subQ.Offset}})
+	}
+	if subQ.Limit != nil {
+		stages = append(stages, bson.D{{Key: "$limit", Value: *subQ.Limit}})
+	}
+
+	return stages
+}
+
+/*
+selectedField returns the field a subquery selects, which must be a single
+column or expression.
+
+Parameters:
+- subQ: The built subquery
+
+Returns:
+- The name of the selected field
+- Whether the subquery selects a single field
+*/
+func selectedField(subQ *Query) (string, bool) {
+	fields := make([]string, 0, 1)
+	for _, field := range subQ.Projection {
+		if field.Value != 0 {
+			fields = append(fields, field.Key)
+		}
+	}
+
+	if len(fields) != 1 {
+		return "", false
+	}
+	return fields[0], true
+}
+
+/*
+fromTables collects the names of the tables of a FROM clause.
+
+Parameters:
+- exprs: The FROM clause
+- names: The set to add the names to
+
+Returns:
+- The name of the first table, which the others are joined to
+*/
+func fromTables(exprs sqlparser.TableExprs, names map[string]bool) string {
+	root := ""
+
+	for _, expr := range exprs {
+		_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			switch node := node.(type) {
+			case *sqlparser.AliasedTableExpr:
+				if _, name := tableName(node); name != "" {
+					names[name] = true
+					if root == "" {
+						root = name
+					}
+				}
+				return false, nil
+			case *sqlparser.Subquery:
+				return false, nil
+			}
+			return true, nil
+		}, expr)
+	}
+
+	return root
+}
+
+/*
+joinExprs combines conditions into a single conjunction.
+
+Parameters:
+- exprs: The conditions
+
+Returns:
+- The conjunction, or nil when there are no conditions
+*/
+func joinExprs(exprs []sqlparser.Expr) sqlparser.Expr {
+	var joined sqlparser.Expr
+	for _, expr := range exprs {
+		if joined == nil {
+			joined = expr
+			continue
+		}
+		joined = &sqlparser.AndExpr{Left: joined, Right: expr}
+	}
+	return joined
+}
@@ -0,0 +1,119 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSubquery(t *testing.T) {
+	Convey("Given SQL with subqueries", t, func() {
+		evaluator := newEvaluator(evaluatorFixtures)
+
+		Convey("It should translate IN into a semi-join on the selected column", func() {
+			q := buildAggregate("SELECT _id FROM users WHERE _id IN (SELECT user_id FROM orders WHERE total > 6)")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$lookup", "$match", "$unset", "$project"})
+			So(q.Pipeline[0], ShouldResemble, bson.D{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"},
+				{Key: "let", Value: bson.D{{Key: "local__id", Value: "$_id"}}},
+				{Key: "pipeline", Value: bson.A{
+					bson.D{{Key: "$match", Value: bson.D{{Key: "total", Value: bson.M{"$gt": int64(6)}}}}},
+					bson.D{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.M{"$eq": bson.A{"$user_id", "$$local__id"}}}}}},
+					bson.D{{Key: "$limit", Value: 1}},
+					bson.D{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+				}},
+				{Key: "as", Value: "__subquery_1"},
+			}}})
+			So(q.Filter, ShouldResemble, bson.D{{Key: "__subquery_1", Value: bson.M{"$not": bson.M{"$size": 0}}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}, {"_id": int32(3)}})
+		})
+
+		Convey("It should keep the rows without a match for NOT IN", func() {
+			q := buildAggregate("SELECT _id FROM users WHERE age > 18 AND _id NOT IN (SELECT user_id FROM orders WHERE total > 10)")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(3)}})
+		})
+
+		Convey("It should correlate EXISTS through the columns it refers to", func() {
+			q := buildAggregate("SELECT u._id FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u._id AND o.total > 10)")
+			So(q.Pipeline[0], ShouldResemble, bson.D{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"},
+				{Key: "let", Value: bson.D{{Key: "local__id", Value: "$_id"}}},
+				{Key: "pipeline", Value: bson.A{
+					bson.D{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.M{"$eq": bson.A{"$user_id", "$$local__id"}}}}}},
+					bson.D{{Key: "$match", Value: bson.D{{Key: "total", Value: bson.M{"$gt": int64(10)}}}}},
+					bson.D{{Key: "$limit", Value: 1}},
+					bson.D{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+				}},
+				{Key: "as", Value: "__subquery_1"},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1)}})
+		})
+
+		Convey("It should keep the rows without a match for NOT EXISTS", func() {
+			q := buildAggregate("SELECT _id FROM users u WHERE NOT EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u._id)")
+			So(q.Filter, ShouldResemble, bson.D{{Key: "__subquery_1", Value: bson.M{"$size": 0}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2)}})
+		})
+
+		Convey("It should combine semi-joins with OR", func() {
+			q := buildAggregate("SELECT _id FROM users u WHERE age < 18 OR EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u._id AND o.total = 7)")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(2)}, {"_id": int32(3)}})
+		})
+
+		Convey("It should count the looked up documents of a scalar COUNT(*) subquery", func() {
+			q := buildAggregate("SELECT u.name, (SELECT COUNT(*) FROM orders o WHERE o.user_id = u._id) AS order_count FROM users u")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(1), "name": "ann", "order_count": int32(2)},
+				{"_id": int32(2), "name": "bob", "order_count": int32(0)},
+				{"_id": int32(3), "name": "cid", "order_count": int32(1)},
+			})
+		})
+
+		Convey("It should look up scalar subqueries after WHERE", func() {
+			q := buildAggregate("SELECT u.name, (SELECT COUNT(*) FROM orders o WHERE o.user_id = u._id) AS age FROM users u WHERE age > 18")
+			So(stageNames(q.Pipeline), ShouldResemble, []string{"$match", "$lookup", "$addFields", "$project"})
+			So(q.Pipeline[1], ShouldResemble, bson.D{{Key: "$lookup", Value: bson.D{
+				{Key: "from", Value: "orders"},
+				{Key: "let", Value: bson.D{{Key: "local__id", Value: "$_id"}}},
+				{Key: "pipeline", Value: bson.A{
+					bson.D{{Key: "$match", Value: bson.D{{Key: "$expr", Value: bson.M{"$eq": bson.A{"$user_id", "$$local__id"}}}}}},
+					bson.D{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+				}},
+				{Key: "as", Value: "age"},
+			}}})
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{
+				{"_id": int32(1), "name": "ann", "age": int32(2)},
+				{"_id": int32(3), "name": "cid", "age": int32(1)},
+			})
+		})
+
+		Convey("It should select the value of a scalar subquery", func() {
+			q := buildAggregate("SELECT u.name, (SELECT MAX(o.total) FROM orders o WHERE o.user_id = u._id) AS biggest FROM users u WHERE u._id = 1")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(1), "name": "ann", "biggest": int32(20)}})
+		})
+
+		Convey("It should correlate a nested subquery with the subquery around it", func() {
+			q := buildAggregate("SELECT u._id FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u._id AND " +
+				"EXISTS (SELECT 1 FROM users x WHERE x._id = o.user_id AND x.age > 40))")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(3)}})
+
+			q = buildAggregate("SELECT u._id FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u._id AND " +
+				"EXISTS (SELECT 1 FROM users x WHERE x._id = o.user_id AND address.city = 'Utrecht'))")
+			So(evaluate(evaluator, q), ShouldResemble, []bson.M{{"_id": int32(3)}})
+		})
+
+		Convey("It should reject subqueries that cannot be translated", func() {
+			for _, sql := range []string{
+				"SELECT _id FROM users WHERE _id IN (SELECT user_id, total FROM orders)",
+				"SELECT _id FROM users WHERE age > (SELECT 1 FROM orders)",
+				"SELECT name, (SELECT COUNT(*) FROM orders o WHERE o.user_id = u._id) FROM users u",
+				"SELECT u._id FROM users u WHERE EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u._id AND " +
+					"EXISTS (SELECT 1 FROM users x WHERE x._id = o.user_id AND x.age > u.age))",
+			} {
+				_, err := NewStatement(sql).Build(NewQuery())
+				So(err, ShouldHaveSameTypeAs, &UnsupportedError{})
+			}
+		})
+	})
+}
@@ -24,7 +24,8 @@ func (statement *Statement) parseTable(q *Query, node sqlparser.TableName) *Quer
 setupQueryFromClause processes the FROM clause of a SQL query and configures
 the Query object with the appropriate collection and join information.
 It iterates through the table expressions and handles both simple table
-references and JOIN expressions.
+references and JOIN expressions. Comma-separated tables are not supported,
+since only JOIN conditions say how to look the other tables up.
 
 Parameters:
 - q: The Query object to modify
@@ -34,6 +35,10 @@ Returns:
 - The modified Query object
 */
 func (statement *Statement) setupQueryFromClause(q *Query, fromClauses []sqlparser.TableExpr) *Query {
+	if len(fromClauses) > 1 {
+		statement.unsupported(sqlparser.TableExprs(fromClauses), "")
+	}
+
 	for _, expr := range fromClauses {
 		if statement.handleFromExpr(q, expr) {
 			return q
@@ -62,6 +67,8 @@ func (statement *Statement) handleFromExpr(q *Query, expr sqlparser.TableExpr) b
 		return true
 	case *sqlparser.AliasedTableExpr:
 		statement.handleAliasedTable(q, exprType)
+	default:
+		statement.unsupported(expr, "")
 	}
 	return false
 }
@@ -77,41 +84,112 @@ Parameters:
 */
 func (statement *Statement) handleJoinExpr(q *Query, join *sqlparser.JoinTableExpr) {
 	q.Operation = "aggregate"
-	for _, expr := range []sqlparser.TableExpr{join.LeftExpr, join.RightExpr} {
-		if aliased, ok := expr.(*sqlparser.AliasedTableExpr); ok {
-			statement.setCollectionFromAlias(q, aliased)
-		}
-	}
+	statement.parseJoin(q, join)
 }
 
 /*
 handleAliasedTable processes an aliased table expression and sets the
-collection name in the Query object based on the table name.
+collection name in the Query object based on the table name. The table
+becomes the root of the statement, which qualified columns are resolved
+against.
 
 Parameters:
 - q: The Query object to modify
 - alias: The aliased table expression to process
 */
 func (statement *Statement) handleAliasedTable(q *Query, alias *sqlparser.AliasedTableExpr) {
-	if tableName, ok := alias.Expr.(sqlparser.TableName); ok {
-		if name := tableName.Name.CompliantName(); name != "" {
-			q.Collection = tableName.Name.String()
-		}
+	collection, name := tableName(alias)
+	if collection == "" {
+		statement.unsupported(alias, "")
+		return
 	}
+
+	q.Collection = collection
+	statement.tables.root = name
+	statement.tables.add(name, collection)
 }
 
 /*
-setCollectionFromAlias extracts the collection name from an aliased table
-expression and sets it in the Query object.
+tableName returns the collection an aliased table expression refers to,
+and the name it is referred to by in the rest of the statement: its alias,
+or the collection name when it has none.
 
 Parameters:
-- q: The Query object to modify
-- alias: The aliased table expression containing the collection name
+- alias: The aliased table expression
+
+Returns:
+- The collection name, or empty if the expression is not a table
+- The name the table is referred to by
 */
-func (statement *Statement) setCollectionFromAlias(q *Query, alias *sqlparser.AliasedTableExpr) {
-	if tableName, ok := alias.Expr.(*sqlparser.TableName); ok {
-		if name := tableName.Name.CompliantName(); name != "" {
-			q.Collection = tableName.Name.String()
-		}
+func tableName(alias *sqlparser.AliasedTableExpr) (string, string) {
+	table, ok := alias.Expr.(sqlparser.TableName)
+	if !ok || table.Name.IsEmpty() {
+		return "", ""
+	}
+
+	collection := table.Name.String()
+	if alias.As.IsEmpty() {
+		return collection, collection
+	}
+	return collection, alias.As.String()
+}
+
+/*
+tables records the tables of the FROM clause by the name they are referred
+to with. The documents of the root table are the rows the query runs on, and
+the documents of every joined table are nested in them under the joined
+table's name.
+*/
+type tables struct {
+	root        string            // The name of the table the query runs on
+	collections map[string]string // The collection of every table, keyed by name
+}
+
+/*
+newTables creates an empty set of tables.
+
+Returns:
+- A new tables instance
+*/
+func newTables() *tables {
+	return &tables{collections: make(map[string]string)}
+}
+
+/*
+add registers a table under the name it is referred to by.
+
+Parameters:
+- name: The alias of the table, or its collection name
+- collection: The collection the table reads from
+*/
+func (tables *tables) add(name, collection string) {
+	tables.collections[name] = collection
+}
+
+/*
+has reports whether a name refers to a table of the statement.
+*/
+func (tables *tables) has(name string) bool {
+	_, ok := tables.collections[name]
+	return ok
+}
+
+/*
+joined reports whether a name refers to a joined table, whose documents
+are nested under that name.
+*/
+func (tables *tables) joined(name string) bool {
+	_, ok := tables.collections[name]
+	return ok && name != tables.root
+}
+
+/*
+collection returns the collection a table name refers to, or the fallback
+if the name is not a table of the statement.
+*/
+func (tables *tables) collection(name, fallback string) string {
+	if collection, ok := tables.collections[name]; ok {
+		return collection
 	}
+	return fallback
 }
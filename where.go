@@ -2,7 +2,6 @@ package squeel
 
 import (
 	"regexp"
-	"strconv"
 	"strings"
 	"unicode"
 
@@ -21,7 +20,9 @@ var (
 /*
 parseWhere processes the WHERE clause of a SQL query and converts it into
 MongoDB query filters. It handles various types of conditions including
-comparisons, functions, AND/OR operations, and range conditions.
+comparisons, functions, AND/OR operations, and range conditions. HAVING
+clauses share the Where node type, but filter grouped rows, so they are
+left to the GROUP BY handling.
 
 Parameters:
 - q: The Query object to modify
@@ -31,7 +32,7 @@ Returns:
 - The modified Query object with filter conditions applied
 */
 func (statement *Statement) parseWhere(q *Query, node *sqlparser.Where) *Query {
-	if node == nil {
+	if node == nil || node.Type == sqlparser.HavingStr {
 		return q
 	}
 
@@ -42,7 +43,8 @@ func (statement *Statement) parseWhere(q *Query, node *sqlparser.Where) *Query {
 /*
 parseWhereExpr processes a single expression from the WHERE clause and converts
 it into appropriate MongoDB query filters. It handles different types of
-expressions including comparisons, functions, AND/OR operations, and ranges.
+expressions including comparisons, functions, AND/OR/NOT operations, ranges and
+EXISTS.
 
 Parameters:
 - q: The Query object to modify
@@ -62,29 +64,134 @@ func (statement *Statement) parseWhereExpr(q *Query, expr sqlparser.Expr) *Query
 			Right:    sqlparser.NewStrVal([]byte("true")),
 		})
 	case *sqlparser.AndExpr:
-		q = statement.parseWhereExpr(q, expr.Left)
-		q = statement.parseWhereExpr(q, expr.Right)
+		q = statement.parseAnd(q, expr)
 	case *sqlparser.OrExpr:
-		leftQ := statement.parseWhereExpr(NewQuery(), expr.Left)
-		rightQ := statement.parseWhereExpr(NewQuery(), expr.Right)
-		q.Filter = append(q.Filter, bson.E{Key: "$or", Value: []bson.M{leftQ.Filter.Map(), rightQ.Filter.Map()}})
+		q = statement.parseOr(q, expr)
+	case *sqlparser.ExistsExpr:
+		q = statement.parseExists(q, expr, false)
+	case *sqlparser.NotExpr:
+		if exists, ok := expr.Expr.(*sqlparser.ExistsExpr); ok {
+			return statement.parseExists(q, exists, true)
+		}
+		if filter := statement.parseSubExpr(q, expr.Expr); len(filter) > 0 {
+			q.Filter = append(q.Filter, bson.E{Key: "$nor", Value: bson.A{filter}})
+		}
 	case *sqlparser.ParenExpr:
 		q = statement.parseWhereExpr(q, expr.Expr)
 	case *sqlparser.RangeCond:
-		field := expr.Left.(*sqlparser.ColName).Name.CompliantName()
-		from, _ := strconv.Atoi(string(expr.From.(*sqlparser.SQLVal).Val))
-		to, _ := strconv.Atoi(string(expr.To.(*sqlparser.SQLVal).Val))
-		q.Filter = append(q.Filter, bson.E{Key: field, Value: bson.M{"$gte": from, "$lte": to}})
+		q = statement.parseRange(q, expr)
+	case *sqlparser.IsExpr:
+		q = statement.parseIs(q, expr)
 	default:
-		logDebug("Unhandled expression type in WHERE clause: %T", expr)
+		statement.unsupported(expr, "")
 	}
 	return q
 }
 
+/*
+parseSubExpr compiles an expression into a filter of its own, to be used as
+an operand of $and, $or or $nor. A negated conjunction, as in NOT (a AND b),
+stays a single document, so $nor excludes only the rows matching both
+conditions. Any other change the expression makes to the query, such as an
+added pipeline stage, is kept.
+
+Parameters:
+- q: The Query object being built
+- expr: The expression to compile
+
+Returns:
+- The filter of the expression
+*/
+func (statement *Statement) parseSubExpr(q *Query, expr sqlparser.Expr) bson.D {
+	filter := q.Filter
+
+	q.Filter = nil
+	sub := statement.parseWhereExpr(q, expr).Filter
+	q.Filter = filter
+
+	return sub
+}
+
+/*
+parseRange processes a BETWEEN condition on a column, converting it into an
+inclusive range filter. NOT BETWEEN matches values below or above the range.
+A range on a computed expression, or on a field without a dotted path, is
+compared with $expr.
+
+Parameters:
+- q: The Query object to modify
+- expr: The range condition to process
+
+Returns:
+- The modified Query object with the range filter applied
+*/
+func (statement *Statement) parseRange(q *Query, expr *sqlparser.RangeCond) *Query {
+	if col, ok := expr.Left.(*sqlparser.ColName); isComputed(expr.Left) || ok && !statement.plainColumn(col) {
+		return statement.parseWhereExpr(q, splitRange(expr))
+	}
+
+	col, isCol := expr.Left.(*sqlparser.ColName)
+	from, isFrom := statement.literal(expr.From)
+	to, isTo := statement.literal(expr.To)
+
+	if !isCol || !isFrom || !isTo || from == nil || to == nil {
+		statement.unsupported(expr, expr.Operator)
+		return q
+	}
+
+	if statement.isDateField(col, statement.tables.collection(statement.resolveColumn(col).table, q.Collection)) {
+		from, isFrom = dateValue(from)
+		to, isTo = dateValue(to)
+		if !isFrom || !isTo {
+			statement.unsupported(expr, expr.Operator)
+			return q
+		}
+	}
+
+	field := statement.fieldPath(col)
+
+	if expr.Operator == sqlparser.NotBetweenStr {
+		q.Filter = append(q.Filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: field, Value: bson.M{"$lt": from}}},
+			bson.D{{Key: field, Value: bson.M{"$gt": to}}},
+		}})
+		return q
+	}
+
+	q.Filter = append(q.Filter, bson.E{Key: field, Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lte", Value: to}}})
+	return q
+}
+
+/*
+splitRange rewrites a BETWEEN condition into the pair of comparisons it
+stands for, so an expression on its left side is compared with $expr.
+
+Parameters:
+- expr: The range condition to rewrite
+
+Returns:
+- The conjunction of the comparisons, or their disjunction for NOT BETWEEN
+*/
+func splitRange(expr *sqlparser.RangeCond) sqlparser.Expr {
+	if expr.Operator == sqlparser.NotBetweenStr {
+		return &sqlparser.OrExpr{
+			Left:  &sqlparser.ComparisonExpr{Left: expr.Left, Operator: sqlparser.LessThanStr, Right: expr.From},
+			Right: &sqlparser.ComparisonExpr{Left: expr.Left, Operator: sqlparser.GreaterThanStr, Right: expr.To},
+		}
+	}
+
+	return &sqlparser.AndExpr{
+		Left:  &sqlparser.ComparisonExpr{Left: expr.Left, Operator: sqlparser.GreaterEqualStr, Right: expr.From},
+		Right: &sqlparser.ComparisonExpr{Left: expr.Left, Operator: sqlparser.LessEqualStr, Right: expr.To},
+	}
+}
+
 /*
 parseComparison processes a comparison expression and converts it into a MongoDB
 filter condition. It handles different types of left-hand expressions including
-functions, columns, and values.
+functions, columns, and values. Comparisons with a column or expression on the
+right are evaluated with $expr, as is IN with a list holding a column or
+expression, and IN with a subquery becomes a semi-join.
 
 Parameters:
 - q: The Query object to modify
@@ -94,15 +201,42 @@ Returns:
 - The modified Query object with the comparison filter applied
 */
 func (statement *Statement) parseComparison(q *Query, expr *sqlparser.ComparisonExpr) *Query {
+	if _, ok := expr.Right.(*sqlparser.Subquery); ok {
+		return statement.parseInSubquery(q, expr)
+	}
+
+	if isExprComparison(expr) {
+		return statement.parseExprComparison(q, expr)
+	}
+
+	if (isComputed(expr.Left) || !isLiteralTuple(expr.Right)) && (expr.Operator == sqlparser.InStr || expr.Operator == sqlparser.NotInStr) {
+		condition, ok := statement.predicate(expr)
+		if !ok {
+			statement.unsupported(expr.Left, expr.Operator)
+			return q
+		}
+		q.Filter = appendExpr(q.Filter, condition)
+		return q
+	}
+
 	switch left := expr.Left.(type) {
 	case *sqlparser.FuncExpr:
 		return statement.handleFuncComparison(q, left)
 	case *sqlparser.ColName:
+		if !statement.plainColumn(left) && isValidOperator(expr.Operator) {
+			return statement.parseExprComparison(q, expr)
+		}
 		return statement.handleColumnComparison(q, left, expr)
 	case *sqlparser.SQLVal:
 		return statement.handleValueComparison(q, left, expr)
+	case *sqlparser.CollateExpr:
+		col, ok := left.Expr.(*sqlparser.ColName)
+		if ok && (expr.Operator == sqlparser.LikeStr || expr.Operator == sqlparser.NotLikeStr) {
+			return statement.handleColumnComparison(q, col, expr)
+		}
+		statement.unsupported(left, expr.Operator)
 	default:
-		logDebug("Unhandled left expression type in comparison: %T with value %v", left, left)
+		statement.unsupported(left, expr.Operator)
 	}
 	return q
 }
@@ -123,36 +257,11 @@ func (statement *Statement) handleFuncComparison(q *Query, expr *sqlparser.FuncE
 	switch expr.Name.Lowered() {
 	case "array_contains":
 		return statement.handleArrayContains(q, expr)
-	case "count", "avg", "sum", "min", "max":
-		// These are aggregate functions - they should be handled in HAVING clause
-		q.Operation = "aggregate"
-		if len(expr.Exprs) > 0 {
-			if colExpr := statement.getColumnFromAliasedExpr(expr.Exprs[0]); colExpr != nil {
-				field := colExpr.Name.CompliantName()
-				operator := "$" + expr.Name.Lowered()
-				if expr.Name.Lowered() == "count" {
-					operator = "$sum"
-					q.Pipeline = append(q.Pipeline, bson.D{{
-						Key: "$group",
-						Value: bson.D{
-							{Key: "_id", Value: nil},
-							{Key: field, Value: bson.M{operator: 1}},
-						},
-					}})
-				} else {
-					q.Pipeline = append(q.Pipeline, bson.D{{
-						Key: "$group",
-						Value: bson.D{
-							{Key: "_id", Value: nil},
-							{Key: field, Value: bson.M{operator: "$" + field}},
-						},
-					}})
-				}
-			}
-		}
-		return q
+	case "regexp_like":
+		return statement.handleRegexpLike(q, expr)
 	default:
-		logDebug("where.handleFuncComparison: Unhandled function: %s", expr.Name.String())
+		// Aggregate functions can only be filtered on in the HAVING clause
+		statement.unsupported(expr, expr.Name.String())
 		return q
 	}
 }
@@ -171,14 +280,23 @@ Returns:
 */
 func (statement *Statement) handleArrayContains(q *Query, expr *sqlparser.FuncExpr) *Query {
 	if len(expr.Exprs) != 2 {
-		logDebug("ARRAY_CONTAINS requires 2 arguments, but got %d", len(expr.Exprs))
+		statement.unsupported(expr, expr.Name.String())
+		return q
+	}
+
+	col := statement.getColumnFromAliasedExpr(expr.Exprs[0])
+	val, isVal := sqlVal(expr.Exprs[1])
+	if col == nil || !isVal {
+		statement.unsupported(expr, expr.Name.String())
 		return q
 	}
 
-	field := expr.Exprs[0].(*sqlparser.AliasedExpr).Expr.(*sqlparser.ColName).Name.CompliantName()
-	value := expr.Exprs[1].(*sqlparser.AliasedExpr).Expr.(*sqlparser.SQLVal).Val
+	field, ok := statement.filterField(col)
+	if !ok {
+		return q
+	}
 
-	parsedVal, err := statement.parseID(string(value), q.Collection)
+	parsedVal, err := statement.parseIDValue(statement.parseValue(val), q.Collection)
 	if err != nil {
 		logDebug("Error parsing ID for ARRAY_CONTAINS: %v", err)
 		return q
@@ -192,7 +310,9 @@ func (statement *Statement) handleArrayContains(q *Query, expr *sqlparser.FuncEx
 /*
 handleColumnComparison processes column-based comparisons, converting them into
 appropriate MongoDB filter conditions. It handles various comparison operators
-and special cases for ID fields.
+and special cases for ID fields, which are converted according to the
+collection of the table the column belongs to, so ids of a joined uppercase
+collection become Binary even when the query runs on a lowercase one.
 
 Parameters:
 - q: The Query object to modify
@@ -203,13 +323,35 @@ Returns:
 - The modified Query object with the column comparison filter applied
 */
 func (statement *Statement) handleColumnComparison(q *Query, col *sqlparser.ColName, expr *sqlparser.ComparisonExpr) *Query {
-	field := strings.TrimPrefix(strings.Join([]string{col.Qualifier.Name.String(), col.Name.String()}, "."), ".")
-	value, ok := statement.parseComparisonRight(expr.Right, q.Collection)
+	field, ok := statement.filterField(col)
 	if !ok {
 		return q
 	}
+	collection := statement.tables.collection(statement.resolveColumn(col).table, q.Collection)
 
-	return statement.applyFilter(q, field, expr.Operator, value)
+	value, ok := statement.parseComparisonRight(expr.Right, collection)
+	if !ok {
+		statement.unsupported(expr, expr.Operator)
+		return q
+	}
+
+	if isIDField(col.Name.String(), collection) {
+		parsedVal, err := statement.parseIDValue(value, collection)
+		if err != nil {
+			logDebug("Error parsing ID: %v", err)
+			return q
+		}
+		value = parsedVal
+	}
+
+	if statement.isDateField(col, collection) {
+		if value, ok = dateValue(value); !ok {
+			statement.unsupported(expr.Right, expr.Operator)
+			return q
+		}
+	}
+
+	return statement.applyFilter(q, expr, field, value)
 }
 
 /*
@@ -225,30 +367,14 @@ Returns:
 */
 func (statement *Statement) parseComparisonRight(right sqlparser.Expr, _ string) (interface{}, bool) {
 	switch right := right.(type) {
-	case *sqlparser.SQLVal:
-		return string(right.Val), true
-	case *sqlparser.ColName:
-		return statement.getQualifiedName(right), true
-	case *sqlparser.ValTuple:
+	case *sqlparser.SQLVal, *sqlparser.NullVal, sqlparser.BoolVal, *sqlparser.ConvertExpr:
+		return statement.literal(right)
+	case sqlparser.ValTuple:
 		return statement.parseValTupleValues(right)
 	}
 	return nil, false
 }
 
-/*
-getQualifiedName builds a fully qualified column name from a ColName node,
-including any table qualifier if present.
-
-Parameters:
-- col: The column name node
-
-Returns:
-- The fully qualified column name as a string
-*/
-func (statement *Statement) getQualifiedName(col *sqlparser.ColName) string {
-	return strings.TrimPrefix(strings.Join([]string{col.Qualifier.Name.String(), col.Name.String()}, "."), ".")
-}
-
 /*
 parseValTupleValues processes a tuple of values (as used in IN clauses) and
 converts them into a slice of MongoDB-compatible values.
@@ -257,57 +383,45 @@ Parameters:
 - tuple: The tuple of values to parse
 
 Returns:
-- A slice of parsed values and whether parsing was successful
+- A slice of parsed values and whether every value could be parsed
 */
-func (statement *Statement) parseValTupleValues(tuple *sqlparser.ValTuple) ([]interface{}, bool) {
-	values := make([]interface{}, 0, len(*tuple))
-	for _, val := range *tuple {
-		if value := statement.parseTupleValue(val); value != nil {
-			values = append(values, value)
+func (statement *Statement) parseValTupleValues(tuple sqlparser.ValTuple) ([]interface{}, bool) {
+	values := make([]interface{}, 0, len(tuple))
+	for _, val := range tuple {
+		value, ok := statement.literal(val)
+		if !ok {
+			return nil, false
 		}
+		values = append(values, value)
 	}
 	return values, true
 }
 
 /*
-parseTupleValue processes a single value from a tuple, converting it into
-a MongoDB-compatible value.
+literal converts a SQL literal into its Go/MongoDB type. Numbers keep the
+int64 or float64 type parseValue gives them, TRUE and FALSE become bools,
+NULL becomes nil and DATE and TIMESTAMP literals become a time.Time.
 
 Parameters:
-- val: The expression to parse
+- expr: The literal to convert
 
 Returns:
-- The parsed value, or nil if parsing failed
+- The converted value
+- Whether the expression is a literal of a known type
 */
-func (statement *Statement) parseTupleValue(val sqlparser.Expr) interface{} {
-	switch v := val.(type) {
+func (statement *Statement) literal(expr sqlparser.Expr) (interface{}, bool) {
+	switch expr := expr.(type) {
 	case *sqlparser.SQLVal:
-		return statement.parseSQLValue(v)
-	case *sqlparser.ColName:
-		return statement.getQualifiedName(v)
-	default:
-		return nil
-	}
-}
-
-/*
-parseSQLValue converts a SQL value into its appropriate Go/MongoDB type.
-Currently handles integer values specially, with other types defaulting to strings.
-
-Parameters:
-- val: The SQL value to parse
-
-Returns:
-- The parsed value in its appropriate Go type
-*/
-func (statement *Statement) parseSQLValue(val *sqlparser.SQLVal) interface{} {
-	switch val.Type {
-	case sqlparser.IntVal:
-		if num, err := strconv.Atoi(string(val.Val)); err == nil {
-			return num
-		}
+		value := statement.parseValue(expr)
+		return value, value != nil
+	case sqlparser.BoolVal:
+		return bool(expr), true
+	case *sqlparser.NullVal:
+		return nil, true
+	case *sqlparser.ConvertExpr:
+		return parseDateLiteral(expr)
 	}
-	return string(val.Val)
+	return nil, false
 }
 
 /*
@@ -323,16 +437,16 @@ Returns:
 - The modified Query object with the value comparison filter applied
 */
 func (statement *Statement) handleValueComparison(q *Query, val *sqlparser.SQLVal, expr *sqlparser.ComparisonExpr) *Query {
-	if expr.Operator != "in" {
+	colName, ok := expr.Right.(*sqlparser.ColName)
+	if expr.Operator != sqlparser.InStr || !ok {
+		statement.unsupported(expr, expr.Operator)
 		return q
 	}
 
-	colName, ok := expr.Right.(*sqlparser.ColName)
+	field, ok := statement.filterField(colName)
 	if !ok {
 		return q
 	}
-
-	field := colName.Name.CompliantName()
 	parsedVal, err := statement.parseID(string(val.Val), q.Collection)
 	if err != nil {
 		logDebug("Error parsing ID for IN clause: %v", err)
@@ -345,35 +459,25 @@ func (statement *Statement) handleValueComparison(q *Query, val *sqlparser.SQLVa
 
 /*
 applyFilter adds a filter condition to the Query based on the field, operator,
-and value provided. It handles special cases for ID fields and supports various
-MongoDB comparison operators.
+and value provided. It supports various MongoDB comparison operators.
 
 Parameters:
 - q: The Query object to modify
+- expr: The comparison expression, whose operator is applied
 - field: The field name to filter on
-- operator: The comparison operator to use
 - value: The value to compare against
 
 Returns:
 - The modified Query object with the filter applied
 */
-func (statement *Statement) applyFilter(q *Query, field, operator string, value interface{}) *Query {
+func (statement *Statement) applyFilter(q *Query, expr *sqlparser.ComparisonExpr, field string, value interface{}) *Query {
 	var filter bson.E
 
-	if isIDField(field, q.Collection) {
-		parsedVal, err := statement.parseID(value.(string), q.Collection)
-		if err != nil {
-			logDebug("Error parsing ID: %v", err)
-			return q
-		}
-		value = parsedVal
-	}
-
-	switch operator {
+	switch expr.Operator {
 	case "=":
 		filter = bson.E{Key: field, Value: value}
 	case "!=":
-		filter = bson.E{Key: field, Value: bson.M{"$ne": value}}
+		filter = bson.E{Key: field, Value: statement.notEqual(value)}
 	case ">":
 		filter = bson.E{Key: field, Value: bson.M{"$gt": value}}
 	case ">=":
@@ -382,15 +486,37 @@ func (statement *Statement) applyFilter(q *Query, field, operator string, value
 		filter = bson.E{Key: field, Value: bson.M{"$lt": value}}
 	case "<=":
 		filter = bson.E{Key: field, Value: bson.M{"$lte": value}}
-	case "like":
-		regex := strings.ReplaceAll(strings.ReplaceAll(value.(string), "%", ".*"), "_", ".")
-		filter = bson.E{Key: field, Value: bson.M{"$regex": regex, "$options": "i"}}
-	case "in":
-		if values, ok := value.([]interface{}); ok {
-			filter = bson.E{Key: field, Value: bson.M{"$in": values}}
+	case sqlparser.LikeStr, sqlparser.NotLikeStr:
+		pattern, ok := value.(string)
+		if !ok {
+			statement.unsupported(expr, expr.Operator)
+			return q
+		}
+		condition, ok := statement.likeFilter(expr, pattern)
+		if !ok {
+			statement.unsupported(expr, expr.Operator)
+			return q
+		}
+		filter = bson.E{Key: field, Value: condition}
+	case sqlparser.RegexpStr, sqlparser.NotRegexpStr:
+		pattern, ok := value.(string)
+		if !ok {
+			statement.unsupported(expr, expr.Operator)
+			return q
+		}
+		filter = bson.E{Key: field, Value: statement.regexFilter(expr.Operator, pattern, "")}
+	case sqlparser.InStr, sqlparser.NotInStr:
+		values, ok := value.([]interface{})
+		if !ok {
+			statement.unsupported(expr, expr.Operator)
+			return q
+		}
+		filter = bson.E{Key: field, Value: bson.M{"$in": values}}
+		if expr.Operator == sqlparser.NotInStr {
+			filter = bson.E{Key: field, Value: statement.notIn(values)}
 		}
 	default:
-		logDebug("Unhandled operator in comparison: %s", operator)
+		statement.unsupported(expr, expr.Operator)
 		return q
 	}
 
@@ -431,6 +557,54 @@ func (statement *Statement) parseID(value string, collection string) (interface{
 	return value, nil
 }
 
+/*
+parseIDValue converts the value an ID field is compared with, converting
+every UUID in a list of values as well.
+
+Parameters:
+- value: The string or list of values to convert
+- collection: The collection the ID field belongs to
+
+Returns:
+- The converted value
+- Any error that occurred during conversion
+*/
+func (statement *Statement) parseIDValue(value interface{}, collection string) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		return statement.parseID(value, collection)
+	case []interface{}:
+		ids := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			id, err := statement.parseIDValue(item, collection)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	}
+	return value, nil
+}
+
+/*
+sqlVal extracts a literal value from a function argument if it is one.
+
+Parameters:
+- expr: The function argument
+
+Returns:
+- The literal value
+- Whether the argument is a literal
+*/
+func sqlVal(expr sqlparser.SelectExpr) (*sqlparser.SQLVal, bool) {
+	if aliased, ok := expr.(*sqlparser.AliasedExpr); ok {
+		val, ok := aliased.Expr.(*sqlparser.SQLVal)
+		return val, ok
+	}
+	return nil, false
+}
+
 func Map(d bson.D) bson.M {
 	m := bson.M{}
 	for _, e := range d {
@@ -0,0 +1,51 @@
+package squeel
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLiterals(t *testing.T) {
+	Convey("Given SQL comparing with literals", t, func() {
+		evaluator := newEvaluator(map[string][]interface{}{
+			"users": {
+				bson.D{{Key: "_id", Value: 1}, {Key: "age", Value: 31}, {Key: "score", Value: 1.75}, {Key: "active", Value: true}},
+				bson.D{{Key: "_id", Value: 2}, {Key: "age", Value: 17}, {Key: "score", Value: 2.5}, {Key: "active", Value: false}},
+				bson.D{{Key: "_id", Value: 3}, {Key: "age", Value: 45.5}, {Key: "score", Value: 3}, {Key: "active", Value: nil}},
+			},
+		})
+
+		Convey("It should compare with numbers, not their text", func() {
+			So(build("SELECT _id FROM users WHERE age > 21 AND score <= 2.5 AND name = '21'").Filter, ShouldResemble, bson.D{
+				{Key: "age", Value: bson.M{"$gt": int64(21)}},
+				{Key: "score", Value: bson.M{"$lte": 2.5}},
+				{Key: "name", Value: "21"},
+			})
+			So(ids(evaluator, "SELECT _id FROM users WHERE age > 21"), ShouldResemble, []interface{}{int32(1), int32(3)})
+		})
+
+		Convey("It should type the bounds of BETWEEN", func() {
+			So(build("SELECT _id FROM users WHERE score BETWEEN 1.5 AND 2.5").Filter, ShouldResemble, bson.D{
+				{Key: "score", Value: bson.D{{Key: "$gte", Value: 1.5}, {Key: "$lte", Value: 2.5}}},
+			})
+			So(ids(evaluator, "SELECT _id FROM users WHERE score BETWEEN 1.5 AND 2.5"), ShouldResemble, []interface{}{int32(1), int32(2)})
+			So(ids(evaluator, "SELECT _id FROM users WHERE age NOT BETWEEN 17.5 AND 45"), ShouldResemble, []interface{}{int32(2), int32(3)})
+		})
+
+		Convey("It should type the values of IN", func() {
+			So(build("SELECT _id FROM users WHERE age IN (17, 45.5, 'x', NULL)").Filter, ShouldResemble, bson.D{
+				{Key: "age", Value: bson.M{"$in": []interface{}{int64(17), 45.5, "x", nil}}},
+			})
+			So(ids(evaluator, "SELECT _id FROM users WHERE age IN (17, 45.5)"), ShouldResemble, []interface{}{int32(2), int32(3)})
+		})
+
+		Convey("It should map TRUE, FALSE and NULL", func() {
+			So(build("SELECT _id FROM users WHERE active = true").Filter, ShouldResemble, bson.D{{Key: "active", Value: true}})
+			So(ids(evaluator, "SELECT _id FROM users WHERE active = true"), ShouldResemble, []interface{}{int32(1)})
+			So(ids(evaluator, "SELECT _id FROM users WHERE active != false"), ShouldResemble, []interface{}{int32(1), int32(3)})
+			So(build("SELECT _id FROM users WHERE active = NULL").Filter, ShouldResemble, bson.D{{Key: "active", Value: nil}})
+		})
+	})
+}